@@ -0,0 +1,303 @@
+package api
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Ward-R/Jishin-API/db"
+	"github.com/Ward-R/Jishin-API/types"
+)
+
+// capSender identifies this API as the sender of CAP messages.
+const capSender = "jishin-api"
+
+// capTimeLayout is the CAP date-time form, which always carries an offset.
+const capTimeLayout = "2006-01-02T15:04:05-07:00"
+
+// defaultCAPMinIntensity is the weakest intensity included in the CAP feed
+// unless CAP_MIN_INTENSITY or ?min_intensity= says otherwise.
+const defaultCAPMinIntensity = "4"
+
+type capAlert struct {
+	XMLName    xml.Name `xml:"urn:oasis:names:tc:emergency:cap:1.2 alert"`
+	Identifier string   `xml:"identifier"`
+	Sender     string   `xml:"sender"`
+	Sent       string   `xml:"sent"`
+	Status     string   `xml:"status"`
+	MsgType    string   `xml:"msgType"`
+	Scope      string   `xml:"scope"`
+	// References lists the earlier alerts an Update or Cancel replaces, as
+	// space-separated sender,identifier,sent triples.
+	References string    `xml:"references,omitempty"`
+	Info       []capInfo `xml:"info"`
+}
+
+type capInfo struct {
+	Language     string         `xml:"language"`
+	Category     string         `xml:"category"`
+	Event        string         `xml:"event"`
+	ResponseType string         `xml:"responseType"`
+	Urgency      string         `xml:"urgency"`
+	Severity     string         `xml:"severity"`
+	Certainty    string         `xml:"certainty"`
+	Onset        string         `xml:"onset,omitempty"`
+	Expires      string         `xml:"expires,omitempty"`
+	SenderName   string         `xml:"senderName"`
+	Headline     string         `xml:"headline"`
+	Description  string         `xml:"description"`
+	Web          string         `xml:"web,omitempty"`
+	Parameters   []capParameter `xml:"parameter"`
+	Area         capArea        `xml:"area"`
+}
+
+type capParameter struct {
+	ValueName string `xml:"valueName"`
+	Value     string `xml:"value"`
+}
+
+type capArea struct {
+	AreaDesc string `xml:"areaDesc"`
+	Circle   string `xml:"circle,omitempty"`
+}
+
+// capSeverity maps the JMA max intensity to CAP severity. A tsunami warning or
+// advisory makes any earthquake at least Severe.
+func capSeverity(eq *types.Earthquake) string {
+	rank := types.IntensityRank(eq.MaxIntensity)
+	severity := "Unknown"
+	switch {
+	case rank >= types.IntensityRank("6-"):
+		severity = "Extreme"
+	case rank >= types.IntensityRank("5-"):
+		severity = "Severe"
+	case rank >= types.IntensityRank("4"):
+		severity = "Moderate"
+	case rank >= 0:
+		severity = "Minor"
+	}
+	if eq.TsunamiWarning && (severity == "Unknown" || severity == "Minor" || severity == "Moderate") {
+		severity = "Severe"
+	}
+	return severity
+}
+
+// capUrgency is Past once a day has gone by, Immediate for strong shaking or a
+// tsunami warning, and Expected (aftershocks) otherwise.
+func capUrgency(eq *types.Earthquake, now time.Time) string {
+	switch {
+	case now.Sub(eq.OriginTime) > 24*time.Hour:
+		return "Past"
+	case eq.TsunamiWarning || types.IntensityRank(eq.MaxIntensity) >= types.IntensityRank("5-"):
+		return "Immediate"
+	default:
+		return "Expected"
+	}
+}
+
+// capResponseType is Evacuate while a tsunami warning or advisory is in
+// effect. A possible or slight sea-level change only calls for Monitor.
+func capResponseType(eq *types.Earthquake) string {
+	if eq.TsunamiWarning {
+		return "Evacuate"
+	}
+	return "Monitor"
+}
+
+// capMsgType is Cancel once JMA cancels the event, Update when earlier reports
+// were issued for it (or this one corrects them) and Alert for the first.
+func capMsgType(eq *types.Earthquake, earlier []types.Revision) string {
+	switch {
+	case eq.Retracted || eq.InfoType == types.InfoTypeCancelled:
+		return "Cancel"
+	case eq.InfoType == types.InfoTypeCorrection || len(earlier) > 0:
+		return "Update"
+	default:
+		return "Alert"
+	}
+}
+
+// capSent is when the alert for a report was sent: the report time, or the
+// origin time for rows synced before report times were kept.
+func capSent(reportTime, originTime time.Time) time.Time {
+	if reportTime.IsZero() {
+		return originTime
+	}
+	return reportTime
+}
+
+// capIdentifier names the alert for one JMA report. Serials restart for each
+// report kind, so the report time tells the reports of an event apart.
+func capIdentifier(reportID string, sent time.Time) string {
+	return fmt.Sprintf("%s-%s-%s", capSender, reportID, sent.In(jst).Format("20060102150405"))
+}
+
+// capEarlierRevisions returns the reports issued for eq before the one it
+// reflects, oldest first.
+func capEarlierRevisions(eq *types.Earthquake, revisions []types.Revision) []types.Revision {
+	var earlier []types.Revision
+	for _, rev := range revisions {
+		if rev.ReportDateTime.Before(eq.ReportDateTime) {
+			earlier = append(earlier, rev)
+		}
+	}
+	return earlier
+}
+
+// capReferences lists the alerts for the earlier reports in the form the CAP
+// references element takes.
+func capReferences(earlier []types.Revision) string {
+	refs := make([]string, 0, len(earlier))
+	for _, rev := range earlier {
+		sent := capSent(rev.ReportDateTime, rev.OriginTime)
+		refs = append(refs, fmt.Sprintf("%s,%s,%s", capSender, capIdentifier(rev.EventId, sent), sent.In(jst).Format(capTimeLayout)))
+	}
+	return strings.Join(refs, " ")
+}
+
+// capRadiusKm roughly estimates how far from the epicenter shaking is felt,
+// growing tenfold every two magnitude units, for the alert's circle area.
+func capRadiusKm(magnitude float64) float64 {
+	radius := math.Pow(10, 0.5*magnitude-0.5)
+	return math.Max(10, math.Min(1000, math.Round(radius)))
+}
+
+// newCAPAlert builds a CAP 1.2 alert with Japanese and English info blocks.
+// revisions is the event's report history, from which an Update or Cancel
+// references the alerts it replaces.
+func newCAPAlert(eq *types.Earthquake, revisions []types.Revision, base string, now time.Time) capAlert {
+	sent := capSent(eq.ReportDateTime, eq.OriginTime)
+	earlier := capEarlierRevisions(eq, revisions)
+	alert := capAlert{
+		Identifier: capIdentifier(eq.ReportId, sent),
+		Sender:     capSender,
+		Sent:       sent.In(jst).Format(capTimeLayout),
+		Status:     "Actual",
+		MsgType:    capMsgType(eq, earlier),
+		Scope:      "Public",
+		References: capReferences(earlier),
+	}
+
+	area := capArea{AreaDesc: eq.JpLocation}
+	if eq.Latitude != 0 || eq.Longitude != 0 {
+		area.Circle = fmt.Sprintf("%g,%g %g", eq.Latitude, eq.Longitude, capRadiusKm(eq.Magnitude))
+	}
+	parameters := []capParameter{
+		{ValueName: "MaxIntensity", Value: eq.MaxIntensity},
+		{ValueName: "Magnitude", Value: fmt.Sprint(eq.Magnitude)},
+		{ValueName: "DepthKm", Value: fmt.Sprint(eq.DepthKm)},
+		{ValueName: "Tsunami", Value: fmt.Sprint(eq.Tsunami)},
+	}
+	info := capInfo{
+		Category:     "Geo",
+		ResponseType: capResponseType(eq),
+		Urgency:      capUrgency(eq, now),
+		Severity:     capSeverity(eq),
+		Certainty:    "Observed",
+		Onset:        eq.OriginTime.In(jst).Format(capTimeLayout),
+		Expires:      eq.OriginTime.Add(24 * time.Hour).In(jst).Format(capTimeLayout),
+		Web:          base + "/earthquake/" + eq.ReportId,
+		Parameters:   parameters,
+		Area:         area,
+	}
+
+	ja := info
+	ja.Language = "ja-JP"
+	ja.Event = "地震"
+	ja.SenderName = "気象庁"
+	ja.Headline = fmt.Sprintf("%s M%.1f 最大震度%s", eq.JpLocation, eq.Magnitude, eq.MaxIntensity)
+	ja.Description = strings.TrimSpace(fmt.Sprintf("%s頃、%sを震源とする地震がありました。最大震度%s、マグニチュード%.1f、深さ約%dkm。\n%s",
+		eq.OriginTime.In(jst).Format("2006年1月2日15時04分"), eq.JpLocation, eq.MaxIntensity, eq.Magnitude, eq.DepthKm, eq.JpComment))
+
+	en := info
+	en.Language = "en-US"
+	en.Event = "Earthquake"
+	en.SenderName = "Japan Meteorological Agency"
+	en.Headline = fmt.Sprintf("M%.1f earthquake – %s, max intensity %s", eq.Magnitude, eq.EnLocation, eq.MaxIntensity)
+	en.Description = strings.TrimSpace(fmt.Sprintf("An earthquake occurred at %s near %s. Maximum seismic intensity %s, magnitude %.1f, depth about %d km.\n%s",
+		eq.OriginTime.In(jst).Format("2006-01-02 15:04 MST"), eq.EnLocation, eq.MaxIntensity, eq.Magnitude, eq.DepthKm, eq.EnComment))
+	en.Area.AreaDesc = eq.EnLocation
+
+	alert.Info = []capInfo{ja, en}
+	return alert
+}
+
+// capResponse renders a single earthquake as a CAP alert.
+func capResponse(store db.EarthquakeStore, request *Request, eq *types.Earthquake) (*Response, error) {
+	revisions, err := store.GetRevisions(request.Context(), eq.ReportId, true)
+	if err != nil {
+		return nil, Internal("Error fetching earthquake revisions", err)
+	}
+	return xmlResponse(formatMediaTypes[FormatCAP], newCAPAlert(eq, revisions, publicBaseURL(request), time.Now()))
+}
+
+// capMinIntensity is the CAP feed threshold from CAP_MIN_INTENSITY, falling
+// back to defaultCAPMinIntensity when unset or invalid.
+func capMinIntensity() string {
+	if intensity, ok := types.NormalizeIntensity(os.Getenv("CAP_MIN_INTENSITY")); ok {
+		return intensity
+	}
+	return defaultCAPMinIntensity
+}
+
+// HandleCAPFeed serves /feeds/cap.atom, an Atom index of CAP alerts for the
+// latest earthquakes at or above the intensity threshold. Each entry links to
+// the alert at /earthquake/{id}?format=cap.
+func HandleCAPFeed(store db.EarthquakeStore, request *Request) (*Response, error) {
+	// Apply the default threshold to a copy, leaving the caller's query as sent
+	query := map[string]string{}
+	for k, v := range request.Query {
+		query[k] = v
+	}
+	if query["min_intensity"] == "" {
+		query["min_intensity"] = capMinIntensity()
+	}
+	feedRequest := *request
+	feedRequest.Query = query
+	earthquakes, err := feedEarthquakes(store, &feedRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	base := publicBaseURL(request)
+	now := time.Now()
+	feed := atomFeed{
+		ID:      feedTagAuthority + "feeds/cap",
+		Title:   "Jishin API – CAP 地震警報 / CAP earthquake alerts",
+		Updated: feedUpdated(earthquakes).UTC().Format(time.RFC3339),
+		Author:  atomAuthor{Name: "Japan Meteorological Agency (JMA)"},
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: base + request.Path + feedQuery(request)},
+		},
+		Generator: "Jishin API",
+	}
+	ids := make([]string, len(earthquakes))
+	for i := range earthquakes {
+		ids[i] = earthquakes[i].ReportId
+	}
+	revisions, err := store.GetEventRevisions(request.Context(), ids)
+	if err != nil {
+		return nil, Internal("Error fetching earthquake revisions", err)
+	}
+	for i := range earthquakes {
+		eq := &earthquakes[i]
+		alert := newCAPAlert(eq, revisions[eq.ReportId], base, now)
+		// A cancelled alert is only served with include_retracted
+		href := base + "/earthquake/" + eq.ReportId + "?format=cap"
+		if eq.Retracted {
+			href += "&include_retracted=true"
+		}
+		feed.Entries = append(feed.Entries, atomEntry{
+			ID:        feedTagAuthority + "cap/" + alert.Identifier,
+			Title:     alert.Info[1].Headline,
+			Updated:   entryUpdated(eq).UTC().Format(time.RFC3339),
+			Published: eq.OriginTime.UTC().Format(time.RFC3339),
+			Link:      atomLink{Rel: "alternate", Type: formatMediaTypes[FormatCAP], Href: href},
+			Summary:   fmt.Sprintf("%s / %s / %s", alert.Info[1].Severity, alert.Info[1].Urgency, alert.Info[1].Certainty),
+		})
+	}
+	return xmlResponse("application/atom+xml; charset=utf-8", feed)
+}
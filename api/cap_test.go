@@ -0,0 +1,134 @@
+package api
+
+import (
+	"context"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Ward-R/Jishin-API/db"
+	"github.com/Ward-R/Jishin-API/types"
+)
+
+func TestCAPAlertRevisions(t *testing.T) {
+	origin := time.Date(2025, 8, 12, 2, 34, 0, 0, time.UTC)
+	flash := types.Revision{EventId: "q1", Serial: 1, InfoType: types.InfoTypeIssued, ReportDateTime: origin.Add(2 * time.Minute)}
+	full := types.Revision{EventId: "q1", Serial: 1, InfoType: types.InfoTypeIssued, ReportDateTime: origin.Add(4 * time.Minute)}
+	eq := &types.Earthquake{ReportId: "q1", OriginTime: origin, MaxIntensity: "3", Serial: 1, InfoType: types.InfoTypeIssued}
+
+	eq.ReportDateTime = flash.ReportDateTime
+	first := newCAPAlert(eq, []types.Revision{flash}, "", origin)
+	if first.MsgType != "Alert" || first.References != "" {
+		t.Errorf("first report = %s referencing %q, want an Alert without references", first.MsgType, first.References)
+	}
+
+	// The full report restarts at serial 1 but is a different message
+	eq.ReportDateTime = full.ReportDateTime
+	update := newCAPAlert(eq, []types.Revision{flash, full}, "", origin)
+	if update.Identifier == first.Identifier {
+		t.Errorf("both reports have identifier %s", update.Identifier)
+	}
+	wantRef := capSender + "," + first.Identifier + "," + first.Sent
+	if update.MsgType != "Update" || update.References != wantRef {
+		t.Errorf("second report = %s referencing %q, want an Update referencing %q", update.MsgType, update.References, wantRef)
+	}
+
+	eq.Retracted = true
+	eq.InfoType = types.InfoTypeCancelled
+	eq.ReportDateTime = origin.Add(10 * time.Minute)
+	cancel := newCAPAlert(eq, []types.Revision{flash, full}, "", origin)
+	if cancel.MsgType != "Cancel" || strings.Count(cancel.References, capSender+",") != 2 {
+		t.Errorf("cancellation = %s referencing %q, want a Cancel referencing both reports", cancel.MsgType, cancel.References)
+	}
+}
+
+func TestCAPAlertTsunami(t *testing.T) {
+	origin := time.Now()
+	tests := []struct {
+		name         string
+		eq           types.Earthquake
+		responseType string
+		severity     string
+	}{
+		{"no tsunami", types.Earthquake{MaxIntensity: "3"}, "Monitor", "Minor"},
+		{"slight sea-level change", types.Earthquake{MaxIntensity: "3", Tsunami: true}, "Monitor", "Minor"},
+		{"tsunami warning", types.Earthquake{MaxIntensity: "3", Tsunami: true, TsunamiWarning: true}, "Evacuate", "Severe"},
+	}
+	for _, tt := range tests {
+		tt.eq.OriginTime = origin
+		info := newCAPAlert(&tt.eq, nil, "", origin).Info[1]
+		if info.ResponseType != tt.responseType || info.Severity != tt.severity {
+			t.Errorf("%s: response type %s, severity %s; want %s, %s", tt.name, info.ResponseType, info.Severity, tt.responseType, tt.severity)
+		}
+	}
+}
+
+func TestCAPEndpoints(t *testing.T) {
+	store := seed(t, 2)
+	ctx := context.Background()
+	eq, _ := store.GetEarthquakeById(ctx, "q1", false)
+	for _, offset := range []time.Duration{-time.Minute, 0} {
+		rev := types.Revision{EventId: "q1", Serial: 1, InfoType: types.InfoTypeIssued, ReportDateTime: eq.ReportDateTime.Add(offset)}
+		if err := store.InsertRevision(ctx, &rev); err != nil {
+			t.Fatalf("InsertRevision: %v", err)
+		}
+	}
+
+	response := get(t, store, "/earthquake/q1", map[string]string{"format": "cap"})
+	var alert capAlert
+	if err := xml.Unmarshal([]byte(response.Body), &alert); err != nil {
+		t.Fatalf("GET /earthquake/q1?format=cap = %d %s: %v", response.StatusCode, response.Body, err)
+	}
+	if alert.MsgType != "Update" || alert.References == "" || len(alert.Info) != 2 {
+		t.Errorf("alert = %+v, want an Update with references and two info blocks", alert)
+	}
+
+	query := map[string]string{}
+	response = get(t, store, "/feeds/cap.atom", query)
+	if response.StatusCode != 200 || !strings.Contains(response.Body, "<feed") {
+		t.Errorf("GET /feeds/cap.atom = %d %s", response.StatusCode, response.Body)
+	}
+	if len(query) != 0 {
+		t.Errorf("the CAP feed changed the request query to %v", query)
+	}
+}
+
+// revisionCounter counts the per-event revision lookups made through it.
+type revisionCounter struct {
+	*db.MemoryStore
+	lookups int
+}
+
+func (s *revisionCounter) GetRevisions(ctx context.Context, eventID string, includeRetracted bool) ([]types.Revision, error) {
+	s.lookups++
+	return s.MemoryStore.GetRevisions(ctx, eventID, includeRetracted)
+}
+
+func TestCAPFeedRetracted(t *testing.T) {
+	ctx := context.Background()
+	store := &revisionCounter{MemoryStore: seed(t, 3)}
+	eq, _ := store.GetEarthquakeById(ctx, "q1", false)
+	eq.InfoType = types.InfoTypeCancelled
+	if err := store.RetractEarthquake(ctx, eq); err != nil {
+		t.Fatalf("RetractEarthquake: %v", err)
+	}
+
+	response := get(t, store, "/feeds/cap.atom", map[string]string{"include_retracted": "true", "min_intensity": "1"})
+	var feed atomFeed
+	if err := xml.Unmarshal([]byte(response.Body), &feed); err != nil {
+		t.Fatalf("GET /feeds/cap.atom = %d %s: %v", response.StatusCode, response.Body, err)
+	}
+	if len(feed.Entries) != 3 {
+		t.Fatalf("feed has %d entries, want 3", len(feed.Entries))
+	}
+	for _, entry := range feed.Entries {
+		retracted := strings.Contains(entry.Link.Href, "/earthquake/q1?")
+		if retracted != strings.HasSuffix(entry.Link.Href, "&include_retracted=true") {
+			t.Errorf("entry link %s, want include_retracted only on the cancelled q1", entry.Link.Href)
+		}
+	}
+	if store.lookups != 0 {
+		t.Errorf("the feed looked up revisions %d times one by one, want a single batch", store.lookups)
+	}
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// Machine-readable error codes returned in the error envelope.
+const (
+	CodeBadRequest         = "bad_request"
+	CodeInvalidParameters  = "invalid_parameters"
+	CodeNotFound           = "not_found"
+	CodeMethodNotAllowed   = "method_not_allowed"
+	CodeTooLarge           = "request_too_large"
+	CodeInternal           = "internal_error"
+	CodeServiceUnavailable = "service_unavailable"
+)
+
+// Error is an API error with an HTTP status and a stable code clients can
+// switch on. Handlers return it as their error; the ErrorEnvelope middleware
+// renders it as
+//
+//	{"error": {"status": 404, "code": "not_found", "message": "...", "request_id": "..."}}
+type Error struct {
+	Status  int         `json:"status"`
+	Code    string      `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+
+	// Headers are added to the error response, e.g. Allow on a 405.
+	Headers map[string]string `json:"-"`
+	// Err is the underlying cause. It is logged, never sent to clients.
+	Err error `json:"-"`
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %v", e.Message, e.Err)
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// BadRequest reports a malformed request.
+func BadRequest(message string) *Error {
+	return &Error{Status: 400, Code: CodeBadRequest, Message: message}
+}
+
+// NotFound reports a missing resource.
+func NotFound(message string) *Error {
+	return &Error{Status: 404, Code: CodeNotFound, Message: message}
+}
+
+// Internal reports a server-side failure; cause is logged but not exposed.
+func Internal(message string, cause error) *Error {
+	return &Error{Status: 500, Code: CodeInternal, Message: message, Err: cause}
+}
+
+// errorResponse renders err in the standard envelope. Errors that are not an
+// *Error are treated as internal errors.
+func errorResponse(request *Request, err error) *Response {
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		apiErr = Internal("Internal server error", err)
+	}
+	if apiErr.Status >= 500 {
+		log.Printf("Error handling %s %s (request_id=%s): %v",
+			request.Method, request.Path, RequestID(request.Context()), apiErr)
+	}
+
+	envelope := map[string]interface{}{
+		"error": struct {
+			*Error
+			RequestID string `json:"request_id,omitempty"`
+		}{apiErr, RequestID(request.Context())},
+	}
+	body, _ := json.Marshal(envelope)
+
+	headers := map[string]string{"Content-Type": "application/json"}
+	for k, v := range apiErr.Headers {
+		headers[k] = v
+	}
+	return &Response{StatusCode: apiErr.Status, Headers: headers, Body: string(body)}
+}
+
+// JSON marshals v into a response with the given status.
+func JSON(status int, v interface{}) (*Response, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, Internal("Error encoding response", err)
+	}
+	return &Response{
+		StatusCode: status,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(body),
+	}, nil
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/Ward-R/Jishin-API/db"
+	"github.com/Ward-R/Jishin-API/types"
+)
+
+// isStreamFormat reports whether format is rendered by streamResponse. Streamed
+// exports are written row by row and are not paginated: limit applies as usual
+// and limit=-1 exports every match.
+func isStreamFormat(format string) bool {
+	return format == FormatCSV || format == FormatNDJSON
+}
+
+// exportFilter lifts the MaxLimit cap for limit=-1 when the export is streamed
+// to the client as it is written. The Lambda adapter buffers the whole body and
+// API Gateway rejects responses over 6 MB, so there limit=-1 is refused with a
+// 413 rather than silently cut to MaxLimit.
+func exportFilter(request *Request, filter db.EarthquakeFilter) (db.EarthquakeFilter, error) {
+	if request.Query["limit"] != "-1" {
+		return filter, nil
+	}
+	if request.buffered {
+		return filter, &Error{
+			Status:  413,
+			Code:    CodeTooLarge,
+			Message: fmt.Sprintf("limit=-1 exports are only available from the standalone server; export up to %d rows at a time, narrowing with start and end", MaxLimit),
+		}
+	}
+	filter.Limit = -1
+	return filter, nil
+}
+
+// earthquakeRecord is one row of an export, with both Japanese and English
+// text and RFC 3339 timestamps in UTC.
+type earthquakeRecord struct {
+	ReportId       string  `json:"report_id"`
+	OriginTime     string  `json:"origin_time"`
+	ArrivalTime    string  `json:"arrival_time"`
+	Magnitude      float64 `json:"magnitude"`
+	DepthKm        int     `json:"depth_km"`
+	Latitude       float64 `json:"latitude"`
+	Longitude      float64 `json:"longitude"`
+	MaxIntensity   string  `json:"max_intensity"`
+	JpLocation     string  `json:"jp_location"`
+	EnLocation     string  `json:"en_location"`
+	JpComment      string  `json:"jp_comment"`
+	EnComment      string  `json:"en_comment"`
+	TsunamiRisk    string  `json:"tsunami_risk"`
+	Tsunami        bool    `json:"tsunami"`
+	TsunamiWarning bool    `json:"tsunami_warning"`
+	Serial         int     `json:"serial"`
+	InfoType       string  `json:"info_type"`
+	ReportDateTime string  `json:"report_date_time"`
+	Retracted      bool    `json:"retracted"`
+}
+
+// csvHeader names the CSV columns, in the order of earthquakeRecord.csvRow.
+var csvHeader = []string{
+	"report_id", "origin_time", "arrival_time", "magnitude", "depth_km",
+	"latitude", "longitude", "max_intensity", "jp_location", "en_location",
+	"jp_comment", "en_comment", "tsunami_risk", "tsunami", "tsunami_warning",
+	"serial", "info_type", "report_date_time", "retracted",
+}
+
+func newEarthquakeRecord(eq *types.Earthquake) earthquakeRecord {
+	return earthquakeRecord{
+		ReportId:       eq.ReportId,
+		OriginTime:     formatTimestamp(eq.OriginTime),
+		ArrivalTime:    formatTimestamp(eq.ArrivalTime),
+		Magnitude:      eq.Magnitude,
+		DepthKm:        eq.DepthKm,
+		Latitude:       eq.Latitude,
+		Longitude:      eq.Longitude,
+		MaxIntensity:   eq.MaxIntensity,
+		JpLocation:     eq.JpLocation,
+		EnLocation:     eq.EnLocation,
+		JpComment:      eq.JpComment,
+		EnComment:      eq.EnComment,
+		TsunamiRisk:    eq.TsunamiRisk,
+		Tsunami:        eq.Tsunami,
+		TsunamiWarning: eq.TsunamiWarning,
+		Serial:         eq.Serial,
+		InfoType:       eq.InfoType,
+		ReportDateTime: formatTimestamp(eq.ReportDateTime),
+		Retracted:      eq.Retracted,
+	}
+}
+
+func (r earthquakeRecord) csvRow() []string {
+	return []string{
+		r.ReportId, r.OriginTime, r.ArrivalTime,
+		strconv.FormatFloat(r.Magnitude, 'f', -1, 64), strconv.Itoa(r.DepthKm),
+		strconv.FormatFloat(r.Latitude, 'f', -1, 64), strconv.FormatFloat(r.Longitude, 'f', -1, 64),
+		r.MaxIntensity, r.JpLocation, r.EnLocation, r.JpComment, r.EnComment, r.TsunamiRisk,
+		strconv.FormatBool(r.Tsunami), strconv.FormatBool(r.TsunamiWarning), strconv.Itoa(r.Serial), r.InfoType,
+		r.ReportDateTime, strconv.FormatBool(r.Retracted),
+	}
+}
+
+// formatTimestamp formats t as RFC 3339 in UTC, or "" for the zero time.
+func formatTimestamp(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// streamResponse renders an export whose rows come from source, which calls
+// its argument once per earthquake (e.g. a db.StreamEarthquakes closure).
+func streamResponse(format string, source func(fn func(eq *types.Earthquake) error) error) *Response {
+	response := &Response{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": formatMediaTypes[format] + "; charset=utf-8"},
+	}
+
+	switch format {
+	case FormatCSV:
+		response.Headers["Content-Disposition"] = `attachment; filename="earthquakes.csv"`
+		response.Stream = func(w io.Writer) error {
+			cw := csv.NewWriter(w)
+			if err := cw.Write(csvHeader); err != nil {
+				return err
+			}
+			err := source(func(eq *types.Earthquake) error {
+				return cw.Write(newEarthquakeRecord(eq).csvRow())
+			})
+			if err != nil {
+				return err
+			}
+			cw.Flush()
+			return cw.Error()
+		}
+	case FormatNDJSON:
+		response.Stream = func(w io.Writer) error {
+			enc := json.NewEncoder(w)
+			return source(func(eq *types.Earthquake) error {
+				return enc.Encode(newEarthquakeRecord(eq))
+			})
+		}
+	}
+	return response
+}
@@ -0,0 +1,440 @@
+package api
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Ward-R/Jishin-API/db"
+	"github.com/Ward-R/Jishin-API/types"
+	"github.com/jackc/pgx/v4"
+)
+
+// fdsnServiceVersion is the version of the FDSN web service specification the
+// /fdsnws/event/1 endpoints implement.
+const fdsnServiceVersion = "1.2.0"
+
+// fdsnMaxEvents caps a single query, or MaxLimit behind the Lambda adapter.
+// Larger result sets get a 413 asking the client to narrow the request or page
+// with limit and offset.
+const fdsnMaxEvents = 20000
+
+// fdsnCatalog is the only catalog and contributor served.
+const fdsnCatalog = "JMA"
+
+// fdsnAliases maps the abbreviated FDSN parameter names to their full form.
+var fdsnAliases = map[string]string{
+	"start":   "starttime",
+	"end":     "endtime",
+	"minlat":  "minlatitude",
+	"maxlat":  "maxlatitude",
+	"minlon":  "minlongitude",
+	"maxlon":  "maxlongitude",
+	"lat":     "latitude",
+	"lon":     "longitude",
+	"minmag":  "minmagnitude",
+	"maxmag":  "maxmagnitude",
+	"magtype": "magnitudetype",
+}
+
+// fdsnParameters lists every parameter the query method accepts. The spec
+// requires anything else to be rejected.
+var fdsnParameters = map[string]bool{
+	"starttime": true, "endtime": true, "updatedafter": true,
+	"minlatitude": true, "maxlatitude": true, "minlongitude": true, "maxlongitude": true,
+	"latitude": true, "longitude": true, "minradius": true, "maxradius": true,
+	"mindepth": true, "maxdepth": true, "minmagnitude": true, "maxmagnitude": true,
+	"magnitudetype": true, "includeallorigins": true, "includeallmagnitudes": true,
+	"includearrivals": true, "eventid": true, "limit": true, "offset": true,
+	"orderby": true, "catalog": true, "contributor": true, "format": true, "nodata": true,
+}
+
+// fdsnOrders maps the orderby values to listing orders.
+var fdsnOrders = map[string]db.EarthquakeOrder{
+	"time":          db.OrderTimeDesc,
+	"time-asc":      db.OrderTimeAsc,
+	"magnitude":     db.OrderMagnitudeDesc,
+	"magnitude-asc": db.OrderMagnitudeAsc,
+}
+
+// fdsnQuery is a validated fdsnws-event query.
+type fdsnQuery struct {
+	filter  db.EarthquakeFilter
+	eventID string
+	format  string
+	noData  int
+	// limited is set when the client chose a limit, so more matches than
+	// fdsnMaxEvents are a page rather than a 413.
+	limited bool
+	// matchesNothing is set when a parameter, like another catalog, rules out
+	// every JMA event.
+	matchesNothing bool
+}
+
+// FDSNTime parses an FDSN time (YYYY-MM-DDTHH:MM:SS[.ssssss], or a date),
+// which is UTC unless it carries an offset.
+func (p *queryParser) FDSNTime(name string) time.Time {
+	raw, ok := p.value(name)
+	if !ok {
+		return time.Time{}
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999", "2006-01-02"} {
+		if t, err := time.Parse(layout, raw); err == nil {
+			return t
+		}
+	}
+	p.fail(name, "must be a time in YYYY-MM-DDTHH:MM:SS format")
+	return time.Time{}
+}
+
+// parseFDSNQuery validates the query string of /fdsnws/event/1/query.
+func parseFDSNQuery(query map[string]string) (*fdsnQuery, error) {
+	normalized := map[string]string{}
+	var unknown []FieldError
+	for k, v := range query {
+		name := strings.ToLower(k)
+		if full, ok := fdsnAliases[name]; ok {
+			name = full
+		}
+		if !fdsnParameters[name] {
+			unknown = append(unknown, FieldError{Field: k, Message: "is not a supported parameter"})
+			continue
+		}
+		normalized[name] = v
+	}
+
+	p := newQueryParser(normalized)
+	p.errors = unknown
+	q := &fdsnQuery{
+		eventID: strings.TrimSpace(normalized["eventid"]),
+		format:  "xml",
+		noData:  p.Int("nodata", 204, 204, 404),
+	}
+	_, q.limited = normalized["limit"]
+	if q.noData != 204 && q.noData != 404 {
+		p.fail("nodata", "must be 204 or 404")
+	}
+	if format, ok := p.value("format"); ok {
+		q.format = strings.ToLower(format)
+		if q.format != "xml" && q.format != "text" {
+			p.fail("format", "must be xml or text")
+		}
+	}
+
+	filter := db.EarthquakeFilter{
+		Limit:        p.Int("limit", fdsnMaxEvents, 1, fdsnMaxEvents),
+		Offset:       p.Int("offset", 1, 1, math.MaxInt32) - 1,
+		MinMagnitude: p.OptionalFloat("minmagnitude", -10, 10),
+		MaxMagnitude: p.OptionalFloat("maxmagnitude", -10, 10),
+		Start:        p.FDSNTime("starttime"),
+		UpdatedAfter: p.FDSNTime("updatedafter"),
+	}
+	// endtime is inclusive, End is not
+	if end := p.FDSNTime("endtime"); !end.IsZero() {
+		filter.End = end.Add(time.Microsecond)
+	}
+	if orderBy, ok := p.value("orderby"); ok {
+		order, known := fdsnOrders[strings.ToLower(orderBy)]
+		if !known {
+			p.fail("orderby", "must be one of time, time-asc, magnitude, magnitude-asc")
+		}
+		filter.Order = order
+	}
+
+	// Depths are stored in whole kilometres
+	if minDepth := p.OptionalFloat("mindepth", -100, 7000); minDepth != nil {
+		v := int(math.Ceil(*minDepth))
+		filter.MinDepth = &v
+	}
+	if maxDepth := p.OptionalFloat("maxdepth", -100, 7000); maxDepth != nil {
+		v := int(math.Floor(*maxDepth))
+		filter.MaxDepth = &v
+	}
+
+	_, hasMinLat := p.value("minlatitude")
+	_, hasMaxLat := p.value("maxlatitude")
+	_, hasMinLon := p.value("minlongitude")
+	_, hasMaxLon := p.value("maxlongitude")
+	if hasMinLat || hasMaxLat || hasMinLon || hasMaxLon {
+		filter.BBox = &db.BoundingBox{
+			MinLat: p.Float("minlatitude", -90, -90, 90),
+			MaxLat: p.Float("maxlatitude", 90, -90, 90),
+			MinLon: p.Float("minlongitude", -180, -180, 180),
+			MaxLon: p.Float("maxlongitude", 180, -180, 180),
+		}
+		if filter.BBox.MinLat > filter.BBox.MaxLat {
+			p.fail("maxlatitude", "must not be less than minlatitude")
+		}
+	}
+
+	_, hasLat := p.value("latitude")
+	_, hasLon := p.value("longitude")
+	_, hasMinRadius := p.value("minradius")
+	_, hasMaxRadius := p.value("maxradius")
+	if hasLat || hasLon || hasMinRadius || hasMaxRadius {
+		filter.Near = &db.Circle{
+			Latitude:    p.Float("latitude", 0, -90, 90),
+			Longitude:   p.Float("longitude", 0, -180, 180),
+			MinRadiusKm: p.Float("minradius", 0, 0, 180) * db.KmPerDegree,
+			RadiusKm:    p.Float("maxradius", 180, 0, 180) * db.KmPerDegree,
+		}
+		if filter.Near.MinRadiusKm > filter.Near.RadiusKm {
+			p.fail("maxradius", "must not be less than minradius")
+		}
+	}
+
+	if filter.MinMagnitude != nil && filter.MaxMagnitude != nil && *filter.MinMagnitude > *filter.MaxMagnitude {
+		p.fail("maxmagnitude", "must not be less than minmagnitude")
+	}
+	if !filter.Start.IsZero() && !filter.End.IsZero() && !filter.Start.Before(filter.End) {
+		p.fail("endtime", "must not be before starttime")
+	}
+
+	// One origin and one magnitude per event and no arrivals: these only have
+	// to be valid
+	p.Bool("includeallorigins")
+	p.Bool("includeallmagnitudes")
+	p.Bool("includearrivals")
+
+	for _, name := range []string{"catalog", "contributor"} {
+		if v, ok := p.value(name); ok && !strings.EqualFold(v, fdsnCatalog) {
+			q.matchesNothing = true
+		}
+	}
+	if v, ok := p.value("magnitudetype"); ok && !strings.EqualFold(v, "Mj") {
+		q.matchesNothing = true
+	}
+
+	if err := p.Err(); err != nil {
+		return nil, err
+	}
+	q.filter = filter
+	return q, nil
+}
+
+// HandleFDSNQuery serves /fdsnws/event/1/query: the catalog in QuakeML (xml)
+// or the pipe-separated text format, with 204 (or 404 if nodata=404) when
+// nothing matches.
+func HandleFDSNQuery(store db.EarthquakeStore, request *Request) (*Response, error) {
+	q, err := parseFDSNQuery(request.Query)
+	if err != nil {
+		return nil, err
+	}
+
+	var earthquakes []types.Earthquake
+	switch {
+	case q.matchesNothing:
+	case q.eventID != "":
+		earthquake, err := store.GetEarthquakeById(request.Context(), q.eventID, false)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return nil, Internal("Error fetching earthquake", err)
+		}
+		if err == nil {
+			earthquakes = append(earthquakes, *earthquake)
+		}
+	default:
+		// The Lambda adapter buffers the body and API Gateway rejects responses
+		// over 6 MB, so there a query is capped at MaxLimit events
+		maxEvents, capped := fdsnMaxEvents, false
+		if request.buffered && q.filter.Limit > MaxLimit {
+			maxEvents, capped = MaxLimit, true
+			q.filter.Limit = MaxLimit
+		}
+		page, err := store.GetEarthquakes(request.Context(), q.filter)
+		if err != nil {
+			return nil, Internal("Error fetching earthquakes", err)
+		}
+		if page.Next != nil && (!q.limited || capped) {
+			return nil, &Error{
+				Status:  413,
+				Code:    CodeTooLarge,
+				Message: fmt.Sprintf("More than %d events match; narrow the request or use limit and offset", maxEvents),
+			}
+		}
+		earthquakes = page.Earthquakes
+	}
+
+	if len(earthquakes) == 0 {
+		if q.noData == 404 {
+			return nil, NotFound("No data matches the selection")
+		}
+		return &Response{StatusCode: 204, Headers: map[string]string{}}, nil
+	}
+	if q.format == "text" {
+		return fdsnTextResponse(earthquakes), nil
+	}
+	return quakeMLResponse(earthquakes)
+}
+
+// fdsnTextHeader is the header line of the fdsnws-event text format.
+const fdsnTextHeader = "#EventID|Time|Latitude|Longitude|Depth/km|Author|Catalog|Contributor|ContributorID|MagType|Magnitude|MagAuthor|EventLocationName"
+
+// fdsnTextResponse renders earthquakes in the fdsnws-event text format.
+func fdsnTextResponse(earthquakes []types.Earthquake) *Response {
+	var b strings.Builder
+	b.WriteString(fdsnTextHeader + "\n")
+	for _, eq := range earthquakes {
+		name := eq.EnLocation
+		if name == "" {
+			name = eq.JpLocation
+		}
+		fmt.Fprintf(&b, "%s|%s|%g|%g|%d|%s|%s|%s|%s|Mj|%g|%s|%s\n",
+			eq.ReportId, eq.OriginTime.UTC().Format("2006-01-02T15:04:05.000000"),
+			eq.Latitude, eq.Longitude, eq.DepthKm,
+			fdsnCatalog, fdsnCatalog, fdsnCatalog, eq.ReportId,
+			eq.Magnitude, fdsnCatalog, strings.ReplaceAll(name, "|", " "))
+	}
+	return &Response{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "text/plain; charset=utf-8"},
+		Body:       b.String(),
+	}
+}
+
+// fdsnErrors renders errors from an FDSN handler in the plain-text error
+// format the FDSN specification defines, instead of the JSON envelope.
+func fdsnErrors(next HandlerFunc) HandlerFunc {
+	return func(request *Request) (*Response, error) {
+		response, err := next(request)
+		if err == nil {
+			return response, nil
+		}
+		response = errorResponse(request, err)
+
+		var apiErr *Error
+		if !errors.As(err, &apiErr) {
+			apiErr = Internal("Internal server error", err)
+		}
+		details := apiErr.Message
+		if fields, ok := apiErr.Details.([]FieldError); ok {
+			for _, f := range fields {
+				details += fmt.Sprintf("\n%s %s", f.Field, f.Message)
+			}
+		}
+		if apiErr.Status >= 500 {
+			details = "Internal server error"
+		}
+
+		target := request.Path
+		if len(request.Query) > 0 {
+			target += "?" + request.QueryValues().Encode()
+		}
+
+		response.Headers["Content-Type"] = "text/plain; charset=utf-8"
+		response.Body = fmt.Sprintf("Error %d: %s\n\n%s\n\nUsage details are available from /fdsnws/event/1/application.wadl\n\nRequest:\n%s\n\nRequest Submitted:\n%s\n\nService version:\n%s\n",
+			apiErr.Status, http.StatusText(apiErr.Status), details, target,
+			time.Now().UTC().Format("2006-01-02T15:04:05"), fdsnServiceVersion)
+		return response, nil
+	}
+}
+
+// HandleFDSNVersion serves /fdsnws/event/1/version.
+func HandleFDSNVersion() (*Response, error) {
+	return fdsnPlain("text/plain; charset=utf-8", fdsnServiceVersion), nil
+}
+
+// HandleFDSNCatalogs serves /fdsnws/event/1/catalogs.
+func HandleFDSNCatalogs() (*Response, error) {
+	return fdsnPlain("application/xml", xml.Header+"<Catalogs><Catalog>"+fdsnCatalog+"</Catalog></Catalogs>\n"), nil
+}
+
+// HandleFDSNContributors serves /fdsnws/event/1/contributors.
+func HandleFDSNContributors() (*Response, error) {
+	return fdsnPlain("application/xml", xml.Header+"<Contributors><Contributor>"+fdsnCatalog+"</Contributor></Contributors>\n"), nil
+}
+
+// HandleFDSNWADL serves /fdsnws/event/1/application.wadl, which clients such
+// as ObsPy read to discover the supported parameters.
+func HandleFDSNWADL() (*Response, error) {
+	return fdsnPlain("application/xml", fdsnWADL), nil
+}
+
+func fdsnPlain(contentType, body string) *Response {
+	return &Response{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": contentType},
+		Body:       body,
+	}
+}
+
+// fdsnWADL describes the query method and its parameters.
+const fdsnWADL = xml.Header + `<application xmlns="http://wadl.dev.java.net/2009/02" xmlns:xs="http://www.w3.org/2001/XMLSchema">
+  <resources base="fdsnws/event/1/">
+    <resource path="query">
+      <method name="GET">
+        <request>
+          <param name="starttime" style="query" type="xs:dateTime"/>
+          <param name="endtime" style="query" type="xs:dateTime"/>
+          <param name="updatedafter" style="query" type="xs:dateTime"/>
+          <param name="minlatitude" style="query" type="xs:double" default="-90.0"/>
+          <param name="maxlatitude" style="query" type="xs:double" default="90.0"/>
+          <param name="minlongitude" style="query" type="xs:double" default="-180.0"/>
+          <param name="maxlongitude" style="query" type="xs:double" default="180.0"/>
+          <param name="latitude" style="query" type="xs:double" default="0.0"/>
+          <param name="longitude" style="query" type="xs:double" default="0.0"/>
+          <param name="minradius" style="query" type="xs:double" default="0.0"/>
+          <param name="maxradius" style="query" type="xs:double" default="180.0"/>
+          <param name="mindepth" style="query" type="xs:double"/>
+          <param name="maxdepth" style="query" type="xs:double"/>
+          <param name="minmagnitude" style="query" type="xs:double"/>
+          <param name="maxmagnitude" style="query" type="xs:double"/>
+          <param name="magnitudetype" style="query" type="xs:string"/>
+          <param name="includeallorigins" style="query" type="xs:boolean" default="false"/>
+          <param name="includeallmagnitudes" style="query" type="xs:boolean" default="false"/>
+          <param name="includearrivals" style="query" type="xs:boolean" default="false"/>
+          <param name="eventid" style="query" type="xs:string"/>
+          <param name="limit" style="query" type="xs:int"/>
+          <param name="offset" style="query" type="xs:int" default="1"/>
+          <param name="orderby" style="query" type="xs:string" default="time">
+            <option value="time"/>
+            <option value="time-asc"/>
+            <option value="magnitude"/>
+            <option value="magnitude-asc"/>
+          </param>
+          <param name="catalog" style="query" type="xs:string"/>
+          <param name="contributor" style="query" type="xs:string"/>
+          <param name="format" style="query" type="xs:string" default="xml">
+            <option value="xml" mediaType="application/xml"/>
+            <option value="text" mediaType="text/plain"/>
+          </param>
+          <param name="nodata" style="query" type="xs:int" default="204">
+            <option value="204"/>
+            <option value="404"/>
+          </param>
+        </request>
+        <response status="200">
+          <representation mediaType="application/xml"/>
+          <representation mediaType="text/plain"/>
+        </response>
+        <response status="204 400 404 413 500 503">
+          <representation mediaType="text/plain"/>
+        </response>
+      </method>
+    </resource>
+    <resource path="version">
+      <method name="GET">
+        <response><representation mediaType="text/plain"/></response>
+      </method>
+    </resource>
+    <resource path="catalogs">
+      <method name="GET">
+        <response><representation mediaType="application/xml"/></response>
+      </method>
+    </resource>
+    <resource path="contributors">
+      <method name="GET">
+        <response><representation mediaType="application/xml"/></response>
+      </method>
+    </resource>
+    <resource path="application.wadl">
+      <method name="GET">
+        <response><representation mediaType="application/xml"/></response>
+      </method>
+    </resource>
+  </resources>
+</application>
+`
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestFDSNEventIDErrors(t *testing.T) {
+	query := map[string]string{"eventid": "q1"}
+	if response := get(t, failingStore{}, "/fdsnws/event/1/query", query); response.StatusCode != 500 {
+		t.Errorf("eventid lookup with the database down = %d, want 500", response.StatusCode)
+	}
+	if response := get(t, seed(t, 1), "/fdsnws/event/1/query", map[string]string{"eventid": "missing"}); response.StatusCode != 204 {
+		t.Errorf("eventid lookup of a missing event = %d, want 204", response.StatusCode)
+	}
+}
+
+func TestFDSNLimitIsCaseInsensitive(t *testing.T) {
+	for _, name := range []string{"limit", "LIMIT", "Limit"} {
+		q, err := parseFDSNQuery(map[string]string{name: "10"})
+		if err != nil {
+			t.Fatalf("parseFDSNQuery(%s=10): %v", name, err)
+		}
+		if !q.limited || q.filter.Limit != 10 {
+			t.Errorf("%s=10 parsed as limited %v, limit %d; want a limit of 10", name, q.limited, q.filter.Limit)
+		}
+	}
+	if q, err := parseFDSNQuery(map[string]string{}); err != nil || q.limited {
+		t.Errorf("no limit parsed as limited %v, %v", q != nil && q.limited, err)
+	}
+}
+
+func TestFDSNQueryFormats(t *testing.T) {
+	store := seed(t, 3)
+
+	response := get(t, store, "/fdsnws/event/1/query", map[string]string{"minmagnitude": "4", "orderby": "magnitude"})
+	if response.StatusCode != 200 || !strings.Contains(response.Body, "<q:quakeml") {
+		t.Fatalf("FDSN xml query = %d %.200s, want QuakeML", response.StatusCode, response.Body)
+	}
+	if strings.Contains(response.Body, "event/q1") || !strings.Contains(response.Body, "event/q2") || !strings.Contains(response.Body, "event/q3") {
+		t.Errorf("minmagnitude=4 returned %s, want q2 and q3 without the M3.5 q1", response.Body)
+	}
+
+	response = get(t, store, "/fdsnws/event/1/query", map[string]string{"format": "text", "limit": "2"})
+	lines := strings.Split(strings.TrimSpace(response.Body), "\n")
+	if response.StatusCode != 200 || len(lines) != 3 || lines[0] != fdsnTextHeader || !strings.HasPrefix(lines[1], "q1|") {
+		t.Errorf("FDSN text query = %d %q, want the header and q1, q2", response.StatusCode, response.Body)
+	}
+
+	if response := get(t, store, "/fdsnws/event/1/query", map[string]string{"format": "json"}); response.StatusCode != 400 {
+		t.Errorf("FDSN format=json = %d, want 400", response.StatusCode)
+	}
+	if response := get(t, store, "/fdsnws/event/1/query", map[string]string{"minmagnitude": "9"}); response.StatusCode != 204 {
+		t.Errorf("FDSN query matching nothing = %d, want 204", response.StatusCode)
+	}
+
+	// Behind Lambda a query is capped at MaxLimit events
+	handler := LambdaHandler(NewAPIRouter(seed(t, MaxLimit+1), nil).ServeRequest)
+	for _, tt := range []struct {
+		query  map[string]string
+		status int
+	}{
+		{map[string]string{}, 413},
+		{map[string]string{"limit": "5000"}, 413},
+		{map[string]string{"limit": "10"}, 200},
+	} {
+		response, err := handler(context.Background(), events.APIGatewayProxyRequest{
+			HTTPMethod:            "GET",
+			Path:                  "/fdsnws/event/1/query",
+			QueryStringParameters: tt.query,
+		})
+		if err != nil {
+			t.Fatalf("Lambda FDSN query: %v", err)
+		}
+		if response.StatusCode != tt.status {
+			t.Errorf("Lambda FDSN query %v = %d, want %d", tt.query, response.StatusCode, tt.status)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package api
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Ward-R/Jishin-API/db"
+	"github.com/Ward-R/Jishin-API/types"
+)
+
+// feedTagAuthority scopes the tag: URIs that identify feeds and entries. An
+// entry's ID depends only on its report ID, so readers treat later JMA
+// revisions as updates of the same entry.
+const feedTagAuthority = "tag:jishin-api,2025:"
+
+// feedTitle is shown by feed readers for both formats.
+const feedTitle = "Jishin API – 地震情報 / Earthquakes in Japan"
+
+// publicBaseURL is the absolute URL the API is reachable at, used for links in
+// documents that need them. PUBLIC_BASE_URL overrides what the request implies.
+func publicBaseURL(request *Request) string {
+	if base := os.Getenv("PUBLIC_BASE_URL"); base != "" {
+		return strings.TrimRight(base, "/")
+	}
+	scheme := request.Header("X-Forwarded-Proto")
+	if scheme == "" {
+		scheme = "http"
+	}
+	return scheme + "://" + request.Header("Host") + request.Header("X-Forwarded-Prefix")
+}
+
+// earthquakeTitle is the bilingual headline of an earthquake, e.g.
+// "M4.2 – 福島県沖 / Off the Coast of Fukushima".
+func earthquakeTitle(eq *types.Earthquake) string {
+	headline := "震度" + eq.MaxIntensity
+	if eq.Magnitude != 0 {
+		headline = fmt.Sprintf("M%.1f", eq.Magnitude)
+	}
+	var names []string
+	for _, name := range []string{eq.JpLocation, eq.EnLocation} {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return headline
+	}
+	return headline + " – " + strings.Join(names, " / ")
+}
+
+// earthquakeSummary is a short bilingual description of an earthquake.
+func earthquakeSummary(eq *types.Earthquake) string {
+	origin := eq.OriginTime.In(jst).Format("2006-01-02 15:04 MST")
+	summary := fmt.Sprintf("発生時刻 / Origin time: %s\n深さ / Depth: %d km\n最大震度 / Max intensity: %s",
+		origin, eq.DepthKm, eq.MaxIntensity)
+	if eq.JpComment != "" || eq.EnComment != "" {
+		summary += "\n" + strings.TrimSpace(eq.JpComment+" "+eq.EnComment)
+	}
+	return summary
+}
+
+// entryUpdated is when an earthquake's entry last changed: its latest JMA
+// report, or its origin time for rows synced before reports were tracked.
+func entryUpdated(eq *types.Earthquake) time.Time {
+	if eq.ReportDateTime.After(eq.OriginTime) {
+		return eq.ReportDateTime
+	}
+	return eq.OriginTime
+}
+
+// feedUpdated is the newest entryUpdated, or now for an empty feed.
+func feedUpdated(earthquakes []types.Earthquake) time.Time {
+	var updated time.Time
+	for i := range earthquakes {
+		if t := entryUpdated(&earthquakes[i]); t.After(updated) {
+			updated = t
+		}
+	}
+	if updated.IsZero() {
+		return time.Now()
+	}
+	return updated
+}
+
+type atomFeed struct {
+	XMLName   xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	GeoRSS    string      `xml:"xmlns:georss,attr"`
+	ID        string      `xml:"id"`
+	Title     string      `xml:"title"`
+	Updated   string      `xml:"updated"`
+	Author    atomAuthor  `xml:"author"`
+	Links     []atomLink  `xml:"link"`
+	Entries   []atomEntry `xml:"entry"`
+	Generator string      `xml:"generator"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	ID        string         `xml:"id"`
+	Title     string         `xml:"title"`
+	Updated   string         `xml:"updated"`
+	Published string         `xml:"published"`
+	Link      atomLink       `xml:"link"`
+	Summary   string         `xml:"summary"`
+	Point     string         `xml:"georss:point,omitempty"`
+	Category  []atomCategory `xml:"category"`
+}
+
+type atomCategory struct {
+	Term  string `xml:"term,attr"`
+	Label string `xml:"label,attr,omitempty"`
+}
+
+type rssDocument struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Atom    string     `xml:"xmlns:atom,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Self          atomLink  `xml:"atom:link"`
+	Description   string    `xml:"description"`
+	Language      string    `xml:"language"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	Description string  `xml:"description"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// HandleAtomFeed serves /feeds/earthquakes.atom. The listing filters, like
+// min_magnitude and min_intensity, narrow the feed.
+func HandleAtomFeed(store db.EarthquakeStore, request *Request) (*Response, error) {
+	earthquakes, err := feedEarthquakes(store, request)
+	if err != nil {
+		return nil, err
+	}
+
+	base := publicBaseURL(request)
+	feed := atomFeed{
+		GeoRSS:  "http://www.georss.org/georss",
+		ID:      feedTagAuthority + "feeds/earthquakes",
+		Title:   feedTitle,
+		Updated: feedUpdated(earthquakes).UTC().Format(time.RFC3339),
+		Author:  atomAuthor{Name: "Japan Meteorological Agency (JMA)"},
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: base + request.Path + feedQuery(request)},
+			{Rel: "alternate", Type: "application/json", Href: base + "/earthquakes"},
+		},
+		Generator: "Jishin API",
+	}
+	for i := range earthquakes {
+		eq := &earthquakes[i]
+		entry := atomEntry{
+			ID:        feedTagAuthority + "earthquake/" + eq.ReportId,
+			Title:     earthquakeTitle(eq),
+			Updated:   entryUpdated(eq).UTC().Format(time.RFC3339),
+			Published: eq.OriginTime.UTC().Format(time.RFC3339),
+			Link:      atomLink{Rel: "alternate", Type: "application/json", Href: base + "/earthquake/" + eq.ReportId},
+			Summary:   earthquakeSummary(eq),
+		}
+		if eq.Latitude != 0 || eq.Longitude != 0 {
+			entry.Point = fmt.Sprintf("%g %g", eq.Latitude, eq.Longitude)
+		}
+		if eq.MaxIntensity != "" {
+			entry.Category = append(entry.Category, atomCategory{Term: "intensity-" + eq.MaxIntensity, Label: "震度" + eq.MaxIntensity})
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+	return xmlResponse("application/atom+xml; charset=utf-8", feed)
+}
+
+// HandleRSSFeed serves /feeds/earthquakes.rss, the RSS 2.0 twin of the Atom feed.
+func HandleRSSFeed(store db.EarthquakeStore, request *Request) (*Response, error) {
+	earthquakes, err := feedEarthquakes(store, request)
+	if err != nil {
+		return nil, err
+	}
+
+	base := publicBaseURL(request)
+	doc := rssDocument{
+		Version: "2.0",
+		Atom:    "http://www.w3.org/2005/Atom",
+		Channel: rssChannel{
+			Title:         feedTitle,
+			Link:          base + "/earthquakes",
+			Self:          atomLink{Rel: "self", Type: "application/rss+xml", Href: base + request.Path + feedQuery(request)},
+			Description:   "気象庁の地震情報 / Earthquake reports from the Japan Meteorological Agency (JMA)",
+			Language:      "ja",
+			LastBuildDate: feedUpdated(earthquakes).Format(time.RFC1123Z),
+		},
+	}
+	for i := range earthquakes {
+		eq := &earthquakes[i]
+		doc.Channel.Items = append(doc.Channel.Items, rssItem{
+			Title:       earthquakeTitle(eq),
+			Link:        base + "/earthquake/" + eq.ReportId,
+			Description: earthquakeSummary(eq),
+			GUID:        rssGUID{Value: feedTagAuthority + "earthquake/" + eq.ReportId},
+			PubDate:     eq.OriginTime.Format(time.RFC1123Z),
+		})
+	}
+	return xmlResponse("application/rss+xml; charset=utf-8", doc)
+}
+
+// feedEarthquakes fetches the newest page of earthquakes for a feed.
+func feedEarthquakes(store db.EarthquakeStore, request *Request) ([]types.Earthquake, error) {
+	filter, err := parseEarthquakeFilter(request.Query, DefaultLimit)
+	if err != nil {
+		return nil, err
+	}
+	page, err := store.GetEarthquakes(request.Context(), filter)
+	if err != nil {
+		return nil, Internal("Error fetching earthquakes", err)
+	}
+	return page.Earthquakes, nil
+}
+
+// feedQuery re-encodes the request's query string for self links.
+func feedQuery(request *Request) string {
+	if len(request.Query) == 0 {
+		return ""
+	}
+	return "?" + request.QueryValues().Encode()
+}
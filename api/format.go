@@ -0,0 +1,99 @@
+package api
+
+import (
+	"encoding/xml"
+	"strings"
+
+	"github.com/Ward-R/Jishin-API/db"
+	"github.com/Ward-R/Jishin-API/types"
+)
+
+// Output formats the earthquake endpoints can render, selected with ?format=
+// or the Accept header.
+const (
+	FormatJSON    = "json"
+	FormatGeoJSON = "geojson"
+	FormatCSV     = "csv"
+	FormatNDJSON  = "ndjson"
+	FormatQuakeML = "quakeml"
+	FormatCAP     = "cap"
+)
+
+// formatMediaTypes maps each format to the media type it is served as.
+var formatMediaTypes = map[string]string{
+	FormatJSON:    "application/json",
+	FormatGeoJSON: "application/geo+json",
+	FormatCSV:     "text/csv",
+	FormatNDJSON:  "application/x-ndjson",
+	FormatQuakeML: "application/xml",
+	FormatCAP:     "application/cap+xml",
+}
+
+// negotiateFormat picks the output format for a request. An explicit ?format=
+// wins and must be one of supported; otherwise the first media type in Accept
+// that the endpoint supports is used, falling back to the first supported format.
+func negotiateFormat(request *Request, supported ...string) (string, error) {
+	if format := strings.ToLower(strings.TrimSpace(request.Query["format"])); format != "" {
+		for _, s := range supported {
+			if s == format {
+				return format, nil
+			}
+		}
+		return "", &Error{
+			Status:  400,
+			Code:    CodeInvalidParameters,
+			Message: "One or more query parameters are invalid",
+			Details: []FieldError{{Field: "format", Message: "must be one of " + strings.Join(supported, ", ")}},
+		}
+	}
+
+	for _, accepted := range strings.Split(request.Header("Accept"), ",") {
+		mediaType := strings.ToLower(strings.TrimSpace(strings.SplitN(accepted, ";", 2)[0]))
+		for _, s := range supported {
+			if formatMediaTypes[s] == mediaType {
+				return s, nil
+			}
+		}
+	}
+	return supported[0], nil
+}
+
+// renderPage writes a page of a listing in the negotiated format. extra holds
+// additional top-level fields, like the timeframe of /earthquakes/recent.
+func renderPage(request *Request, format string, page *db.EarthquakePage, extra map[string]interface{}) (*Response, error) {
+	switch format {
+	case FormatGeoJSON:
+		return geoJSONPageResponse(request, page, extra)
+	case FormatQuakeML:
+		return quakeMLPageResponse(request, page)
+	default:
+		return pageResponse(request, page, extra)
+	}
+}
+
+// renderEarthquake writes a single earthquake in the negotiated format.
+func renderEarthquake(store db.EarthquakeStore, request *Request, format string, eq *types.Earthquake) (*Response, error) {
+	switch format {
+	case FormatGeoJSON:
+		return geoJSON(200, newGeoJSONFeature(eq))
+	case FormatQuakeML:
+		return quakeMLResponse([]types.Earthquake{*eq})
+	case FormatCAP:
+		return capResponse(store, request, eq)
+	default:
+		return JSON(200, eq)
+	}
+}
+
+// xmlResponse marshals v into an XML document served as contentType.
+func xmlResponse(contentType string, v interface{}) (*Response, error) {
+	body, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, Internal("Error encoding response", err)
+	}
+	return &Response{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": contentType},
+		Body:       xml.Header + string(body),
+	}, nil
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"time"
+
+	"github.com/Ward-R/Jishin-API/db"
+	"github.com/Ward-R/Jishin-API/types"
+)
+
+// geoJSONFeature is one earthquake as an RFC 7946 Feature.
+type geoJSONFeature struct {
+	Type       string               `json:"type"`
+	ID         string               `json:"id"`
+	Geometry   *geoJSONPoint        `json:"geometry"`
+	Properties earthquakeProperties `json:"properties"`
+}
+
+// geoJSONPoint holds [longitude, latitude, elevation]. The elevation is the
+// hypocenter depth as negative metres, per the RFC 7946 coordinate system.
+type geoJSONPoint struct {
+	Type        string    `json:"type"`
+	Coordinates []float64 `json:"coordinates"`
+}
+
+// earthquakeProperties is the flat, snake_case view of an earthquake used by
+// the map-oriented formats.
+type earthquakeProperties struct {
+	ReportId       string    `json:"report_id"`
+	OriginTime     time.Time `json:"origin_time"`
+	ArrivalTime    time.Time `json:"arrival_time"`
+	Magnitude      float64   `json:"magnitude"`
+	DepthKm        int       `json:"depth_km"`
+	MaxIntensity   string    `json:"max_intensity"`
+	JpLocation     string    `json:"jp_location"`
+	EnLocation     string    `json:"en_location"`
+	JpComment      string    `json:"jp_comment"`
+	EnComment      string    `json:"en_comment"`
+	Tsunami        bool      `json:"tsunami"`
+	Serial         int       `json:"serial"`
+	InfoType       string    `json:"info_type"`
+	ReportDateTime time.Time `json:"report_date_time"`
+	Retracted      bool      `json:"retracted"`
+}
+
+func newEarthquakeProperties(eq *types.Earthquake) earthquakeProperties {
+	return earthquakeProperties{
+		ReportId:       eq.ReportId,
+		OriginTime:     eq.OriginTime,
+		ArrivalTime:    eq.ArrivalTime,
+		Magnitude:      eq.Magnitude,
+		DepthKm:        eq.DepthKm,
+		MaxIntensity:   eq.MaxIntensity,
+		JpLocation:     eq.JpLocation,
+		EnLocation:     eq.EnLocation,
+		JpComment:      eq.JpComment,
+		EnComment:      eq.EnComment,
+		Tsunami:        eq.Tsunami,
+		Serial:         eq.Serial,
+		InfoType:       eq.InfoType,
+		ReportDateTime: eq.ReportDateTime,
+		Retracted:      eq.Retracted,
+	}
+}
+
+// newGeoJSONFeature converts an earthquake to a Feature. Reports without a
+// hypocenter (e.g. intensity-only flashes) get a null geometry.
+func newGeoJSONFeature(eq *types.Earthquake) geoJSONFeature {
+	feature := geoJSONFeature{
+		Type:       "Feature",
+		ID:         eq.ReportId,
+		Properties: newEarthquakeProperties(eq),
+	}
+	if eq.Latitude != 0 || eq.Longitude != 0 {
+		feature.Geometry = &geoJSONPoint{
+			Type:        "Point",
+			Coordinates: []float64{eq.Longitude, eq.Latitude, float64(-eq.DepthKm * 1000)},
+		}
+	}
+	return feature
+}
+
+// geoJSONPageResponse wraps a page of earthquakes as a FeatureCollection, with
+// count, next_cursor and any extra fields as foreign members.
+func geoJSONPageResponse(request *Request, page *db.EarthquakePage, extra map[string]interface{}) (*Response, error) {
+	features := make([]geoJSONFeature, 0, len(page.Earthquakes))
+	for i := range page.Earthquakes {
+		features = append(features, newGeoJSONFeature(&page.Earthquakes[i]))
+	}
+	collection := map[string]interface{}{
+		"type":        "FeatureCollection",
+		"features":    features,
+		"count":       len(features),
+		"next_cursor": nil,
+	}
+	for k, v := range extra {
+		collection[k] = v
+	}
+	if page.Next != nil {
+		collection["next_cursor"] = encodeCursor(page.Next)
+	}
+
+	res, err := geoJSON(200, collection)
+	if err != nil {
+		return nil, err
+	}
+	if page.Next != nil {
+		setHeader(res, "Link", nextPageLink(request, page.Next))
+	}
+	return res, nil
+}
+
+// geoJSON is JSON served as application/geo+json.
+func geoJSON(status int, v interface{}) (*Response, error) {
+	res, err := JSON(status, v)
+	if err != nil {
+		return nil, err
+	}
+	setHeader(res, "Content-Type", formatMediaTypes[FormatGeoJSON])
+	return res, nil
+}
@@ -1,66 +1,70 @@
 package api
 
 import (
-	"context"
-	"encoding/json"
+	"errors"
 	"log"
-	"strconv"
-	"strings"
 	"time"
 
 	"github.com/Ward-R/Jishin-API/db"
 	"github.com/Ward-R/Jishin-API/service"
-	"github.com/aws/aws-lambda-go/events"
+	"github.com/Ward-R/Jishin-API/types"
 	"github.com/jackc/pgx/v4"
 )
 
-func HandleEarthquakes(dbConn *pgx.Conn, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	// Parse query parameters (Lambda way)
-	limitStr := request.QueryStringParameters["limit"]
-	magnitudeStr := request.QueryStringParameters["magnitude"]
-	dateStr := request.QueryStringParameters["date"]
+// includeRetracted reports whether the caller asked to see earthquakes JMA has
+// cancelled with ?include_retracted=true, rejecting values that are not a
+// boolean.
+func includeRetracted(request *Request) (bool, error) {
+	p := newQueryParser(request.Query)
+	include := p.Bool("include_retracted")
+	return include, p.Err()
+}
 
-	// Convert to proper types with defaults
-	limit := 0       // Will use the default (50) in DB function
-	magnitude := 0.0 // will mean no filter in DB function
+// getEarthquake loads the earthquake named by the path, answering 404 only
+// when there is none so database failures are not mistaken for it.
+func getEarthquake(store db.EarthquakeStore, request *Request, include bool) (*types.Earthquake, error) {
+	earthquake, err := store.GetEarthquakeById(request.Context(), request.PathParam("id"), include)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, NotFound("Earthquake not found")
+	}
+	if err != nil {
+		return nil, Internal("Error fetching earthquake", err)
+	}
+	return earthquake, nil
+}
 
-	if limitStr != "" {
-		limit, _ = strconv.Atoi(limitStr)
+func HandleEarthquakes(store db.EarthquakeStore, request *Request) (*Response, error) {
+	format, err := negotiateFormat(request, FormatJSON, FormatGeoJSON, FormatCSV, FormatNDJSON, FormatQuakeML)
+	if err != nil {
+		return nil, err
 	}
-	if magnitudeStr != "" {
-		magnitude, _ = strconv.ParseFloat(magnitudeStr, 64)
+
+	// Parse and validate query parameters
+	filter, err := parseEarthquakeFilter(request.Query, DefaultLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	if isStreamFormat(format) {
+		filter, err := exportFilter(request, filter)
+		if err != nil {
+			return nil, err
+		}
+		return streamResponse(format, func(fn func(eq *types.Earthquake) error) error {
+			return store.StreamEarthquakes(request.Context(), filter, fn)
+		}), nil
 	}
 
 	// Call db function
-	earthquakes, err := db.GetEarthquakes(dbConn, limit, magnitude, dateStr)
+	page, err := store.GetEarthquakes(request.Context(), filter)
 	if err != nil {
-		return events.APIGatewayProxyResponse{
-			StatusCode: 500,
-			Headers: map[string]string{
-			"Content-Type":                "application/json",
-			"Access-Control-Allow-Origin": "*",
-			"Access-Control-Allow-Methods": "GET, POST, OPTIONS",
-			"Access-Control-Allow-Headers": "Content-Type",
-		},
-			Body:       `{"error": "Error fetching earthquakes"}`,
-		}, nil
-	}
-
-	// Marshal to JSON
-	body, _ := json.Marshal(earthquakes)
-	return events.APIGatewayProxyResponse{
-		StatusCode: 200,
-		Headers: map[string]string{
-			"Content-Type":                "application/json",
-			"Access-Control-Allow-Origin": "*",
-			"Access-Control-Allow-Methods": "GET, POST, OPTIONS",
-			"Access-Control-Allow-Headers": "Content-Type",
-		},
-		Body: string(body),
-	}, nil
+		return nil, Internal("Error fetching earthquakes", err)
+	}
+
+	return renderPage(request, format, page, nil)
 }
 
-func HandleRoot(dbConn *pgx.Conn) (events.APIGatewayProxyResponse, error) {
+func HandleRoot(store db.EarthquakeStore) (*Response, error) {
 	response := map[string]interface{}{
 		"name":        "Jishin API",
 		"version":     "1.0.0",
@@ -74,49 +78,55 @@ func HandleRoot(dbConn *pgx.Conn) (events.APIGatewayProxyResponse, error) {
 			"GET /earthquakes/recent":                                "Gets all earthquakes in last 24 hours",
 			"GET /earthquakes/stats":                                 "Summary statistics and data overview",
 			"GET /earthquakes?limit=10":                              "10 earthquakes",
-			"GET /earthquakes?limit=-1":                              "ALL earthquakes",
+			"GET /earthquakes?limit=-1":                              "Maximum page of earthquakes (1000)",
+			"GET /earthquakes?cursor={next_cursor}":                  "Next page of any earthquake listing",
 			"GET /earthquakes?magnitude=5.0":                         "Earthquakes 5.0+ magnitude",
 			"GET /earthquakes?date=2025-08-12":                       "Earthquakes from specific date (YYYY-MM-DD)",
 			"GET /earthquakes?limit=5&magnitude=4.0&date=2025-08-12": "Combined filters example",
+			"GET /earthquakes?start=2025-08-01&end=2025-08-08":       "Earthquakes in a time range (RFC 3339 or YYYY-MM-DD, JST)",
+			"GET /earthquakes?min_magnitude=4&max_magnitude=6":       "Earthquakes within a magnitude range",
+			"GET /earthquakes?min_depth=0&max_depth=30":              "Earthquakes within a depth range (km)",
+			"GET /earthquakes?min_intensity=5-":                      "Earthquakes with max intensity 5- or stronger",
+			"GET /earthquakes?bbox=139,35,141,37":                    "Earthquakes with an epicenter inside a box (minLon,minLat,maxLon,maxLat)",
+			"GET /earthquakes?lat=38.27&lon=140.87&radius_km=100":    "Earthquakes within 100 km of a point",
+			"GET /earthquakes?tsunami=true":                          "Earthquakes with a tsunami or sea-level change comment",
+			"GET /earthquakes?format=geojson":                        "Earthquakes as a GeoJSON FeatureCollection (also /earthquakes/recent, /earthquake/{id}, or Accept: application/geo+json)",
+			"GET /earthquakes?format=csv&limit=-1":                   "Export every matching earthquake as CSV (also format=ndjson, or Accept: text/csv / application/x-ndjson)",
+			"GET /earthquakes?format=quakeml":                        "Earthquakes as QuakeML 1.2 for ObsPy and other seismology tools (also /earthquake/{id})",
 			"GET /earthquake/{id}":                                   "Get specific earthquake by report ID",
+			"GET /earthquake/{id}/stations":                          "Seismic intensity observed at each station",
+			"GET /earthquake/{id}/intensity?level=pref":              "Max intensity per prefecture (level=pref|area|city)",
+			"GET /earthquake/{id}/revisions":                         "History of JMA reports for an earthquake",
+			"GET /earthquakes?include_retracted=true":                "Include earthquakes cancelled by JMA (any endpoint)",
+			"GET /feeds/earthquakes.atom":                            "Atom feed of the latest earthquakes (accepts the listing filters, e.g. ?min_intensity=4)",
+			"GET /feeds/earthquakes.rss":                             "RSS 2.0 feed of the latest earthquakes (accepts the listing filters)",
+			"GET /earthquake/{id}?format=cap":                        "Earthquake as a bilingual CAP 1.2 alert",
+			"GET /feeds/cap.atom":                                    "Atom index of CAP alerts at or above an intensity threshold (CAP_MIN_INTENSITY, default 4; or ?min_intensity=)",
+			"GET /stream/earthquakes":                                "Server-Sent Events of new, revised and retracted earthquakes (HTTP mode only; ?min_magnitude=, ?region=, ?bbox=, Last-Event-ID)",
+			"GET /ws/earthquakes":                                    "WebSocket push of new and revised earthquakes; send {\"type\": \"subscribe\", \"filters\": {...}} (HTTP mode only)",
+			"GET /fdsnws/event/1/query":                              "FDSN event web service (starttime, endtime, minmagnitude, maxradius, orderby, format=xml|text, ...)",
+			"POST /subscriptions":                                    "Register a webhook: {\"url\", \"secret\", \"min_magnitude\", \"min_intensity\", \"prefectures\", \"tsunami\"}",
+			"GET /subscriptions/{id}":                                "A webhook subscription and whether it is still active",
+			"GET /subscriptions/{id}/deliveries":                     "Delivery log of a webhook subscription",
+			"DELETE /subscriptions/{id}":                             "Remove a webhook subscription",
 			"POST /sync":                                             "Manually sync with JMA data",
 		},
 		"data_source": "Japan Meteorological Agency (JMA)",
 		"github":      "https://github.com/Ward-R/Jishin-API",
 	}
-	body, _ := json.Marshal(response)
-	return events.APIGatewayProxyResponse{
-		StatusCode: 200,
-		Headers: map[string]string{
-			"Content-Type":                "application/json",
-			"Access-Control-Allow-Origin": "*",
-			"Access-Control-Allow-Methods": "GET, POST, OPTIONS",
-			"Access-Control-Allow-Headers": "Content-Type",
-		},
-		Body: string(body),
-	}, nil
+	return JSON(200, response)
 }
 
-func HandleHealth(dbConn *pgx.Conn) (events.APIGatewayProxyResponse, error) {
+func HandleHealth(store db.EarthquakeStore, request *Request) (*Response, error) {
 	// Test db connection
-	err := dbConn.Ping(context.Background())
+	err := store.Ping(request.Context())
 	if err != nil {
 		response := map[string]interface{}{
 			"status":   "unhealthy",
 			"database": "disconnected",
 			"error":    err.Error(),
 		}
-		body, _ := json.Marshal(response)
-		return events.APIGatewayProxyResponse{
-			StatusCode: 503,
-			Headers: map[string]string{
-			"Content-Type":                "application/json",
-			"Access-Control-Allow-Origin": "*",
-			"Access-Control-Allow-Methods": "GET, POST, OPTIONS",
-			"Access-Control-Allow-Headers": "Content-Type",
-		},
-			Body:       string(body),
-		}, nil
+		return JSON(503, response)
 	}
 
 	// All good
@@ -125,251 +135,244 @@ func HandleHealth(dbConn *pgx.Conn) (events.APIGatewayProxyResponse, error) {
 		"database":  "connected",
 		"timestamp": time.Now().Format(time.RFC3339),
 	}
-	body, _ := json.Marshal(response)
-	return events.APIGatewayProxyResponse{
-		StatusCode: 200,
-		Headers: map[string]string{
-			"Content-Type":                "application/json",
-			"Access-Control-Allow-Origin": "*",
-			"Access-Control-Allow-Methods": "GET, POST, OPTIONS",
-			"Access-Control-Allow-Headers": "Content-Type",
-		},
-		Body: string(body),
-	}, nil
+	return JSON(200, response)
 }
 
-func HandleRecent(dbConn *pgx.Conn) (events.APIGatewayProxyResponse, error) {
-	earthquakes, err := db.GetRecentEarthquakes(dbConn)
+func HandleRecent(store db.EarthquakeStore, request *Request) (*Response, error) {
+	format, err := negotiateFormat(request, FormatJSON, FormatGeoJSON, FormatCSV, FormatNDJSON)
 	if err != nil {
-		return events.APIGatewayProxyResponse{
-			StatusCode: 500,
-			Headers: map[string]string{
-			"Content-Type":                "application/json",
-			"Access-Control-Allow-Origin": "*",
-			"Access-Control-Allow-Methods": "GET, POST, OPTIONS",
-			"Access-Control-Allow-Headers": "Content-Type",
-		},
-			Body:       `{"error": "Error fetching recent earthquakes"}`,
-		}, nil
+		return nil, err
+	}
+
+	// A day rarely holds more than one page, so default to the largest one
+	filter, err := parseEarthquakeFilter(request.Query, MaxLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	if isStreamFormat(format) {
+		filter, err := exportFilter(request, filter)
+		if err != nil {
+			return nil, err
+		}
+		return streamResponse(format, func(fn func(eq *types.Earthquake) error) error {
+			return store.StreamRecentEarthquakes(request.Context(), filter, fn)
+		}), nil
+	}
+
+	page, err := store.GetRecentEarthquakes(request.Context(), filter)
+	if err != nil {
+		return nil, Internal("Error fetching recent earthquakes", err)
 	}
 
 	// Check if no earthquakes found
-	if len(earthquakes) == 0 {
+	if len(page.Earthquakes) == 0 && filter.After == nil && format == FormatJSON {
 		response := map[string]interface{}{
 			"message":   "No earthquakes found in the last 24 hours",
 			"count":     0,
 			"timeframe": "24 hours",
 		}
-		body, _ := json.Marshal(response)
-		return events.APIGatewayProxyResponse{
-			StatusCode: 200,
-			Headers: map[string]string{
-			"Content-Type":                "application/json",
-			"Access-Control-Allow-Origin": "*",
-			"Access-Control-Allow-Methods": "GET, POST, OPTIONS",
-			"Access-Control-Allow-Headers": "Content-Type",
-		},
-			Body:       string(body),
-		}, nil
+		return JSON(200, response)
 	}
 
 	// Return earthquakes with count info
-	response := map[string]interface{}{
-		"count":       len(earthquakes),
-		"timeframe":   "24 hours",
-		"earthquakes": earthquakes,
-	}
-	body, _ := json.Marshal(response)
-	return events.APIGatewayProxyResponse{
-		StatusCode: 200,
-		Headers: map[string]string{
-			"Content-Type":                "application/json",
-			"Access-Control-Allow-Origin": "*",
-			"Access-Control-Allow-Methods": "GET, POST, OPTIONS",
-			"Access-Control-Allow-Headers": "Content-Type",
-		},
-		Body: string(body),
-	}, nil
+	return renderPage(request, format, page, map[string]interface{}{"timeframe": "24 hours"})
 }
 
-func HandleStats(dbConn *pgx.Conn) (events.APIGatewayProxyResponse, error) {
-	stats, err := db.GetEarthquakeStats(dbConn)
+func HandleStats(store db.EarthquakeStore, request *Request) (*Response, error) {
+	include, err := includeRetracted(request)
 	if err != nil {
-		return events.APIGatewayProxyResponse{
-			StatusCode: 500,
-			Headers: map[string]string{
-			"Content-Type":                "application/json",
-			"Access-Control-Allow-Origin": "*",
-			"Access-Control-Allow-Methods": "GET, POST, OPTIONS",
-			"Access-Control-Allow-Headers": "Content-Type",
-		},
-			Body:       `{"error": "Error fetching earthquake statistics"}`,
-		}, nil
-	}
-
-	body, _ := json.Marshal(stats)
-	return events.APIGatewayProxyResponse{
-		StatusCode: 200,
-		Headers: map[string]string{
-			"Content-Type":                "application/json",
-			"Access-Control-Allow-Origin": "*",
-			"Access-Control-Allow-Methods": "GET, POST, OPTIONS",
-			"Access-Control-Allow-Headers": "Content-Type",
-		},
-		Body: string(body),
-	}, nil
+		return nil, err
+	}
+	stats, err := store.GetEarthquakeStats(request.Context(), include)
+	if err != nil {
+		return nil, Internal("Error fetching earthquake statistics", err)
+	}
+
+	return JSON(200, stats)
 }
 
-func HandleLargestToday(dbConn *pgx.Conn) (events.APIGatewayProxyResponse, error) {
-	earthquake, err := db.GetLargestEarthquakeToday(dbConn)
+func HandleLargestToday(store db.EarthquakeStore, request *Request) (*Response, error) {
+	include, err := includeRetracted(request)
+	if err != nil {
+		return nil, err
+	}
+	earthquake, err := store.GetLargestEarthquakeToday(request.Context(), include)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, Internal("Error fetching the largest earthquake", err)
+	}
 	if err != nil {
 		response := map[string]interface{}{
 			"message": "No earthquakes found today",
 			"period":  "today",
 		}
-		body, _ := json.Marshal(response)
-		return events.APIGatewayProxyResponse{
-			StatusCode: 200,
-			Headers: map[string]string{
-			"Content-Type":                "application/json",
-			"Access-Control-Allow-Origin": "*",
-			"Access-Control-Allow-Methods": "GET, POST, OPTIONS",
-			"Access-Control-Allow-Headers": "Content-Type",
-		},
-			Body:       string(body),
-		}, nil
+		return JSON(200, response)
 	}
 
 	response := map[string]interface{}{
 		"period":             "today",
 		"largest_earthquake": earthquake,
 	}
-	body, _ := json.Marshal(response)
-	return events.APIGatewayProxyResponse{
-		StatusCode: 200,
-		Headers: map[string]string{
-			"Content-Type":                "application/json",
-			"Access-Control-Allow-Origin": "*",
-			"Access-Control-Allow-Methods": "GET, POST, OPTIONS",
-			"Access-Control-Allow-Headers": "Content-Type",
-		},
-		Body: string(body),
-	}, nil
+	return JSON(200, response)
 }
 
-func HandleLargestWeek(dbConn *pgx.Conn) (events.APIGatewayProxyResponse, error) {
-	earthquake, err := db.GetLargestEarthquakeThisWeek(dbConn)
+func HandleLargestWeek(store db.EarthquakeStore, request *Request) (*Response, error) {
+	include, err := includeRetracted(request)
+	if err != nil {
+		return nil, err
+	}
+	earthquake, err := store.GetLargestEarthquakeThisWeek(request.Context(), include)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, Internal("Error fetching the largest earthquake", err)
+	}
 	if err != nil {
 		response := map[string]interface{}{
 			"message": "No earthquakes found this week",
 			"period":  "this week",
 		}
-		body, _ := json.Marshal(response)
-		return events.APIGatewayProxyResponse{
-			StatusCode: 200,
-			Headers: map[string]string{
-			"Content-Type":                "application/json",
-			"Access-Control-Allow-Origin": "*",
-			"Access-Control-Allow-Methods": "GET, POST, OPTIONS",
-			"Access-Control-Allow-Headers": "Content-Type",
-		},
-			Body:       string(body),
-		}, nil
+		return JSON(200, response)
 	}
 
 	response := map[string]interface{}{
 		"period":             "this week",
 		"largest_earthquake": earthquake,
 	}
-	body, _ := json.Marshal(response)
-	return events.APIGatewayProxyResponse{
-		StatusCode: 200,
-		Headers: map[string]string{
-			"Content-Type":                "application/json",
-			"Access-Control-Allow-Origin": "*",
-			"Access-Control-Allow-Methods": "GET, POST, OPTIONS",
-			"Access-Control-Allow-Headers": "Content-Type",
-		},
-		Body: string(body),
-	}, nil
+	return JSON(200, response)
 }
 
-func HandleSync(dbConn *pgx.Conn) (events.APIGatewayProxyResponse, error) {
+func HandleSync(store db.EarthquakeStore, jma *service.JMAClient, request *Request) (*Response, error) {
 	log.Println("Manually syncing earthquake data from JMA")
-	recordsAdded, err := service.SyncEarthquakes(dbConn)
+	result, err := service.SyncEarthquakes(request.Context(), store, jma)
 	if err != nil {
-		log.Printf("Error syncing earthquake data: %v", err)
-		return events.APIGatewayProxyResponse{
-			StatusCode: 500,
-			Headers: map[string]string{
-			"Content-Type":                "application/json",
-			"Access-Control-Allow-Origin": "*",
-			"Access-Control-Allow-Methods": "GET, POST, OPTIONS",
-			"Access-Control-Allow-Headers": "Content-Type",
-		},
-			Body:       `{"error": "Error syncing earthquake data"}`,
-		}, nil
+		return nil, Internal("Error syncing earthquake data", err)
 	}
 
-	log.Printf("Sync complete: added %d new earthquake records", recordsAdded)
+	log.Printf("Sync complete: added %d new, updated %d and retracted %d earthquake records",
+		result.RecordsAdded, result.RecordsUpdated, result.RecordsRetracted)
+	failures := []map[string]string{}
+	for _, syncErr := range result.Errors {
+		failures = append(failures, map[string]string{
+			"report_id": syncErr.ReportID,
+			"detail":    syncErr.DetailJSON,
+			"error":     syncErr.Err.Error(),
+		})
+	}
 	response := map[string]interface{}{
-		"message":       "Sync completed successfully",
-		"records_added": recordsAdded,
-	}
-	body, _ := json.Marshal(response)
-	return events.APIGatewayProxyResponse{
-		StatusCode: 200,
-		Headers: map[string]string{
-			"Content-Type":                "application/json",
-			"Access-Control-Allow-Origin": "*",
-			"Access-Control-Allow-Methods": "GET, POST, OPTIONS",
-			"Access-Control-Allow-Headers": "Content-Type",
+		"message":           "Sync completed successfully",
+		"records_added":     result.RecordsAdded,
+		"records_updated":   result.RecordsUpdated,
+		"records_retracted": result.RecordsRetracted,
+		"reports_listed":    result.ReportsListed,
+		"reports_fetched":   result.ReportsFetched,
+		"errors":            failures,
+		"timings_ms": map[string]int64{
+			"list":  result.Timings.List.Milliseconds(),
+			"diff":  result.Timings.Diff.Milliseconds(),
+			"fetch": result.Timings.Fetch.Milliseconds(),
+			"apply": result.Timings.Apply.Milliseconds(),
+			"total": result.Timings.Total.Milliseconds(),
 		},
-		Body: string(body),
-	}, nil
+	}
+	return JSON(200, response)
 }
 
-func HandleEarthquakeById(dbConn *pgx.Conn, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	// Extract ID from URL path like "/earthquake/20250812113450"
-	path := request.Path
-	id := strings.TrimPrefix(path, "/earthquake/")
-
-	if id == "" || id == path {
-		return events.APIGatewayProxyResponse{
-			StatusCode: 400,
-			Headers: map[string]string{
-			"Content-Type":                "application/json",
-			"Access-Control-Allow-Origin": "*",
-			"Access-Control-Allow-Methods": "GET, POST, OPTIONS",
-			"Access-Control-Allow-Headers": "Content-Type",
-		},
-			Body:       `{"error": "Earthquake ID required"}`,
-		}, nil
+func HandleEarthquakeById(store db.EarthquakeStore, request *Request) (*Response, error) {
+	format, err := negotiateFormat(request, FormatJSON, FormatGeoJSON, FormatQuakeML, FormatCAP)
+	if err != nil {
+		return nil, err
 	}
 
-	earthquake, err := db.GetEarthquakeById(dbConn, id)
+	include, err := includeRetracted(request)
 	if err != nil {
-		return events.APIGatewayProxyResponse{
-			StatusCode: 404,
-			Headers: map[string]string{
-			"Content-Type":                "application/json",
-			"Access-Control-Allow-Origin": "*",
-			"Access-Control-Allow-Methods": "GET, POST, OPTIONS",
-			"Access-Control-Allow-Headers": "Content-Type",
-		},
-			Body:       `{"error": "Earthquake not found"}`,
-		}, nil
-	}
-
-	body, _ := json.Marshal(earthquake)
-	return events.APIGatewayProxyResponse{
-		StatusCode: 200,
-		Headers: map[string]string{
-			"Content-Type":                "application/json",
-			"Access-Control-Allow-Origin": "*",
-			"Access-Control-Allow-Methods": "GET, POST, OPTIONS",
-			"Access-Control-Allow-Headers": "Content-Type",
-		},
-		Body: string(body),
-	}, nil
-}
\ No newline at end of file
+		return nil, err
+	}
+	earthquake, err := getEarthquake(store, request, include)
+	if err != nil {
+		return nil, err
+	}
+
+	return renderEarthquake(store, request, format, earthquake)
+}
+
+func HandleEarthquakeStations(store db.EarthquakeStore, request *Request) (*Response, error) {
+	id := request.PathParam("id")
+	include, err := includeRetracted(request)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := getEarthquake(store, request, include); err != nil {
+		return nil, err
+	}
+
+	stations, err := store.GetStationObservations(request.Context(), id, include)
+	if err != nil {
+		return nil, Internal("Error fetching station observations", err)
+	}
+
+	response := map[string]interface{}{
+		"report_id": id,
+		"count":     len(stations),
+		"stations":  stations,
+	}
+	return JSON(200, response)
+}
+
+func HandleEarthquakeIntensity(store db.EarthquakeStore, request *Request) (*Response, error) {
+	id := request.PathParam("id")
+
+	// Default to prefecture level, the unit alerts are routed by
+	level := request.Query["level"]
+	if level == "" {
+		level = types.RegionLevelPref
+	}
+	if level != types.RegionLevelPref && level != types.RegionLevelArea && level != types.RegionLevelCity {
+		return nil, BadRequest("level must be one of pref, area or city")
+	}
+
+	include, err := includeRetracted(request)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := getEarthquake(store, request, include); err != nil {
+		return nil, err
+	}
+
+	regions, err := store.GetRegionIntensities(request.Context(), id, level, include)
+	if err != nil {
+		return nil, Internal("Error fetching intensity breakdown", err)
+	}
+
+	response := map[string]interface{}{
+		"report_id": id,
+		"level":     level,
+		"count":     len(regions),
+		"regions":   regions,
+	}
+	return JSON(200, response)
+}
+
+func HandleEarthquakeRevisions(store db.EarthquakeStore, request *Request) (*Response, error) {
+	id := request.PathParam("id")
+	include, err := includeRetracted(request)
+	if err != nil {
+		return nil, err
+	}
+
+	revisions, err := store.GetRevisions(request.Context(), id, include)
+	if err != nil {
+		return nil, Internal("Error fetching earthquake revisions", err)
+	}
+
+	if len(revisions) == 0 {
+		return nil, NotFound("Earthquake not found")
+	}
+
+	response := map[string]interface{}{
+		"report_id": id,
+		"count":     len(revisions),
+		"revisions": revisions,
+	}
+	return JSON(200, response)
+}
@@ -0,0 +1,307 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Ward-R/Jishin-API/db"
+	"github.com/Ward-R/Jishin-API/types"
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// get serves a GET request for path through the full API router.
+func get(t *testing.T, store db.EarthquakeStore, path string, query map[string]string) *Response {
+	t.Helper()
+	if query == nil {
+		query = map[string]string{}
+	}
+	response, err := NewAPIRouter(store, nil).ServeRequest(&Request{
+		Method:  "GET",
+		Path:    path,
+		Query:   query,
+		Headers: map[string]string{"Host": "api.example.com"},
+	})
+	if err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+	return response
+}
+
+// failingStore answers every earthquake lookup with a database error.
+type failingStore struct {
+	db.EarthquakeStore
+}
+
+var errDatabaseDown = errors.New("connection refused")
+
+func (failingStore) GetEarthquakeById(ctx context.Context, id string, includeRetracted bool) (*types.Earthquake, error) {
+	return nil, errDatabaseDown
+}
+
+func (failingStore) GetLargestEarthquakeToday(ctx context.Context, includeRetracted bool) (*types.Earthquake, error) {
+	return nil, errDatabaseDown
+}
+
+func (failingStore) GetLargestEarthquakeThisWeek(ctx context.Context, includeRetracted bool) (*types.Earthquake, error) {
+	return nil, errDatabaseDown
+}
+
+func TestLookupErrors(t *testing.T) {
+	paths := []string{
+		"/earthquake/20250812113450",
+		"/earthquake/20250812113450/stations",
+		"/earthquake/20250812113450/intensity",
+		"/earthquakes/largest/today",
+		"/earthquakes/largest/week",
+	}
+	for _, path := range paths {
+		if response := get(t, failingStore{}, path, nil); response.StatusCode != 500 {
+			t.Errorf("GET %s with the database down = %d, want 500", path, response.StatusCode)
+		}
+	}
+	for _, path := range paths[:3] {
+		if response := get(t, db.NewMemoryStore(), path, nil); response.StatusCode != 404 {
+			t.Errorf("GET %s for a missing earthquake = %d, want 404", path, response.StatusCode)
+		}
+	}
+}
+
+// seed returns a memory store holding n earthquakes from the last hour, a
+// minute apart; q1 is the newest.
+func seed(t *testing.T, n int) *db.MemoryStore {
+	t.Helper()
+	store := db.NewMemoryStore()
+	for i := 1; i <= n; i++ {
+		origin := time.Now().Add(-time.Duration(i) * time.Minute).Truncate(time.Second)
+		eq := &types.Earthquake{
+			ReportId:       fmt.Sprintf("q%d", i),
+			OriginTime:     origin,
+			ArrivalTime:    origin,
+			Magnitude:      3 + float64(i%5)/2,
+			DepthKm:        10 * i,
+			Latitude:       35 + float64(i)/10,
+			Longitude:      139,
+			MaxIntensity:   "3",
+			JpLocation:     "東京湾",
+			EnLocation:     "Tokyo Bay",
+			Serial:         1,
+			InfoType:       types.InfoTypeIssued,
+			ReportDateTime: origin.Add(2 * time.Minute),
+		}
+		if err := store.InsertEarthquake(context.Background(), eq); err != nil {
+			t.Fatalf("InsertEarthquake: %v", err)
+		}
+	}
+	return store
+}
+
+func TestExportAll(t *testing.T) {
+	store := seed(t, 3)
+	router := NewAPIRouter(store, nil)
+	query := map[string]string{"format": "csv", "limit": "-1"}
+
+	response, err := router.ServeRequest(&Request{Method: "GET", Path: "/earthquakes", Query: query})
+	if err != nil {
+		t.Fatalf("GET /earthquakes: %v", err)
+	}
+	if err := response.bufferStream(); err != nil {
+		t.Fatalf("streaming the export: %v", err)
+	}
+	if lines := strings.Count(response.Body, "\n"); response.StatusCode != 200 || lines != 4 {
+		t.Errorf("streamed export = %d with %d lines, want 200 with a header and 3 rows", response.StatusCode, lines)
+	}
+	if header, _, _ := strings.Cut(response.Body, "\n"); header != strings.Join(csvHeader, ",") || !strings.Contains(header, ",tsunami_risk,tsunami,tsunami_warning,") {
+		t.Errorf("CSV header = %q, want the tsunami_risk and tsunami_warning columns", header)
+	}
+	row := newEarthquakeRecord(&types.Earthquake{TsunamiRisk: "若干の海面変動", TsunamiWarning: true}).csvRow()
+	columns := map[string]string{}
+	for i, name := range csvHeader {
+		if i < len(row) {
+			columns[name] = row[i]
+		}
+	}
+	if len(row) != len(csvHeader) || columns["tsunami_risk"] != "若干の海面変動" || columns["tsunami_warning"] != "true" {
+		t.Errorf("CSV row %q does not line up with the header %q", row, csvHeader)
+	}
+
+	lambdaResponse, err := LambdaHandler(router.ServeRequest)(context.Background(), events.APIGatewayProxyRequest{
+		HTTPMethod:            "GET",
+		Path:                  "/earthquakes",
+		QueryStringParameters: query,
+	})
+	if err != nil {
+		t.Fatalf("Lambda GET /earthquakes: %v", err)
+	}
+	if lambdaResponse.StatusCode != 413 {
+		t.Errorf("Lambda export with limit=-1 = %d, want 413", lambdaResponse.StatusCode)
+	}
+}
+
+// earthquakePage is the JSON body of an earthquake listing.
+type earthquakePage struct {
+	Count       int                `json:"count"`
+	Earthquakes []types.Earthquake `json:"earthquakes"`
+	NextCursor  *string            `json:"next_cursor"`
+}
+
+// decodePage checks that response is a 200 listing and decodes it.
+func decodePage(t *testing.T, response *Response) earthquakePage {
+	t.Helper()
+	var page earthquakePage
+	if response.StatusCode != 200 {
+		t.Fatalf("listing = %d %s, want 200", response.StatusCode, response.Body)
+	}
+	if err := json.Unmarshal([]byte(response.Body), &page); err != nil {
+		t.Fatalf("decoding %s: %v", response.Body, err)
+	}
+	return page
+}
+
+func TestEarthquakesPagination(t *testing.T) {
+	store := seed(t, 5)
+
+	var ids []string
+	query := map[string]string{"limit": "2"}
+	for pages := 0; ; pages++ {
+		if pages == 5 {
+			t.Fatalf("cursor never ran out after %v", ids)
+		}
+		response := get(t, store, "/earthquakes", query)
+		page := decodePage(t, response)
+		for _, eq := range page.Earthquakes {
+			ids = append(ids, eq.ReportId)
+		}
+		if page.NextCursor == nil {
+			if link := response.Headers["Link"]; link != "" {
+				t.Errorf("last page has Link %q", link)
+			}
+			break
+		}
+		if link := response.Headers["Link"]; !strings.Contains(link, "cursor="+*page.NextCursor) {
+			t.Errorf("Link = %q, want the next cursor %s", link, *page.NextCursor)
+		}
+		query = map[string]string{"limit": "2", "cursor": *page.NextCursor}
+	}
+	if got := strings.Join(ids, ","); got != "q1,q2,q3,q4,q5" {
+		t.Errorf("paged through %s, want q1 to q5 newest first", got)
+	}
+}
+
+func TestEarthquakesValidation(t *testing.T) {
+	tests := []struct {
+		query map[string]string
+		field string
+	}{
+		{map[string]string{"limit": "0"}, "limit"},
+		{map[string]string{"min_magnitude": "11"}, "min_magnitude"},
+		{map[string]string{"min_magnitude": "5", "max_magnitude": "4"}, "max_magnitude"},
+		{map[string]string{"cursor": "not a cursor"}, "cursor"},
+		{map[string]string{"format": "kml"}, "format"},
+	}
+	for _, tt := range tests {
+		response := get(t, seed(t, 1), "/earthquakes", tt.query)
+		var body struct {
+			Error struct {
+				Code    string       `json:"code"`
+				Details []FieldError `json:"details"`
+			} `json:"error"`
+		}
+		json.Unmarshal([]byte(response.Body), &body)
+		if response.StatusCode != 400 || len(body.Error.Details) == 0 || body.Error.Details[0].Field != tt.field {
+			t.Errorf("GET /earthquakes?%v = %d %s, want 400 naming %s", tt.query, response.StatusCode, response.Body, tt.field)
+		}
+	}
+}
+
+func TestEarthquakesRetracted(t *testing.T) {
+	ctx := context.Background()
+	store := seed(t, 2)
+	eq, _ := store.GetEarthquakeById(ctx, "q1", false)
+	eq.InfoType = types.InfoTypeCancelled
+	if err := store.RetractEarthquake(ctx, eq); err != nil {
+		t.Fatalf("RetractEarthquake: %v", err)
+	}
+
+	if page := decodePage(t, get(t, store, "/earthquakes", nil)); page.Count != 1 || page.Earthquakes[0].ReportId != "q2" {
+		t.Errorf("listing = %+v, want the retracted q1 left out", page.Earthquakes)
+	}
+	if page := decodePage(t, get(t, store, "/earthquakes", map[string]string{"include_retracted": "true"})); page.Count != 2 || !page.Earthquakes[0].Retracted {
+		t.Errorf("listing with include_retracted = %+v, want q1 marked retracted", page.Earthquakes)
+	}
+	if response := get(t, store, "/earthquake/q1", nil); response.StatusCode != 404 {
+		t.Errorf("GET /earthquake/q1 after retraction = %d, want 404", response.StatusCode)
+	}
+	if response := get(t, store, "/earthquake/q1", map[string]string{"include_retracted": "true"}); response.StatusCode != 200 {
+		t.Errorf("GET /earthquake/q1?include_retracted=true = %d, want 200", response.StatusCode)
+	}
+}
+
+func TestEarthquakeFormats(t *testing.T) {
+	store := seed(t, 2)
+	tests := []struct {
+		path, format string
+		contentType  string
+		contains     string
+	}{
+		{"/earthquake/q1", "", "application/json", `"ReportId":"q1"`},
+		{"/earthquake/q1", "geojson", "application/geo+json", `"type":"Feature"`},
+		{"/earthquake/q1", "quakeml", "application/xml", "<q:quakeml"},
+		{"/earthquakes", "geojson", "application/geo+json", `"type":"FeatureCollection"`},
+		{"/earthquakes", "quakeml", "application/xml", "smi:jishin-api/event/q2"},
+		{"/earthquakes", "ndjson", "application/x-ndjson", `"report_id":"q2"`},
+		{"/feeds/earthquakes.atom", "", "application/atom+xml", "<feed"},
+		{"/feeds/earthquakes.rss", "", "application/rss+xml", "<rss"},
+	}
+	for _, tt := range tests {
+		query := map[string]string{}
+		if tt.format != "" {
+			query["format"] = tt.format
+		}
+		response := get(t, store, tt.path, query)
+		if err := response.bufferStream(); err != nil {
+			t.Fatalf("streaming %s: %v", tt.path, err)
+		}
+		if response.StatusCode != 200 || !strings.HasPrefix(response.Headers["Content-Type"], tt.contentType) ||
+			!strings.Contains(response.Body, tt.contains) {
+			t.Errorf("GET %s?format=%s = %d %s %.200s, want %s containing %s",
+				tt.path, tt.format, response.StatusCode, response.Headers["Content-Type"], response.Body, tt.contentType, tt.contains)
+		}
+	}
+}
+
+func TestEmptyBreakdowns(t *testing.T) {
+	store := seed(t, 1)
+	response := get(t, store, "/earthquake/q1/stations", nil)
+	if response.StatusCode != 200 || !strings.Contains(response.Body, `"stations":[]`) {
+		t.Errorf("stations of an earthquake without observations = %d %s, want an empty list", response.StatusCode, response.Body)
+	}
+	response = get(t, store, "/earthquake/q1/intensity", nil)
+	if response.StatusCode != 200 || !strings.Contains(response.Body, `"regions":[]`) {
+		t.Errorf("intensity of an earthquake without observations = %d %s, want an empty list", response.StatusCode, response.Body)
+	}
+}
+
+func TestIncludeRetractedValidation(t *testing.T) {
+	store := seed(t, 1)
+	paths := []string{
+		"/earthquakes",
+		"/earthquakes/stats",
+		"/earthquakes/largest/today",
+		"/earthquakes/largest/week",
+		"/earthquake/q1",
+		"/earthquake/q1/stations",
+		"/earthquake/q1/intensity",
+		"/earthquake/q1/revisions",
+	}
+	for _, path := range paths {
+		response := get(t, store, path, map[string]string{"include_retracted": "yes"})
+		if response.StatusCode != 400 || !strings.Contains(response.Body, `"field":"include_retracted"`) {
+			t.Errorf("GET %s?include_retracted=yes = %d %s, want 400 naming include_retracted", path, response.StatusCode, response.Body)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"io"
+	"log"
+	"net/http"
+)
+
+// maxRequestBody caps how much of a request body the HTTP adapter reads.
+const maxRequestBody = 1 << 20
+
+// HTTPHandler adapts a HandlerFunc to net/http for the standalone server mode.
+func HTTPHandler(handler HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestBody))
+
+		// API Gateway passes single-value maps; keep the first value to match
+		query := map[string]string{}
+		for k, v := range r.URL.Query() {
+			query[k] = v[0]
+		}
+		headers := map[string]string{}
+		for k, v := range r.Header {
+			headers[k] = v[0]
+		}
+		// net/http moves Host out of the header map
+		headers["Host"] = r.Host
+
+		request := &Request{
+			Method:  r.Method,
+			Path:    r.URL.Path,
+			Query:   query,
+			Headers: headers,
+			Body:    string(body),
+			ctx:     r.Context(),
+		}
+		if err != nil {
+			request.bodyErr = BadRequest("Error reading request body")
+		}
+
+		response, err := handler(request)
+		if err != nil {
+			response = errorResponse(request, err)
+		}
+
+		for k, v := range response.Headers {
+			w.Header().Set(k, v)
+		}
+		w.WriteHeader(response.StatusCode)
+		if response.Stream == nil {
+			io.WriteString(w, response.Body)
+			return
+		}
+		if err := response.Stream(w); err != nil {
+			// The status is already sent; abort so the client sees a truncated
+			// response instead of a complete-looking one
+			log.Printf("Error streaming %s %s: %v", request.Method, request.Path, err)
+			panic(http.ErrAbortHandler)
+		}
+	})
+}
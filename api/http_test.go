@@ -0,0 +1,47 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Ward-R/Jishin-API/db"
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// brokenBody fails every read, like a client that drops the connection.
+type brokenBody struct{}
+
+func (brokenBody) Read([]byte) (int, error) { return 0, errors.New("connection reset") }
+
+func TestUnreadableBody(t *testing.T) {
+	router := NewAPIRouter(db.NewMemoryStore(), nil)
+
+	w := httptest.NewRecorder()
+	HTTPHandler(router.ServeRequest).ServeHTTP(w, httptest.NewRequest("POST", "/subscriptions", brokenBody{}))
+	var envelope struct {
+		Error *Error `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &envelope); err != nil || envelope.Error == nil || w.Code != http.StatusBadRequest {
+		t.Errorf("unreadable body = %d %s, want a 400 error envelope", w.Code, w.Body)
+	}
+	if w.Header().Get(RequestIDHeader) == "" || w.Header().Get("Access-Control-Allow-Origin") == "" {
+		t.Errorf("unreadable body skipped the middleware: headers %v", w.Header())
+	}
+
+	response, err := LambdaHandler(router.ServeRequest)(context.Background(), events.APIGatewayProxyRequest{
+		HTTPMethod:      "POST",
+		Path:            "/subscriptions",
+		Body:            "not base64!",
+		IsBase64Encoded: true,
+	})
+	if err != nil {
+		t.Fatalf("Lambda POST /subscriptions: %v", err)
+	}
+	if response.StatusCode != 400 || response.Headers[RequestIDHeader] == "" {
+		t.Errorf("badly encoded Lambda body = %d %v %s, want a 400 with a request ID", response.StatusCode, response.Headers, response.Body)
+	}
+}
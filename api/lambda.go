@@ -0,0 +1,89 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// LambdaHandler adapts a HandlerFunc to the API Gateway proxy integration used
+// by lambda.Start.
+func LambdaHandler(handler HandlerFunc) func(context.Context, events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	return func(ctx context.Context, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		body := event.Body
+		var bodyErr error
+		if event.IsBase64Encoded {
+			decoded, err := base64.StdEncoding.DecodeString(event.Body)
+			if err != nil {
+				bodyErr = BadRequest("Invalid request body encoding")
+			}
+			body = string(decoded)
+		}
+
+		request := &Request{
+			Method:   event.HTTPMethod,
+			Path:     event.Path,
+			Query:    event.QueryStringParameters,
+			Headers:  map[string]string{},
+			Body:     body,
+			ctx:      ctx,
+			buffered: true,
+			bodyErr:  bodyErr,
+		}
+		if request.Query == nil {
+			request.Query = map[string]string{}
+		}
+		for k, v := range event.Headers {
+			request.Headers[k] = v
+		}
+		// Links must include the stage, which API Gateway strips from the path
+		if request.Header("X-Forwarded-Prefix") == "" && event.RequestContext.Stage != "" {
+			request.Headers["X-Forwarded-Prefix"] = "/" + event.RequestContext.Stage
+		}
+		// Correlate logs with API Gateway when the caller sent no request ID
+		if request.Header(RequestIDHeader) == "" && event.RequestContext.RequestID != "" {
+			request.Headers[RequestIDHeader] = event.RequestContext.RequestID
+		}
+
+		response, err := handler(request)
+		if err != nil {
+			response = errorResponse(request, err)
+		}
+		// API Gateway needs the whole body up front
+		if err := response.bufferStream(); err != nil {
+			response = streamErrorResponse(request, response, err)
+		}
+
+		// API Gateway only passes binary bodies, like gzip output, base64 encoded
+		if response.Headers["Content-Encoding"] != "" {
+			return events.APIGatewayProxyResponse{
+				StatusCode:      response.StatusCode,
+				Headers:         response.Headers,
+				Body:            base64.StdEncoding.EncodeToString([]byte(response.Body)),
+				IsBase64Encoded: true,
+			}, nil
+		}
+
+		return events.APIGatewayProxyResponse{
+			StatusCode: response.StatusCode,
+			Headers:    response.Headers,
+			Body:       response.Body,
+		}, nil
+	}
+}
+
+// streamErrorResponse replaces a response whose stream failed while being
+// buffered. The middleware has already run by then, so the request ID and CORS
+// headers it set are carried over to the error.
+func streamErrorResponse(request *Request, failed *Response, err error) *Response {
+	id := failed.Headers[RequestIDHeader]
+	response := errorResponse(request.WithContext(context.WithValue(request.Context(), requestIDKey{}, id)), err)
+	for k, v := range failed.Headers {
+		if k == RequestIDHeader || strings.HasPrefix(k, "Access-Control-") {
+			response.Headers[k] = v
+		}
+	}
+	return response
+}
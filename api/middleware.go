@@ -0,0 +1,199 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// Middleware wraps a HandlerFunc with cross-cutting behaviour.
+type Middleware func(HandlerFunc) HandlerFunc
+
+// Chain wraps handler so the first middleware is the outermost.
+func Chain(handler HandlerFunc, middleware ...Middleware) HandlerFunc {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
+
+// DefaultMiddleware is the stack every API route runs behind, outermost first.
+func DefaultMiddleware() []Middleware {
+	return []Middleware{
+		RequestIDMiddleware,
+		LoggingMiddleware,
+		CORSMiddleware,
+		CompressionMiddleware,
+		ErrorEnvelopeMiddleware,
+		RecoveryMiddleware,
+	}
+}
+
+type requestIDKey struct{}
+
+// RequestIDHeader carries the request ID in both directions.
+const RequestIDHeader = "X-Request-Id"
+
+// RequestID returns the ID assigned to the request by RequestIDMiddleware.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// RequestIDMiddleware reuses the caller's X-Request-Id or generates one, stores
+// it in the request context and echoes it on the response.
+func RequestIDMiddleware(next HandlerFunc) HandlerFunc {
+	return func(request *Request) (*Response, error) {
+		id := request.Header(RequestIDHeader)
+		if id == "" {
+			id = newRequestID()
+		}
+		request = request.WithContext(context.WithValue(request.Context(), requestIDKey{}, id))
+
+		response, err := next(request)
+		if response != nil {
+			setHeader(response, RequestIDHeader, id)
+		}
+		return response, err
+	}
+}
+
+// LoggingMiddleware logs one line per request with its status and duration.
+func LoggingMiddleware(next HandlerFunc) HandlerFunc {
+	return func(request *Request) (*Response, error) {
+		start := time.Now()
+		response, err := next(request)
+
+		status := 0
+		if response != nil {
+			status = response.StatusCode
+		}
+		log.Printf("%s %s %d %s request_id=%s",
+			request.Method, request.Path, status, time.Since(start).Round(time.Microsecond), RequestID(request.Context()))
+		return response, err
+	}
+}
+
+// CORSMiddleware adds the CORS headers the public API has always sent.
+// Headers already set by the handler, like a preflight's allowed methods, win.
+func CORSMiddleware(next HandlerFunc) HandlerFunc {
+	return func(request *Request) (*Response, error) {
+		response, err := next(request)
+		if response == nil {
+			return response, err
+		}
+		defaults := map[string]string{
+			"Access-Control-Allow-Origin":   "*",
+			"Access-Control-Allow-Methods":  "GET, POST, DELETE, OPTIONS",
+			"Access-Control-Allow-Headers":  "Content-Type, " + RequestIDHeader,
+			"Access-Control-Expose-Headers": RequestIDHeader + ", Link",
+		}
+		for k, v := range defaults {
+			if _, ok := response.Headers[k]; !ok {
+				setHeader(response, k, v)
+			}
+		}
+		return response, err
+	}
+}
+
+// minCompressSize is the smallest body worth gzipping.
+const minCompressSize = 1024
+
+// CompressionMiddleware gzips larger bodies for clients that accept it.
+func CompressionMiddleware(next HandlerFunc) HandlerFunc {
+	return func(request *Request) (*Response, error) {
+		response, err := next(request)
+		if response == nil || response.Headers["Content-Encoding"] != "" ||
+			!strings.Contains(request.Header("Accept-Encoding"), "gzip") {
+			return response, err
+		}
+
+		// Event streams must reach the client event by event, which gzip would hold back
+		if strings.HasPrefix(response.Headers["Content-Type"], "text/event-stream") {
+			return response, err
+		}
+
+		// Streams are compressed as they are written, whatever their size
+		if stream := response.Stream; stream != nil {
+			response.Stream = func(w io.Writer) error {
+				zw := gzip.NewWriter(w)
+				if err := stream(zw); err != nil {
+					zw.Close()
+					return err
+				}
+				return zw.Close()
+			}
+			setHeader(response, "Content-Encoding", "gzip")
+			setHeader(response, "Vary", "Accept-Encoding")
+			return response, err
+		}
+
+		if len(response.Body) < minCompressSize {
+			return response, err
+		}
+
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, werr := zw.Write([]byte(response.Body)); werr != nil {
+			return response, err
+		}
+		if werr := zw.Close(); werr != nil {
+			return response, err
+		}
+		response.Body = buf.String()
+		setHeader(response, "Content-Encoding", "gzip")
+		setHeader(response, "Vary", "Accept-Encoding")
+		return response, err
+	}
+}
+
+// ErrorEnvelopeMiddleware turns errors returned by handlers into the standard
+// JSON error envelope, so adapters only ever see responses.
+func ErrorEnvelopeMiddleware(next HandlerFunc) HandlerFunc {
+	return func(request *Request) (*Response, error) {
+		response, err := next(request)
+		if err != nil {
+			return errorResponse(request, err), nil
+		}
+		return response, nil
+	}
+}
+
+// RecoveryMiddleware converts a panicking handler into an internal error.
+func RecoveryMiddleware(next HandlerFunc) HandlerFunc {
+	return func(request *Request) (response *Response, err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				log.Printf("Panic handling %s %s: %v\n%s", request.Method, request.Path, p, debug.Stack())
+				response, err = nil, Internal("Internal server error", fmt.Errorf("panic: %v", p))
+			}
+		}()
+		return next(request)
+	}
+}
+
+// setHeader sets a response header, allocating the map if needed.
+func setHeader(response *Response, key, value string) {
+	if response.Headers == nil {
+		response.Headers = map[string]string{}
+	}
+	response.Headers[key] = value
+}
+
+// newRequestID returns a random 16-byte hex ID.
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"encoding/base64"
+	"strings"
+	"time"
+
+	"github.com/Ward-R/Jishin-API/db"
+	"github.com/Ward-R/Jishin-API/types"
+)
+
+// encodeCursor turns a keyset position into the opaque token clients pass
+// back as ?cursor=.
+func encodeCursor(c *db.Cursor) string {
+	raw := c.OriginTime.UTC().Format(time.RFC3339Nano) + "|" + c.ReportId
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeCursor parses a token produced by encodeCursor.
+func decodeCursor(token string) (*db.Cursor, bool) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, false
+	}
+	parts := strings.SplitN(string(raw), "|", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return nil, false
+	}
+	t, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return nil, false
+	}
+	return &db.Cursor{OriginTime: t, ReportId: parts[1]}, true
+}
+
+// Cursor parses an opaque pagination cursor, returning nil when absent.
+func (p *queryParser) Cursor(name string) *db.Cursor {
+	raw, ok := p.value(name)
+	if !ok {
+		return nil
+	}
+	cursor, ok := decodeCursor(raw)
+	if !ok {
+		p.fail(name, "is not a valid cursor")
+		return nil
+	}
+	return cursor
+}
+
+// nextPageLink builds the RFC 8288 Link header for the page after this one. The
+// target is a query-only reference so it resolves against whatever base path
+// (e.g. an API Gateway stage) the client used.
+func nextPageLink(request *Request, next *db.Cursor) string {
+	query := request.QueryValues()
+	query.Set("cursor", encodeCursor(next))
+	return `<?` + query.Encode() + `>; rel="next"`
+}
+
+// pageResponse wraps a page of earthquakes as
+//
+//	{"count": n, "earthquakes": [...], "next_cursor": "..."}
+//
+// plus any extra fields, and sets the Link header when there is a next page.
+func pageResponse(request *Request, page *db.EarthquakePage, extra map[string]interface{}) (*Response, error) {
+	earthquakes := page.Earthquakes
+	if earthquakes == nil {
+		earthquakes = []types.Earthquake{}
+	}
+	response := map[string]interface{}{
+		"count":       len(earthquakes),
+		"earthquakes": earthquakes,
+		"next_cursor": nil,
+	}
+	for k, v := range extra {
+		response[k] = v
+	}
+	if page.Next != nil {
+		response["next_cursor"] = encodeCursor(page.Next)
+	}
+
+	res, err := JSON(200, response)
+	if err != nil {
+		return nil, err
+	}
+	if page.Next != nil {
+		setHeader(res, "Link", nextPageLink(request, page.Next))
+	}
+	return res, nil
+}
@@ -0,0 +1,173 @@
+package api
+
+import (
+	"encoding/xml"
+	"fmt"
+	"time"
+
+	"github.com/Ward-R/Jishin-API/db"
+	"github.com/Ward-R/Jishin-API/types"
+)
+
+// quakeMLAuthority prefixes every QuakeML resource identifier. IDs are derived
+// from the JMA report ID only, so they stay stable across revisions.
+const quakeMLAuthority = "smi:jishin-api"
+
+// QuakeML 1.2 namespaces: the quakeml root element and the Basic Event
+// Description (BED) it contains.
+const (
+	quakeMLNamespace    = "http://quakeml.org/xmlns/quakeml/1.2"
+	quakeMLBEDNamespace = "http://quakeml.org/xmlns/bed/1.2"
+)
+
+type quakeMLDocument struct {
+	XMLName         xml.Name               `xml:"q:quakeml"`
+	QNamespace      string                 `xml:"xmlns:q,attr"`
+	Namespace       string                 `xml:"xmlns,attr"`
+	EventParameters quakeMLEventParameters `xml:"eventParameters"`
+}
+
+type quakeMLEventParameters struct {
+	PublicID     string              `xml:"publicID,attr"`
+	Events       []quakeMLEvent      `xml:"event"`
+	CreationInfo quakeMLCreationInfo `xml:"creationInfo"`
+}
+
+type quakeMLEvent struct {
+	PublicID             string               `xml:"publicID,attr"`
+	PreferredOriginID    string               `xml:"preferredOriginID,omitempty"`
+	PreferredMagnitudeID string               `xml:"preferredMagnitudeID,omitempty"`
+	Type                 string               `xml:"type"`
+	Descriptions         []quakeMLDescription `xml:"description"`
+	CreationInfo         quakeMLCreationInfo  `xml:"creationInfo"`
+	Origin               *quakeMLOrigin       `xml:"origin,omitempty"`
+	Magnitude            *quakeMLMagnitude    `xml:"magnitude,omitempty"`
+}
+
+type quakeMLDescription struct {
+	Text string `xml:"text"`
+	Type string `xml:"type"`
+}
+
+type quakeMLCreationInfo struct {
+	AgencyID     string `xml:"agencyID,omitempty"`
+	CreationTime string `xml:"creationTime,omitempty"`
+	Version      string `xml:"version,omitempty"`
+}
+
+type quakeMLOrigin struct {
+	PublicID  string       `xml:"publicID,attr"`
+	Time      quakeMLValue `xml:"time"`
+	Latitude  quakeMLValue `xml:"latitude"`
+	Longitude quakeMLValue `xml:"longitude"`
+	Depth     quakeMLValue `xml:"depth"`
+}
+
+type quakeMLMagnitude struct {
+	PublicID string       `xml:"publicID,attr"`
+	Mag      quakeMLValue `xml:"mag"`
+	Type     string       `xml:"type"`
+	OriginID string       `xml:"originID,omitempty"`
+}
+
+type quakeMLValue struct {
+	Value string `xml:"value"`
+}
+
+// quakeMLID builds a resource identifier such as smi:jishin-api/event/<id>.
+func quakeMLID(kind, reportID string) string {
+	return fmt.Sprintf("%s/%s/%s", quakeMLAuthority, kind, reportID)
+}
+
+// newQuakeMLEvent maps an earthquake to a QuakeML event. The JMA magnitude is
+// typed Mj, depth is in metres, and the max intensity (震度), which BED has no
+// element for, is given as a "felt report" description. Cancelled earthquakes
+// are typed "not existing".
+func newQuakeMLEvent(eq *types.Earthquake) quakeMLEvent {
+	event := quakeMLEvent{
+		PublicID: quakeMLID("event", eq.ReportId),
+		Type:     "earthquake",
+		CreationInfo: quakeMLCreationInfo{
+			AgencyID: "JMA",
+			Version:  fmt.Sprint(eq.Serial),
+		},
+	}
+	if eq.Retracted {
+		event.Type = "not existing"
+	}
+	if !eq.ReportDateTime.IsZero() {
+		event.CreationInfo.CreationTime = quakeMLTime(eq.ReportDateTime)
+	}
+
+	for _, name := range []string{eq.EnLocation, eq.JpLocation} {
+		if name != "" {
+			event.Descriptions = append(event.Descriptions, quakeMLDescription{Text: name, Type: "region name"})
+		}
+	}
+	if eq.MaxIntensity != "" {
+		event.Descriptions = append(event.Descriptions, quakeMLDescription{
+			Text: "JMA maximum seismic intensity " + eq.MaxIntensity,
+			Type: "felt report",
+		})
+	}
+
+	// Intensity-only reports have no hypocenter yet
+	if eq.Latitude != 0 || eq.Longitude != 0 {
+		event.Origin = &quakeMLOrigin{
+			PublicID:  quakeMLID("origin", eq.ReportId),
+			Time:      quakeMLValue{quakeMLTime(eq.OriginTime)},
+			Latitude:  quakeMLValue{fmt.Sprint(eq.Latitude)},
+			Longitude: quakeMLValue{fmt.Sprint(eq.Longitude)},
+			Depth:     quakeMLValue{fmt.Sprint(eq.DepthKm * 1000)},
+		}
+		event.PreferredOriginID = event.Origin.PublicID
+	}
+	if eq.Magnitude != 0 {
+		event.Magnitude = &quakeMLMagnitude{
+			PublicID: quakeMLID("magnitude", eq.ReportId),
+			Mag:      quakeMLValue{fmt.Sprint(eq.Magnitude)},
+			Type:     "Mj",
+			OriginID: event.PreferredOriginID,
+		}
+		event.PreferredMagnitudeID = event.Magnitude.PublicID
+	}
+	return event
+}
+
+// quakeMLTime formats t as the UTC timestamp QuakeML expects.
+func quakeMLTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000000Z")
+}
+
+// quakeMLResponse renders earthquakes as a QuakeML 1.2 document.
+func quakeMLResponse(earthquakes []types.Earthquake) (*Response, error) {
+	doc := quakeMLDocument{
+		QNamespace: quakeMLNamespace,
+		Namespace:  quakeMLBEDNamespace,
+		EventParameters: quakeMLEventParameters{
+			PublicID: quakeMLAuthority + "/eventParameters",
+			CreationInfo: quakeMLCreationInfo{
+				AgencyID:     "JMA",
+				CreationTime: quakeMLTime(time.Now()),
+			},
+		},
+	}
+	for i := range earthquakes {
+		doc.EventParameters.Events = append(doc.EventParameters.Events, newQuakeMLEvent(&earthquakes[i]))
+	}
+
+	return xmlResponse(formatMediaTypes[FormatQuakeML], doc)
+}
+
+// quakeMLPageResponse renders a page of a listing as QuakeML, linking the next
+// page with the Link header.
+func quakeMLPageResponse(request *Request, page *db.EarthquakePage) (*Response, error) {
+	res, err := quakeMLResponse(page.Earthquakes)
+	if err != nil {
+		return nil, err
+	}
+	if page.Next != nil {
+		setHeader(res, "Link", nextPageLink(request, page.Next))
+	}
+	return res, nil
+}
@@ -0,0 +1,187 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Router matches requests to handlers by method and path pattern. Patterns are
+// slash-separated and may contain parameters like "/earthquake/{id}", whose
+// values are available from Request.PathParam. Both the Lambda and HTTP
+// adapters serve a Router via its ServeRequest method.
+type Router struct {
+	routes     []*route
+	middleware []Middleware
+}
+
+type route struct {
+	pattern  string
+	segments []string
+	handlers map[string]HandlerFunc
+}
+
+// NewRouter returns an empty Router.
+func NewRouter() *Router {
+	return &Router{}
+}
+
+// Handle registers handler for method requests matching pattern.
+func (rt *Router) Handle(method, pattern string, handler HandlerFunc) {
+	method = strings.ToUpper(method)
+	for _, r := range rt.routes {
+		if r.pattern == pattern {
+			r.handlers[method] = handler
+			return
+		}
+	}
+	rt.routes = append(rt.routes, &route{
+		pattern:  pattern,
+		segments: splitPath(pattern),
+		handlers: map[string]HandlerFunc{method: handler},
+	})
+}
+
+// Use appends middleware that wraps every request the router serves, including
+// 404, 405 and OPTIONS responses.
+func (rt *Router) Use(middleware ...Middleware) {
+	rt.middleware = append(rt.middleware, middleware...)
+}
+
+// GET registers a handler for GET requests.
+func (rt *Router) GET(pattern string, handler HandlerFunc) {
+	rt.Handle(http.MethodGet, pattern, handler)
+}
+
+// POST registers a handler for POST requests.
+func (rt *Router) POST(pattern string, handler HandlerFunc) {
+	rt.Handle(http.MethodPost, pattern, handler)
+}
+
+// DELETE registers a handler for DELETE requests.
+func (rt *Router) DELETE(pattern string, handler HandlerFunc) {
+	rt.Handle(http.MethodDelete, pattern, handler)
+}
+
+// ServeRequest dispatches request to the matching handler. Unknown paths get a
+// 404, known paths with an unregistered method a 405 with an Allow header, and
+// OPTIONS requests are answered automatically for CORS preflight. HEAD falls
+// back to the GET handler with the body dropped.
+func (rt *Router) ServeRequest(request *Request) (*Response, error) {
+	return Chain(rt.dispatch, rt.middleware...)(request)
+}
+
+func (rt *Router) dispatch(request *Request) (*Response, error) {
+	if request.bodyErr != nil {
+		return nil, request.bodyErr
+	}
+	r, params := rt.match(request.Path)
+	if r == nil {
+		return nil, NotFound("Not found")
+	}
+	request.PathParams = params
+
+	method := strings.ToUpper(request.Method)
+	if handler, ok := r.handlers[method]; ok {
+		return handler(request)
+	}
+
+	allow := r.allowedMethods()
+	switch method {
+	case http.MethodOptions:
+		return &Response{
+			StatusCode: 204,
+			Headers: map[string]string{
+				"Allow":                        allow,
+				"Access-Control-Allow-Methods": allow,
+				"Access-Control-Max-Age":       "86400",
+			},
+		}, nil
+	case http.MethodHead:
+		if handler, ok := r.handlers[http.MethodGet]; ok {
+			response, err := handler(request)
+			// Dropping Stream too skips the export or stream it would write
+			if response != nil {
+				response.Body = ""
+				response.Stream = nil
+			}
+			return response, err
+		}
+	}
+
+	return nil, &Error{
+		Status:  405,
+		Code:    CodeMethodNotAllowed,
+		Message: "Method not allowed",
+		Headers: map[string]string{"Allow": allow},
+	}
+}
+
+// match finds the route for path, preferring routes with more literal
+// segments so "/earthquakes/recent" wins over a "/earthquakes/{id}" pattern.
+func (rt *Router) match(path string) (*route, map[string]string) {
+	segments := splitPath(path)
+
+	var best *route
+	var bestParams map[string]string
+	bestLiterals := -1
+	for _, r := range rt.routes {
+		if len(r.segments) != len(segments) {
+			continue
+		}
+		params := map[string]string{}
+		literals := 0
+		matched := true
+		for i, seg := range r.segments {
+			if name, ok := paramName(seg); ok {
+				if segments[i] == "" {
+					matched = false
+					break
+				}
+				params[name] = segments[i]
+				continue
+			}
+			if seg != segments[i] {
+				matched = false
+				break
+			}
+			literals++
+		}
+		if matched && literals > bestLiterals {
+			best, bestParams, bestLiterals = r, params, literals
+		}
+	}
+	return best, bestParams
+}
+
+// allowedMethods lists the methods a route answers, for Allow headers.
+func (r *route) allowedMethods() string {
+	methods := []string{http.MethodOptions}
+	for m := range r.handlers {
+		methods = append(methods, m)
+	}
+	if _, ok := r.handlers[http.MethodGet]; ok {
+		if _, ok := r.handlers[http.MethodHead]; !ok {
+			methods = append(methods, http.MethodHead)
+		}
+	}
+	sort.Strings(methods)
+	return strings.Join(methods, ", ")
+}
+
+// splitPath splits a path into segments, ignoring leading and trailing slashes.
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
+
+// paramName returns the name of a "{name}" pattern segment.
+func paramName(segment string) (string, bool) {
+	if len(segment) > 2 && strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+		return segment[1 : len(segment)-1], true
+	}
+	return "", false
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"io"
+	"testing"
+)
+
+func TestRouter(t *testing.T) {
+	streamed := false
+	r := NewRouter()
+	r.GET("/earthquakes/recent", func(request *Request) (*Response, error) {
+		return &Response{StatusCode: 200, Body: "recent"}, nil
+	})
+	r.GET("/earthquake/{id}", func(request *Request) (*Response, error) {
+		return &Response{StatusCode: 200, Body: "id " + request.PathParam("id")}, nil
+	})
+	r.GET("/export", func(request *Request) (*Response, error) {
+		return &Response{StatusCode: 200, Stream: func(w io.Writer) error {
+			streamed = true
+			_, err := io.WriteString(w, "rows")
+			return err
+		}}, nil
+	})
+
+	tests := []struct {
+		method, path string
+		status       int
+		body         string
+	}{
+		{"GET", "/earthquakes/recent", 200, "recent"},
+		{"GET", "/earthquake/20250812113450", 200, "id 20250812113450"},
+		{"GET", "/earthquake/20250812113450/", 200, "id 20250812113450"},
+		{"GET", "/missing", 404, ""},
+		{"POST", "/earthquake/1", 405, ""},
+		{"OPTIONS", "/earthquake/1", 204, ""},
+		{"HEAD", "/earthquake/1", 200, ""},
+	}
+	for _, tt := range tests {
+		response, err := r.ServeRequest(&Request{Method: tt.method, Path: tt.path})
+		if err != nil {
+			response = errorResponse(&Request{}, err)
+		}
+		if response.StatusCode != tt.status || (tt.status < 400 && response.Body != tt.body) {
+			t.Errorf("%s %s = %d %q, want %d %q", tt.method, tt.path, response.StatusCode, response.Body, tt.status, tt.body)
+		}
+	}
+
+	response, err := r.ServeRequest(&Request{Method: "HEAD", Path: "/export"})
+	if err != nil {
+		t.Fatalf("HEAD /export: %v", err)
+	}
+	if response.Stream != nil || response.Body != "" || streamed {
+		t.Errorf("HEAD /export kept its body: stream set %v, body %q, streamed %v", response.Stream != nil, response.Body, streamed)
+	}
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"github.com/Ward-R/Jishin-API/db"
+	"github.com/Ward-R/Jishin-API/service"
+)
+
+// NewAPIRouter registers every endpoint of the API. The returned router is
+// shared by the Lambda and standalone HTTP entrypoints; POST /sync fetches
+// from JMA through jma.
+func NewAPIRouter(store db.EarthquakeStore, jma *service.JMAClient) *Router {
+	r := NewRouter()
+	r.Use(DefaultMiddleware()...)
+
+	r.GET("/", func(request *Request) (*Response, error) {
+		return HandleRoot(store)
+	})
+	r.GET("/health", func(request *Request) (*Response, error) {
+		return HandleHealth(store, request)
+	})
+	r.GET("/earthquakes", func(request *Request) (*Response, error) {
+		return HandleEarthquakes(store, request) // Needs ?Limit=X&magnitude=Y
+	})
+	r.GET("/earthquakes/stats", func(request *Request) (*Response, error) {
+		return HandleStats(store, request)
+	})
+	r.GET("/earthquakes/recent", func(request *Request) (*Response, error) {
+		return HandleRecent(store, request)
+	})
+	r.GET("/earthquakes/largest/today", func(request *Request) (*Response, error) {
+		return HandleLargestToday(store, request)
+	})
+	r.GET("/earthquakes/largest/week", func(request *Request) (*Response, error) {
+		return HandleLargestWeek(store, request)
+	})
+	r.GET("/earthquake/{id}", func(request *Request) (*Response, error) {
+		return HandleEarthquakeById(store, request)
+	})
+	r.GET("/earthquake/{id}/stations", func(request *Request) (*Response, error) {
+		return HandleEarthquakeStations(store, request)
+	})
+	r.GET("/earthquake/{id}/intensity", func(request *Request) (*Response, error) {
+		return HandleEarthquakeIntensity(store, request) // Optional ?level=pref|area|city
+	})
+	r.GET("/earthquake/{id}/revisions", func(request *Request) (*Response, error) {
+		return HandleEarthquakeRevisions(store, request)
+	})
+	r.GET("/feeds/earthquakes.atom", func(request *Request) (*Response, error) {
+		return HandleAtomFeed(store, request)
+	})
+	r.GET("/feeds/earthquakes.rss", func(request *Request) (*Response, error) {
+		return HandleRSSFeed(store, request)
+	})
+	r.GET("/feeds/cap.atom", func(request *Request) (*Response, error) {
+		return HandleCAPFeed(store, request)
+	})
+	r.GET("/fdsnws/event/1/query", fdsnErrors(func(request *Request) (*Response, error) {
+		return HandleFDSNQuery(store, request)
+	}))
+	r.GET("/fdsnws/event/1/version", func(request *Request) (*Response, error) {
+		return HandleFDSNVersion()
+	})
+	r.GET("/fdsnws/event/1/catalogs", func(request *Request) (*Response, error) {
+		return HandleFDSNCatalogs()
+	})
+	r.GET("/fdsnws/event/1/contributors", func(request *Request) (*Response, error) {
+		return HandleFDSNContributors()
+	})
+	r.GET("/fdsnws/event/1/application.wadl", func(request *Request) (*Response, error) {
+		return HandleFDSNWADL()
+	})
+	r.POST("/subscriptions", func(request *Request) (*Response, error) {
+		return HandleCreateSubscription(store, request)
+	})
+	r.GET("/subscriptions/{id}", func(request *Request) (*Response, error) {
+		return HandleGetSubscription(store, request)
+	})
+	r.DELETE("/subscriptions/{id}", func(request *Request) (*Response, error) {
+		return HandleDeleteSubscription(store, request)
+	})
+	r.GET("/subscriptions/{id}/deliveries", func(request *Request) (*Response, error) {
+		return HandleSubscriptionDeliveries(store, request) // Optional ?limit=
+	})
+	r.POST("/sync", func(request *Request) (*Response, error) {
+		return HandleSync(store, jma, request)
+	})
+
+	return r
+}
+
+// RegisterStreamRoutes adds the endpoints that hold a connection open. Only
+// the standalone HTTP mode registers them; Lambda buffers whole responses.
+func RegisterStreamRoutes(r *Router, broker *service.Broker) {
+	r.GET("/stream/earthquakes", func(request *Request) (*Response, error) {
+		return HandleStream(broker, request) // Optional ?min_magnitude=&region=&bbox=
+	})
+}
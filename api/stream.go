@@ -0,0 +1,168 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Ward-R/Jishin-API/db"
+	"github.com/Ward-R/Jishin-API/service"
+	"github.com/Ward-R/Jishin-API/types"
+)
+
+// streamHeartbeat is how often an idle stream sends a comment, which keeps
+// proxies from timing it out and notices clients that have gone away.
+const streamHeartbeat = 25 * time.Second
+
+// streamRetry is the reconnection delay suggested to EventSource clients.
+const streamRetry = 5 * time.Second
+
+// streamFilter selects which events a stream client receives.
+type streamFilter struct {
+	MinMagnitude *float64
+	// Region matches the hypocenter name or any prefecture, area or city that
+	// observed shaking, by code or case-insensitive substring of its name.
+	Region string
+	BBox   *db.BoundingBox
+}
+
+// parseStreamFilter reads min_magnitude, region and bbox.
+func parseStreamFilter(query map[string]string) (streamFilter, error) {
+	p := newQueryParser(query)
+	filter := streamFilter{
+		MinMagnitude: p.OptionalFloat("min_magnitude", 0, 10),
+		Region:       strings.ToLower(strings.TrimSpace(query["region"])),
+		BBox:         p.BBox("bbox"),
+	}
+	return filter, p.Err()
+}
+
+// matches reports whether eq passes the filter.
+func (f streamFilter) matches(eq *types.Earthquake) bool {
+	if f.MinMagnitude != nil && eq.Magnitude < *f.MinMagnitude {
+		return false
+	}
+	// Intensity-only reports have no epicenter to test
+	if f.BBox != nil && ((eq.Latitude == 0 && eq.Longitude == 0) || !f.BBox.Contains(eq.Latitude, eq.Longitude)) {
+		return false
+	}
+	if f.Region != "" && !matchesRegion(eq, f.Region) {
+		return false
+	}
+	return true
+}
+
+// matchesRegion reports whether region, already lower-cased, names the
+// hypocenter or an observed region of eq.
+func matchesRegion(eq *types.Earthquake, region string) bool {
+	for _, name := range []string{eq.JpLocation, eq.EnLocation} {
+		if strings.Contains(strings.ToLower(name), region) {
+			return true
+		}
+	}
+	for _, r := range eq.Regions {
+		if r.Code == region || strings.Contains(strings.ToLower(r.JpName), region) ||
+			strings.Contains(strings.ToLower(r.EnName), region) {
+			return true
+		}
+	}
+	return false
+}
+
+// HandleStream serves /stream/earthquakes, a Server-Sent Events stream of the
+// earthquakes each sync inserts, revises or retracts. A reconnecting client's
+// Last-Event-ID replays the events it missed, as far as the broker remembers.
+// It needs a long-lived connection, so it is only routed in the HTTP mode.
+func HandleStream(broker *service.Broker, request *Request) (*Response, error) {
+	filter, err := parseStreamFilter(request.Query)
+	if err != nil {
+		return nil, err
+	}
+	// A new client only gets events from now on
+	lastID := uint64(math.MaxUint64)
+	if raw := request.Header("Last-Event-ID"); raw != "" {
+		lastID, err = strconv.ParseUint(strings.TrimSpace(raw), 10, 64)
+		if err != nil {
+			return nil, BadRequest("Last-Event-ID must be an event ID from this stream")
+		}
+	}
+
+	stream := func(w io.Writer) error {
+		// Streams outlive the server's write timeout
+		if rw, ok := w.(http.ResponseWriter); ok {
+			controller := http.NewResponseController(rw)
+			controller.SetWriteDeadline(time.Time{})
+		}
+
+		replay, events, cancel := broker.Subscribe(lastID)
+		defer cancel()
+
+		if _, err := fmt.Fprintf(w, "retry: %d\n\n", streamRetry.Milliseconds()); err != nil {
+			return nil
+		}
+		for i := range replay {
+			if err := writeStreamEvent(w, &replay[i], filter); err != nil {
+				return nil
+			}
+		}
+		flushStream(w)
+
+		heartbeat := time.NewTicker(streamHeartbeat)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case <-request.Context().Done():
+				return nil
+			case event, ok := <-events:
+				if !ok {
+					// Dropped for falling behind; the client resumes from its last ID
+					return nil
+				}
+				if err := writeStreamEvent(w, &event, filter); err != nil {
+					return nil
+				}
+			case <-heartbeat.C:
+				if _, err := io.WriteString(w, ": heartbeat\n\n"); err != nil {
+					return nil
+				}
+			}
+			flushStream(w)
+		}
+	}
+
+	return &Response{
+		StatusCode: 200,
+		Headers: map[string]string{
+			"Content-Type":      "text/event-stream; charset=utf-8",
+			"Cache-Control":     "no-cache",
+			"X-Accel-Buffering": "no",
+		},
+		Stream: stream,
+	}, nil
+}
+
+// writeStreamEvent writes one event if it passes the filter. The data is the
+// earthquake as /earthquake/{id} returns it.
+func writeStreamEvent(w io.Writer, event *service.Event, filter streamFilter) error {
+	if !filter.matches(&event.Earthquake) {
+		return nil
+	}
+	data, err := json.Marshal(event.Earthquake)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
+
+// flushStream pushes buffered output to the client.
+func flushStream(w io.Writer) {
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/Ward-R/Jishin-API/service"
+	"github.com/Ward-R/Jishin-API/types"
+)
+
+func TestStreamRegionFilter(t *testing.T) {
+	fukushima := []types.RegionIntensity{
+		{Level: types.RegionLevelPref, Code: "07", JpName: "福島県", EnName: "Fukushima", MaxIntensity: "3"},
+	}
+	broker := service.NewBroker(10)
+	// Sync publishes retractions and hypocenter-only updates with the regions
+	// already stored for the earthquake
+	broker.Publish(service.EventCreated, &types.Earthquake{ReportId: "a", EnLocation: "Off the Coast of Ibaraki", Regions: fukushima})
+	broker.Publish(service.EventUpdated, &types.Earthquake{ReportId: "a", EnLocation: "Off the Coast of Ibaraki", Magnitude: 4.4, Regions: fukushima})
+	broker.Publish(service.EventRetracted, &types.Earthquake{ReportId: "a", EnLocation: "Off the Coast of Ibaraki", Retracted: true, Regions: fukushima})
+	broker.Publish(service.EventCreated, &types.Earthquake{ReportId: "b", EnLocation: "Hyuganada Sea"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	request := (&Request{
+		Method:  "GET",
+		Path:    "/stream/earthquakes",
+		Query:   map[string]string{"region": "Fukushima"},
+		Headers: map[string]string{"Last-Event-ID": "0"},
+	}).WithContext(ctx)
+	response, err := HandleStream(broker, request)
+	if err != nil {
+		t.Fatalf("HandleStream: %v", err)
+	}
+	var body strings.Builder
+	if err := response.Stream(&body); err != nil {
+		t.Fatalf("streaming: %v", err)
+	}
+
+	for _, want := range []string{"event: " + service.EventCreated, "event: " + service.EventUpdated, "event: " + service.EventRetracted} {
+		if !strings.Contains(body.String(), want) {
+			t.Errorf("stream filtered out %q:\n%s", want, body.String())
+		}
+	}
+	if strings.Contains(body.String(), "Hyuganada") {
+		t.Errorf("stream sent an earthquake outside Fukushima:\n%s", body.String())
+	}
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// Request is a transport-agnostic HTTP request. The Lambda and net/http
+// adapters both translate their native request into one of these so every
+// handler runs unchanged in either mode.
+type Request struct {
+	Method  string
+	Path    string
+	Query   map[string]string
+	Headers map[string]string
+	Body    string
+	// PathParams holds the values of "{name}" segments in the matched route.
+	PathParams map[string]string
+
+	ctx context.Context
+	// buffered is set by adapters that hold the whole response in memory
+	// before sending it, like Lambda behind API Gateway.
+	buffered bool
+	// bodyErr is set by adapters that could not read the body. The router
+	// answers with it, so it passes through the middleware like any error.
+	bodyErr error
+}
+
+// Context returns the request's context, never nil.
+func (r *Request) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// WithContext returns a shallow copy of r with its context replaced by ctx.
+func (r *Request) WithContext(ctx context.Context) *Request {
+	r2 := *r
+	r2.ctx = ctx
+	return &r2
+}
+
+// Header returns the value of a request header, ignoring case.
+func (r *Request) Header(name string) string {
+	if v, ok := r.Headers[name]; ok {
+		return v
+	}
+	canonical := http.CanonicalHeaderKey(name)
+	for k, v := range r.Headers {
+		if http.CanonicalHeaderKey(k) == canonical {
+			return v
+		}
+	}
+	return ""
+}
+
+// QueryValues returns the query string as url.Values, for building links.
+func (r *Request) QueryValues() url.Values {
+	values := url.Values{}
+	for k, v := range r.Query {
+		values.Set(k, v)
+	}
+	return values
+}
+
+// PathParam returns the value of a "{name}" segment of the matched route.
+func (r *Request) PathParam(name string) string {
+	return r.PathParams[name]
+}
+
+// Response is a transport-agnostic HTTP response written back by the adapters.
+type Response struct {
+	StatusCode int
+	Headers    map[string]string
+	Body       string
+	// Stream, when set, writes the body instead of Body. The HTTP adapter sends
+	// it to the client as it is produced; the Lambda adapter buffers it.
+	Stream func(w io.Writer) error
+}
+
+// bufferStream runs Stream into Body, for transports that cannot stream.
+func (r *Response) bufferStream() error {
+	if r.Stream == nil {
+		return nil
+	}
+	var buf bytes.Buffer
+	err := r.Stream(&buf)
+	r.Stream = nil
+	if err != nil {
+		return err
+	}
+	r.Body = buf.String()
+	return nil
+}
+
+// HandlerFunc handles a single request. A returned *Error is rendered in the
+// JSON error envelope with its status; any other error becomes a 500.
+type HandlerFunc func(request *Request) (*Response, error)
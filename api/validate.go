@@ -0,0 +1,302 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Ward-R/Jishin-API/db"
+	"github.com/Ward-R/Jishin-API/types"
+)
+
+// jst is Japan Standard Time; bare dates in filters are JST days, matching JMA.
+var jst = time.FixedZone("JST", 9*60*60)
+
+// Limits for the number of earthquakes a single list request may return.
+const (
+	DefaultLimit = 50
+	MaxLimit     = 1000
+)
+
+// FieldError describes why one query parameter was rejected.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// queryParser reads typed values out of a query string, collecting an error
+// per invalid field instead of stopping at the first one.
+type queryParser struct {
+	query  map[string]string
+	errors []FieldError
+}
+
+func newQueryParser(query map[string]string) *queryParser {
+	return &queryParser{query: query}
+}
+
+func (p *queryParser) fail(field, format string, args ...interface{}) {
+	p.errors = append(p.errors, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// value returns the trimmed raw value of a parameter and whether it was given.
+func (p *queryParser) value(name string) (string, bool) {
+	v := strings.TrimSpace(p.query[name])
+	return v, v != ""
+}
+
+// Int parses an integer within [min, max], returning def when absent.
+func (p *queryParser) Int(name string, def, min, max int) int {
+	raw, ok := p.value(name)
+	if !ok {
+		return def
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil {
+		p.fail(name, "must be an integer")
+		return def
+	}
+	if v < min || v > max {
+		p.fail(name, "must be between %d and %d", min, max)
+		return def
+	}
+	return v
+}
+
+// Float parses a number within [min, max], returning def when absent.
+func (p *queryParser) Float(name string, def, min, max float64) float64 {
+	raw, ok := p.value(name)
+	if !ok {
+		return def
+	}
+	v, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		p.fail(name, "must be a number")
+		return def
+	}
+	if math.IsNaN(v) || v < min || v > max {
+		p.fail(name, "must be between %g and %g", min, max)
+		return def
+	}
+	return v
+}
+
+// Bool parses true/false (also 1/0), returning false when absent.
+func (p *queryParser) Bool(name string) bool {
+	raw, ok := p.value(name)
+	if !ok {
+		return false
+	}
+	v, err := strconv.ParseBool(raw)
+	if err != nil {
+		p.fail(name, "must be true or false")
+		return false
+	}
+	return v
+}
+
+// OptionalInt is Int without a default, returning nil when absent or invalid.
+func (p *queryParser) OptionalInt(name string, min, max int) *int {
+	if _, ok := p.value(name); !ok {
+		return nil
+	}
+	before := len(p.errors)
+	v := p.Int(name, 0, min, max)
+	if len(p.errors) > before {
+		return nil
+	}
+	return &v
+}
+
+// OptionalFloat is Float without a default, returning nil when absent or invalid.
+func (p *queryParser) OptionalFloat(name string, min, max float64) *float64 {
+	if _, ok := p.value(name); !ok {
+		return nil
+	}
+	before := len(p.errors)
+	v := p.Float(name, 0, min, max)
+	if len(p.errors) > before {
+		return nil
+	}
+	return &v
+}
+
+// OptionalBool is Bool without a default, returning nil when absent or invalid.
+func (p *queryParser) OptionalBool(name string) *bool {
+	if _, ok := p.value(name); !ok {
+		return nil
+	}
+	before := len(p.errors)
+	v := p.Bool(name)
+	if len(p.errors) > before {
+		return nil
+	}
+	return &v
+}
+
+// Time parses an RFC 3339 timestamp, or a YYYY-MM-DD date meaning midnight in
+// Japan time, returning the zero time when absent.
+func (p *queryParser) Time(name string) time.Time {
+	raw, ok := p.value(name)
+	if !ok {
+		return time.Time{}
+	}
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t
+	}
+	if t, err := time.ParseInLocation("2006-01-02", raw, jst); err == nil {
+		return t
+	}
+	p.fail(name, "must be an RFC 3339 timestamp or a date in YYYY-MM-DD format")
+	return time.Time{}
+}
+
+// Intensity parses a JMA intensity class such as 4, 5- or 6強, returning ""
+// when absent.
+func (p *queryParser) Intensity(name string) string {
+	raw, ok := p.value(name)
+	if !ok {
+		return ""
+	}
+	// An unencoded "+" arrives as a space, which value trimmed off
+	if strings.HasSuffix(p.query[name], " ") && (raw == "5" || raw == "6") {
+		raw += "+"
+	}
+	intensity, ok := types.NormalizeIntensity(raw)
+	if !ok {
+		p.fail(name, "must be one of %s", strings.Join(types.IntensityScale, ", "))
+		return ""
+	}
+	return intensity
+}
+
+// BBox parses minLon,minLat,maxLon,maxLat, returning nil when absent. A box
+// with minLon greater than maxLon crosses the antimeridian.
+func (p *queryParser) BBox(name string) *db.BoundingBox {
+	raw, ok := p.value(name)
+	if !ok {
+		return nil
+	}
+	parts := strings.Split(raw, ",")
+	if len(parts) != 4 {
+		p.fail(name, "must be minLon,minLat,maxLon,maxLat")
+		return nil
+	}
+	var v [4]float64
+	for i, part := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || math.IsNaN(f) {
+			p.fail(name, "must be minLon,minLat,maxLon,maxLat")
+			return nil
+		}
+		v[i] = f
+	}
+	box := &db.BoundingBox{MinLon: v[0], MinLat: v[1], MaxLon: v[2], MaxLat: v[3]}
+	if box.MinLon < -180 || box.MinLon > 180 || box.MaxLon < -180 || box.MaxLon > 180 {
+		p.fail(name, "longitudes must be between -180 and 180")
+		return nil
+	}
+	if box.MinLat < -90 || box.MaxLat > 90 || box.MinLat > box.MaxLat {
+		p.fail(name, "latitudes must be between -90 and 90 with minLat <= maxLat")
+		return nil
+	}
+	return box
+}
+
+// Circle parses lat, lon and radius_km, which must be given together, returning
+// nil when none are present.
+func (p *queryParser) Circle() *db.Circle {
+	_, hasLat := p.value("lat")
+	_, hasLon := p.value("lon")
+	_, hasRadius := p.value("radius_km")
+	if !hasLat && !hasLon && !hasRadius {
+		return nil
+	}
+	if !hasLat || !hasLon || !hasRadius {
+		p.fail("radius_km", "lat, lon and radius_km must be given together")
+		return nil
+	}
+	before := len(p.errors)
+	circle := &db.Circle{
+		Latitude:  p.Float("lat", 0, -90, 90),
+		Longitude: p.Float("lon", 0, -180, 180),
+		RadiusKm:  p.Float("radius_km", 0, 0, 20038),
+	}
+	if len(p.errors) > before {
+		return nil
+	}
+	return circle
+}
+
+// Date parses a real calendar date in YYYY-MM-DD form, returning "" when absent.
+func (p *queryParser) Date(name string) string {
+	raw, ok := p.value(name)
+	if !ok {
+		return ""
+	}
+	if _, err := time.Parse("2006-01-02", raw); err != nil {
+		p.fail(name, "must be a valid date in YYYY-MM-DD format")
+		return ""
+	}
+	return raw
+}
+
+// Err returns a 400 listing every invalid field, or nil if all were valid.
+func (p *queryParser) Err() error {
+	if len(p.errors) == 0 {
+		return nil
+	}
+	return &Error{
+		Status:  400,
+		Code:    CodeInvalidParameters,
+		Message: "One or more query parameters are invalid",
+		Details: p.errors,
+	}
+}
+
+// parseEarthquakeFilter validates the query string shared by the earthquake
+// listings. The legacy limit=-1 ("everything") is still accepted but capped at
+// MaxLimit; use the cursor to page further. magnitude is kept as an alias of
+// min_magnitude.
+func parseEarthquakeFilter(query map[string]string, defaultLimit int) (db.EarthquakeFilter, error) {
+	p := newQueryParser(query)
+	filter := db.EarthquakeFilter{
+		Limit:            MaxLimit,
+		MinMagnitude:     p.OptionalFloat("min_magnitude", 0, 10),
+		MaxMagnitude:     p.OptionalFloat("max_magnitude", 0, 10),
+		MinDepth:         p.OptionalInt("min_depth", 0, 1000),
+		MaxDepth:         p.OptionalInt("max_depth", 0, 1000),
+		MinIntensity:     p.Intensity("min_intensity"),
+		Tsunami:          p.OptionalBool("tsunami"),
+		BBox:             p.BBox("bbox"),
+		Near:             p.Circle(),
+		Date:             p.Date("date"),
+		Start:            p.Time("start"),
+		End:              p.Time("end"),
+		IncludeRetracted: p.Bool("include_retracted"),
+		After:            p.Cursor("cursor"),
+	}
+	if _, ok := p.value("min_magnitude"); !ok {
+		filter.MinMagnitude = p.OptionalFloat("magnitude", 0, 10)
+	}
+	if raw, _ := p.value("limit"); raw != "-1" {
+		filter.Limit = p.Int("limit", defaultLimit, 1, MaxLimit)
+	}
+
+	if filter.MinMagnitude != nil && filter.MaxMagnitude != nil && *filter.MinMagnitude > *filter.MaxMagnitude {
+		p.fail("max_magnitude", "must not be less than min_magnitude")
+	}
+	if filter.MinDepth != nil && filter.MaxDepth != nil && *filter.MinDepth > *filter.MaxDepth {
+		p.fail("max_depth", "must not be less than min_depth")
+	}
+	if !filter.Start.IsZero() && !filter.End.IsZero() && !filter.Start.Before(filter.End) {
+		p.fail("end", "must be after start")
+	}
+
+	if err := p.Err(); err != nil {
+		return db.EarthquakeFilter{}, err
+	}
+	return filter, nil
+}
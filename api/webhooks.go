@@ -0,0 +1,182 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strings"
+
+	"github.com/Ward-R/Jishin-API/db"
+	"github.com/Ward-R/Jishin-API/types"
+	"github.com/jackc/pgx/v4"
+)
+
+// minWebhookSecret is the shortest secret a client may choose.
+const minWebhookSecret = 16
+
+// subscriptionRequest is the body of POST /subscriptions. The filter fields
+// are named like the listing query parameters.
+type subscriptionRequest struct {
+	URL          string   `json:"url"`
+	Secret       string   `json:"secret"`
+	MinMagnitude *float64 `json:"min_magnitude"`
+	MinIntensity string   `json:"min_intensity"`
+	Prefectures  []string `json:"prefectures"`
+	Tsunami      bool     `json:"tsunami"`
+}
+
+// validate checks the request and normalizes it into a subscription.
+func (body *subscriptionRequest) validate() (*types.WebhookSubscription, error) {
+	var fields []FieldError
+	fail := func(field, message string) {
+		fields = append(fields, FieldError{Field: field, Message: message})
+	}
+
+	u, err := url.Parse(strings.TrimSpace(body.URL))
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || u.User != nil {
+		fail("url", "must be an absolute http or https URL without credentials")
+		u = &url.URL{}
+	}
+	if body.Secret != "" && len(body.Secret) < minWebhookSecret {
+		fail("secret", "must be at least 16 characters, or omitted to have one generated")
+	}
+	if body.MinMagnitude != nil && (*body.MinMagnitude < 0 || *body.MinMagnitude > 10) {
+		fail("min_magnitude", "must be between 0 and 10")
+	}
+	intensity := ""
+	if body.MinIntensity != "" {
+		var ok bool
+		if intensity, ok = types.NormalizeIntensity(body.MinIntensity); !ok {
+			fail("min_intensity", "must be one of "+strings.Join(types.IntensityScale, ", "))
+		}
+	}
+	var prefectures []string
+	for _, pref := range body.Prefectures {
+		if pref = strings.TrimSpace(pref); pref != "" {
+			prefectures = append(prefectures, pref)
+		}
+	}
+	if len(prefectures) > 47 {
+		fail("prefectures", "must list at most 47 prefectures")
+	}
+
+	if len(fields) > 0 {
+		return nil, &Error{
+			Status:  400,
+			Code:    CodeInvalidParameters,
+			Message: "One or more fields are invalid",
+			Details: fields,
+		}
+	}
+	return &types.WebhookSubscription{
+		URL:          u.String(),
+		Secret:       body.Secret,
+		MinMagnitude: body.MinMagnitude,
+		MinIntensity: intensity,
+		Prefectures:  prefectures,
+		TsunamiOnly:  body.Tsunami,
+	}, nil
+}
+
+// randomToken returns prefix followed by n random bytes in hex.
+func randomToken(prefix string, n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return prefix + hex.EncodeToString(b), nil
+}
+
+// HandleCreateSubscription registers a webhook. The response is the only time
+// the signing secret is shown.
+func HandleCreateSubscription(store db.EarthquakeStore, request *Request) (*Response, error) {
+	var body subscriptionRequest
+	decoder := json.NewDecoder(strings.NewReader(request.Body))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&body); err != nil {
+		return nil, BadRequest("Request body must be a JSON subscription: " + err.Error())
+	}
+	sub, err := body.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	if sub.Id, err = randomToken("sub_", 16); err != nil {
+		return nil, Internal("Error creating subscription", err)
+	}
+	if sub.Secret == "" {
+		if sub.Secret, err = randomToken("whsec_", 32); err != nil {
+			return nil, Internal("Error creating subscription", err)
+		}
+	}
+	if err := store.InsertWebhookSubscription(request.Context(), sub); err != nil {
+		return nil, Internal("Error creating subscription", err)
+	}
+
+	response, err := JSON(201, struct {
+		*types.WebhookSubscription
+		Secret string
+	}{sub, sub.Secret})
+	if err != nil {
+		return nil, err
+	}
+	setHeader(response, "Location", "/subscriptions/"+sub.Id)
+	return response, nil
+}
+
+// getSubscription loads the subscription named by the path.
+func getSubscription(store db.EarthquakeStore, request *Request) (*types.WebhookSubscription, error) {
+	sub, err := store.GetWebhookSubscription(request.Context(), request.PathParam("id"))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, NotFound("Subscription not found")
+	}
+	if err != nil {
+		return nil, Internal("Error fetching subscription", err)
+	}
+	return sub, nil
+}
+
+func HandleGetSubscription(store db.EarthquakeStore, request *Request) (*Response, error) {
+	sub, err := getSubscription(store, request)
+	if err != nil {
+		return nil, err
+	}
+	return JSON(200, sub)
+}
+
+// HandleSubscriptionDeliveries returns the delivery log of a subscription,
+// newest first.
+func HandleSubscriptionDeliveries(store db.EarthquakeStore, request *Request) (*Response, error) {
+	p := newQueryParser(request.Query)
+	limit := p.Int("limit", DefaultLimit, 1, MaxLimit)
+	if err := p.Err(); err != nil {
+		return nil, err
+	}
+	sub, err := getSubscription(store, request)
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries, err := store.GetWebhookDeliveries(request.Context(), sub.Id, limit)
+	if err != nil {
+		return nil, Internal("Error fetching deliveries", err)
+	}
+	return JSON(200, map[string]interface{}{
+		"subscription_id": sub.Id,
+		"count":           len(deliveries),
+		"deliveries":      deliveries,
+	})
+}
+
+func HandleDeleteSubscription(store db.EarthquakeStore, request *Request) (*Response, error) {
+	deleted, err := store.DeleteWebhookSubscription(request.Context(), request.PathParam("id"))
+	if err != nil {
+		return nil, Internal("Error deleting subscription", err)
+	}
+	if !deleted {
+		return nil, NotFound("Subscription not found")
+	}
+	return &Response{StatusCode: 204}, nil
+}
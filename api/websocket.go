@@ -0,0 +1,361 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Ward-R/Jishin-API/service"
+	"github.com/Ward-R/Jishin-API/types"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// wsWriteWait is how long a single write to a client may take.
+	wsWriteWait = 10 * time.Second
+	// wsPongWait is how long a client may stay silent, pongs included.
+	wsPongWait = 60 * time.Second
+	// wsPingInterval must be shorter than wsPongWait.
+	wsPingInterval = 25 * time.Second
+	// wsMaxMessageSize caps what a client may send; subscribe messages are small.
+	wsMaxMessageSize = 4096
+	// wsSendBuffer is how many messages a client may fall behind before it is
+	// disconnected as too slow.
+	wsSendBuffer = 32
+	// wsMaxPrefectures caps the prefectures in one subscription.
+	wsMaxPrefectures = 47
+)
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+	// The API is public and read-only, like its CORS policy
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// wsFilter is what a client subscribes to. Empty fields match everything.
+type wsFilter struct {
+	MinMagnitude *float64 `json:"min_magnitude,omitempty"`
+	MinIntensity string   `json:"min_intensity,omitempty"`
+	// Prefectures match a prefecture that observed shaking, by JMA code or by
+	// Japanese or English name.
+	Prefectures []string `json:"prefectures,omitempty"`
+}
+
+// wsClientMessage is a message from a client:
+//
+//	{"type": "subscribe", "filters": {"min_magnitude": 4, "min_intensity": "5-", "prefectures": ["福島県", "07"]}}
+//	{"type": "unsubscribe"}
+type wsClientMessage struct {
+	Type    string   `json:"type"`
+	Filters wsFilter `json:"filters"`
+}
+
+// wsServerMessage is a message to a client. Type is "subscribed",
+// "unsubscribed", "event" or "error".
+type wsServerMessage struct {
+	Type       string            `json:"type"`
+	Event      string            `json:"event,omitempty"`
+	ID         uint64            `json:"id,omitempty"`
+	Earthquake *types.Earthquake `json:"earthquake,omitempty"`
+	Filters    *wsFilter         `json:"filters,omitempty"`
+	Message    string            `json:"message,omitempty"`
+}
+
+// validate normalizes the filter in place and reports what is wrong with it.
+func (f *wsFilter) validate() error {
+	if f.MinMagnitude != nil && (*f.MinMagnitude < 0 || *f.MinMagnitude > 10) {
+		return fmt.Errorf("min_magnitude must be between 0 and 10")
+	}
+	if f.MinIntensity != "" {
+		intensity, ok := types.NormalizeIntensity(f.MinIntensity)
+		if !ok {
+			return fmt.Errorf("min_intensity must be one of %s", strings.Join(types.IntensityScale, ", "))
+		}
+		f.MinIntensity = intensity
+	}
+	if len(f.Prefectures) > wsMaxPrefectures {
+		return fmt.Errorf("at most %d prefectures may be given", wsMaxPrefectures)
+	}
+	for i, pref := range f.Prefectures {
+		f.Prefectures[i] = strings.ToLower(strings.TrimSpace(pref))
+	}
+	return nil
+}
+
+// matches reports whether eq passes the filter.
+func (f *wsFilter) matches(eq *types.Earthquake) bool {
+	if f.MinMagnitude != nil && eq.Magnitude < *f.MinMagnitude {
+		return false
+	}
+	if f.MinIntensity != "" && types.IntensityRank(eq.MaxIntensity) < types.IntensityRank(f.MinIntensity) {
+		return false
+	}
+	if len(f.Prefectures) == 0 {
+		return true
+	}
+	for _, region := range eq.Regions {
+		if region.Level != types.RegionLevelPref {
+			continue
+		}
+		for _, pref := range f.Prefectures {
+			if pref == region.Code || pref == region.JpName || pref == strings.ToLower(region.EnName) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wsClient is one WebSocket connection. Its filter is nil until it subscribes.
+type wsClient struct {
+	conn *websocket.Conn
+	send chan []byte
+
+	mu     sync.Mutex
+	filter *wsFilter
+}
+
+// enqueue queues a message without blocking, reporting whether there was room.
+func (c *wsClient) enqueue(message []byte) bool {
+	select {
+	case c.send <- message:
+		return true
+	default:
+		return false
+	}
+}
+
+// Hub pushes the events SyncEarthquakes publishes to the WebSocket clients
+// whose subscription they match. A client that cannot keep up is disconnected
+// rather than allowed to hold up the others.
+type Hub struct {
+	broker *service.Broker
+
+	mu      sync.Mutex
+	clients map[*wsClient]struct{}
+	done    chan struct{}
+}
+
+// NewHub returns a hub fed by broker. Run must be called to start it.
+func NewHub(broker *service.Broker) *Hub {
+	return &Hub{
+		broker:  broker,
+		clients: map[*wsClient]struct{}{},
+		done:    make(chan struct{}),
+	}
+}
+
+// Run forwards events from the broker until Close is called. If the broker
+// drops the hub for falling behind, it resubscribes and replays what it missed.
+func (h *Hub) Run() {
+	lastID := uint64(math.MaxUint64)
+	for {
+		replay, events, cancel := h.broker.Subscribe(lastID)
+		for i := range replay {
+			h.broadcast(&replay[i])
+			lastID = replay[i].ID
+		}
+	forward:
+		for {
+			select {
+			case <-h.done:
+				cancel()
+				return
+			case event, ok := <-events:
+				if !ok {
+					break forward
+				}
+				h.broadcast(&event)
+				lastID = event.ID
+			}
+		}
+		cancel()
+	}
+}
+
+// Close stops the hub and disconnects every client.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	select {
+	case <-h.done:
+		return
+	default:
+	}
+	close(h.done)
+	for c := range h.clients {
+		delete(h.clients, c)
+		close(c.send)
+	}
+}
+
+// broadcast queues event for every subscribed client it matches.
+func (h *Hub) broadcast(event *service.Event) {
+	message, err := json.Marshal(wsServerMessage{
+		Type:       "event",
+		Event:      event.Type,
+		ID:         event.ID,
+		Earthquake: &event.Earthquake,
+	})
+	if err != nil {
+		log.Printf("Error encoding WebSocket event %d: %v", event.ID, err)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for c := range h.clients {
+		c.mu.Lock()
+		filter := c.filter
+		c.mu.Unlock()
+		if filter == nil || !filter.matches(&event.Earthquake) {
+			continue
+		}
+		if !c.enqueue(message) {
+			log.Printf("Disconnecting slow WebSocket client %s", c.conn.RemoteAddr())
+			delete(h.clients, c)
+			close(c.send)
+		}
+	}
+}
+
+// remove unregisters c, closing its send channel once.
+func (h *Hub) remove(c *wsClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.clients[c]; ok {
+		delete(h.clients, c)
+		close(c.send)
+	}
+}
+
+// ServeHTTP upgrades /ws/earthquakes to a WebSocket. Like the event stream it
+// needs a long-lived connection, so only the HTTP mode serves it.
+func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has already replied with an error status
+		return
+	}
+	c := &wsClient{conn: conn, send: make(chan []byte, wsSendBuffer)}
+
+	h.mu.Lock()
+	select {
+	case <-h.done:
+		h.mu.Unlock()
+		conn.Close()
+		return
+	default:
+	}
+	h.clients[c] = struct{}{}
+	h.mu.Unlock()
+
+	go h.writePump(c)
+	h.readPump(c)
+}
+
+// readPump handles subscribe and unsubscribe messages until the client goes
+// away or stops answering pings.
+func (h *Hub) readPump(c *wsClient) {
+	defer h.remove(c)
+
+	c.conn.SetReadLimit(wsMaxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	for {
+		var message wsClientMessage
+		if err := c.conn.ReadJSON(&message); err != nil {
+			var syntaxErr *json.SyntaxError
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+				h.reply(c, wsServerMessage{Type: "error", Message: "messages must be a JSON subscribe or unsubscribe message"})
+				continue
+			}
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Printf("WebSocket client %s: %v", c.conn.RemoteAddr(), err)
+			}
+			return
+		}
+		c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+
+		switch message.Type {
+		case "subscribe":
+			filter := message.Filters
+			if err := filter.validate(); err != nil {
+				h.reply(c, wsServerMessage{Type: "error", Message: err.Error()})
+				continue
+			}
+			c.mu.Lock()
+			c.filter = &filter
+			c.mu.Unlock()
+			h.reply(c, wsServerMessage{Type: "subscribed", Filters: &filter})
+		case "unsubscribe":
+			c.mu.Lock()
+			c.filter = nil
+			c.mu.Unlock()
+			h.reply(c, wsServerMessage{Type: "unsubscribed"})
+		default:
+			h.reply(c, wsServerMessage{Type: "error", Message: `type must be "subscribe" or "unsubscribe"`})
+		}
+	}
+}
+
+// reply queues a message for c, disconnecting it if it is too far behind.
+func (h *Hub) reply(c *wsClient, message wsServerMessage) {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.clients[c]; !ok {
+		return
+	}
+	if !c.enqueue(body) {
+		delete(h.clients, c)
+		close(c.send)
+	}
+}
+
+// writePump writes queued messages and pings, and closes the connection once
+// the client is removed.
+func (h *Hub) writePump(c *wsClient) {
+	ping := time.NewTicker(wsPingInterval)
+	defer func() {
+		ping.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case message, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if !ok {
+				// Removed by the hub: shutting down or too slow
+				c.conn.WriteMessage(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "reconnect and subscribe again"))
+				return
+			}
+			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				h.remove(c)
+				return
+			}
+		case <-ping.C:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				h.remove(c)
+				return
+			}
+		}
+	}
+}
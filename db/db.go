@@ -5,61 +5,173 @@ import (
 	"fmt"
 	"math"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/Ward-R/Jishin-API/types"
+	"github.com/jackc/pgconn"
 	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
 )
 
 // Note to self. to login via terminal
 // psql -U admin -d jishin_db -h localhost
 
-// Connect establishes a connection to the PostgreSQL database.
-func Connect() (*pgx.Conn, error) {
+// DB is what the queries need from a database handle. A *pgxpool.Pool is used
+// in production; a single *pgx.Conn satisfies it too.
+type DB interface {
+	Ping(ctx context.Context) error
+	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+	SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+// Pool defaults, overridden by DB_MAX_CONNS, DB_MIN_CONNS and
+// DB_STATEMENT_TIMEOUT. A Lambda instance serves one request at a time, so a
+// few connections are plenty.
+const (
+	defaultMaxConns         = 4
+	defaultMinConns         = 0
+	defaultStatementTimeout = 10 * time.Second
+)
+
+// Connect opens a connection pool to the PostgreSQL database. Connections that
+// break are dropped and replaced on demand, so one lost connection no longer
+// fails every later request.
+func Connect(ctx context.Context) (*pgxpool.Pool, error) {
 	connStr := os.Getenv("DATABASE_URL")
 	if connStr == "" {
 		return nil, fmt.Errorf("DATABASE_URL environment variable not set")
 	}
 
-	// Parse and modify connection string to force IPv4
-	config, err := pgx.ParseConfig(connStr)
+	config, err := pgxpool.ParseConfig(connStr)
 	if err != nil {
 		return nil, fmt.Errorf("unable to parse connection string: %w", err)
 	}
-	
-	// Force TCP4 to avoid IPv6 issues on AWS Lambda  
-	config.PreferSimpleProtocol = true
 
-	conn, err := pgx.ConnectConfig(context.Background(), config)
+	// Poolers in front of the database do not support prepared statements
+	config.ConnConfig.PreferSimpleProtocol = true
+
+	if config.MaxConns, err = envInt32("DB_MAX_CONNS", defaultMaxConns); err != nil {
+		return nil, err
+	}
+	if config.MinConns, err = envInt32("DB_MIN_CONNS", defaultMinConns); err != nil {
+		return nil, err
+	}
+	if config.MinConns > config.MaxConns {
+		return nil, fmt.Errorf("DB_MIN_CONNS (%d) must not exceed DB_MAX_CONNS (%d)", config.MinConns, config.MaxConns)
+	}
+	config.HealthCheckPeriod = 30 * time.Second
+	config.MaxConnIdleTime = 5 * time.Minute
+
+	// The server cancels any statement running longer than this
+	timeout := defaultStatementTimeout
+	if raw := os.Getenv("DB_STATEMENT_TIMEOUT"); raw != "" {
+		if timeout, err = time.ParseDuration(raw); err != nil || timeout < 0 {
+			return nil, fmt.Errorf("invalid DB_STATEMENT_TIMEOUT %q: expected a duration such as 10s", raw)
+		}
+	}
+	config.ConnConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(timeout.Milliseconds(), 10)
+
+	pool, err := pgxpool.ConnectConfig(ctx, config)
 	if err != nil {
 		return nil, fmt.Errorf("unable to connect to database: %w", err)
 	}
+	return pool, nil
+}
+
+// envInt32 reads a positive-or-zero integer setting, returning fallback when unset.
+func envInt32(key string, fallback int32) (int32, error) {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return fallback, nil
+	}
+	v, err := strconv.ParseInt(raw, 10, 32)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid %s %q: expected a non-negative integer", key, raw)
+	}
+	return int32(v), nil
+}
+
+// jst is Japan Standard Time, which JMA reports and date filters use.
+var jst = time.FixedZone("JST", 9*60*60)
+
+// earthquakeColumns is the column list every earthquake query selects, in the
+// order scanEarthquake expects.
+const earthquakeColumns = `report_id, origin_time, arrival_time, magnitude, depth_km,
+                 latitude, longitude, max_intensity, jp_location, en_location,
+                 jp_comment, en_comment, tsunami_risk, serial, info_type,
+                 report_date_time, retracted, tsunami, tsunami_warning`
+
+// scanEarthquake scans a row selected with earthquakeColumns into eq.
+func scanEarthquake(row pgx.Row, eq *types.Earthquake) error {
+	return row.Scan(
+		&eq.ReportId, &eq.OriginTime, &eq.ArrivalTime, &eq.Magnitude,
+		&eq.DepthKm, &eq.Latitude, &eq.Longitude, &eq.MaxIntensity,
+		&eq.JpLocation, &eq.EnLocation, &eq.JpComment, &eq.EnComment,
+		&eq.TsunamiRisk, &eq.Serial, &eq.InfoType, &eq.ReportDateTime,
+		&eq.Retracted, &eq.Tsunami, &eq.TsunamiWarning,
+	)
+}
 
-	return conn, nil
+// retractedCondition is the WHERE condition that hides earthquakes JMA has
+// cancelled, unless the caller explicitly asked to see them.
+func retractedCondition(includeRetracted bool) string {
+	if includeRetracted {
+		return "TRUE"
+	}
+	return "NOT retracted"
 }
 
 // EarthquakeExists checks if an earthquake record already exists in the database
-func EarthquakeExists(conn *pgx.Conn, reportID string) (bool, error) {
+func EarthquakeExists(ctx context.Context, conn DB, reportID string) (bool, error) {
 	var exists bool
 	query := `SELECT EXISTS(SELECT 1 FROM earthquakes WHERE report_id = $1)`
-	err := conn.QueryRow(context.Background(), query, reportID).Scan(&exists)
+	err := conn.QueryRow(ctx, query, reportID).Scan(&exists)
 	if err != nil {
 		return false, fmt.Errorf("error checking earthquake existence: %w", err)
 	}
 	return exists, nil
 }
 
-func InsertEarthquake(conn *pgx.Conn, quake *types.Earthquake) error {
+// GetReportTimes returns the time of the report each stored earthquake among
+// reportIDs reflects, retracted or not. IDs not in the database are left out.
+func GetReportTimes(ctx context.Context, conn DB, reportIDs []string) (map[string]time.Time, error) {
+	query := `SELECT report_id, report_date_time FROM earthquakes WHERE report_id = ANY($1)`
+	rows, err := conn.Query(ctx, query, reportIDs)
+	if err != nil {
+		return nil, fmt.Errorf("error querying report times: %w", err)
+	}
+	defer rows.Close()
+
+	times := map[string]time.Time{}
+	for rows.Next() {
+		var id string
+		var reportTime time.Time
+		if err := rows.Scan(&id, &reportTime); err != nil {
+			return nil, fmt.Errorf("error scanning report time: %w", err)
+		}
+		times[id] = reportTime
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading report times: %w", err)
+	}
+	return times, nil
+}
+
+func InsertEarthquake(ctx context.Context, conn DB, quake *types.Earthquake) error {
 	query := `
         INSERT INTO earthquakes (
             report_id, origin_time, arrival_time, magnitude,
             depth_km, latitude, longitude, max_intensity,
             jp_location, en_location, jp_comment, en_comment,
-            tsunami_risk
-        ) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`
+            tsunami_risk, serial, info_type, report_date_time, tsunami, tsunami_warning
+        ) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18)`
 
-	_, err := conn.Exec(context.Background(), query,
+	_, err := conn.Exec(ctx, query,
 		quake.ReportId,
 		quake.OriginTime,
 		quake.ArrivalTime,
@@ -73,6 +185,11 @@ func InsertEarthquake(conn *pgx.Conn, quake *types.Earthquake) error {
 		quake.JpComment,
 		quake.EnComment,
 		quake.TsunamiRisk,
+		quake.Serial,
+		quake.InfoType,
+		quake.ReportDateTime,
+		quake.Tsunami,
+		quake.TsunamiWarning,
 	)
 
 	if err != nil {
@@ -81,38 +198,285 @@ func InsertEarthquake(conn *pgx.Conn, quake *types.Earthquake) error {
 	return nil
 }
 
-func GetEarthquakes(conn *pgx.Conn, limit int, magnitude float64, date string) ([]types.Earthquake, error) {
+// UpdateEarthquake overwrites an existing earthquake row with a newer JMA report.
+// The sync merges the report into the stored row first, so every column is
+// written as given.
+func UpdateEarthquake(ctx context.Context, conn DB, quake *types.Earthquake) error {
+	query := `
+        UPDATE earthquakes SET
+            origin_time = $2, arrival_time = $3, magnitude = $4,
+            depth_km = $5, latitude = $6, longitude = $7, max_intensity = $8,
+            jp_location = $9, en_location = $10, jp_comment = $11, en_comment = $12,
+            tsunami_risk = $13, serial = $14, info_type = $15, report_date_time = $16,
+            tsunami = $17, tsunami_warning = $18
+        WHERE report_id = $1`
+
+	_, err := conn.Exec(ctx, query,
+		quake.ReportId,
+		quake.OriginTime,
+		quake.ArrivalTime,
+		quake.Magnitude,
+		quake.DepthKm,
+		quake.Latitude,
+		quake.Longitude,
+		quake.MaxIntensity,
+		quake.JpLocation,
+		quake.EnLocation,
+		quake.JpComment,
+		quake.EnComment,
+		quake.TsunamiRisk,
+		quake.Serial,
+		quake.InfoType,
+		quake.ReportDateTime,
+		quake.Tsunami,
+		quake.TsunamiWarning,
+	)
+
+	if err != nil {
+		return fmt.Errorf("error updating earthquake: %w", err)
+	}
+	return nil
+}
+
+// RetractEarthquake marks an earthquake as cancelled by JMA. The row is kept for
+// audit but hidden from queries unless retracted earthquakes are requested.
+func RetractEarthquake(ctx context.Context, conn DB, quake *types.Earthquake) error {
+	query := `
+        UPDATE earthquakes SET
+            retracted = TRUE, serial = $2, info_type = $3, report_date_time = $4
+        WHERE report_id = $1`
+
+	_, err := conn.Exec(ctx, query,
+		quake.ReportId, quake.Serial, quake.InfoType, quake.ReportDateTime,
+	)
+	if err != nil {
+		return fmt.Errorf("error retracting earthquake: %w", err)
+	}
+	return nil
+}
+
+// EarthquakeFilter selects a page of earthquakes for the list queries. Nil
+// pointers and zero values leave that filter off.
+type EarthquakeFilter struct {
+	// Limit is the page size: 0 means the default of 50, -1 means no limit.
+	Limit        int
+	MinMagnitude *float64
+	MaxMagnitude *float64
+	MinDepth     *int
+	MaxDepth     *int
+	// MinIntensity keeps earthquakes whose max intensity is at or above this
+	// JMA class (e.g. "5-"), using the order in types.IntensityScale.
+	MinIntensity string
+	Tsunami      *bool
+	// BBox and Near restrict the epicenter to an area.
+	BBox *BoundingBox
+	Near *Circle
+	// Date selects one calendar day in Japan time (YYYY-MM-DD).
+	Date string
+	// Start and End bound origin_time as [Start, End).
+	Start time.Time
+	End   time.Time
+	// UpdatedAfter keeps earthquakes whose latest JMA report is newer than this.
+	UpdatedAfter     time.Time
+	IncludeRetracted bool
+	// Order sorts the results; the zero value is newest first.
+	Order EarthquakeOrder
+	// Offset skips that many rows. Prefer After for paging newest first.
+	Offset int
+	// After continues a listing from the last row of the previous page. It is
+	// only meaningful with the default order, as is EarthquakePage.Next.
+	After *Cursor
+}
+
+// EarthquakeOrder is a sort order for earthquake listings.
+type EarthquakeOrder string
+
+// Supported orders. Ties are broken by report ID so paging is stable.
+const (
+	OrderTimeDesc      EarthquakeOrder = ""
+	OrderTimeAsc       EarthquakeOrder = "time-asc"
+	OrderMagnitudeDesc EarthquakeOrder = "magnitude"
+	OrderMagnitudeAsc  EarthquakeOrder = "magnitude-asc"
+)
+
+// orderClauses maps each order to its ORDER BY clause.
+var orderClauses = map[EarthquakeOrder]string{
+	OrderTimeDesc:      "origin_time DESC, report_id DESC",
+	OrderTimeAsc:       "origin_time ASC, report_id ASC",
+	OrderMagnitudeDesc: "magnitude DESC, origin_time DESC, report_id DESC",
+	OrderMagnitudeAsc:  "magnitude ASC, origin_time DESC, report_id DESC",
+}
+
+// Cursor is a keyset position in the (origin_time, report_id) ordering used by
+// every earthquake listing.
+type Cursor struct {
+	OriginTime time.Time
+	ReportId   string
+}
+
+// EarthquakePage is one page of a listing. Next is nil on the last page.
+type EarthquakePage struct {
+	Earthquakes []types.Earthquake
+	Next        *Cursor
+}
+
+// GetEarthquakes returns a page of earthquakes, newest first, using keyset
+// pagination on (origin_time, report_id) so deep pages stay cheap.
+func GetEarthquakes(ctx context.Context, conn DB, filter EarthquakeFilter) (*EarthquakePage, error) {
 	// defaults:
 	// earthquakes returned. if -1 all will be returned.
+	limit := filter.Limit
 	if limit == 0 {
 		limit = 50 // Default when no param provided
 	}
-	// if limit == -1, skip Limit clause entirely
-	// if limit >1 then us as-is
 
+	// One extra row tells us whether there is another page
+	queryLimit := limit
+	if limit != -1 {
+		queryLimit = limit + 1
+	}
+
+	// Create slice to hold the results
+	var earthquakes []types.Earthquake
+	err := queryEarthquakes(ctx, conn, filter, queryLimit, func(eq *types.Earthquake) error {
+		earthquakes = append(earthquakes, *eq)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	page := &EarthquakePage{Earthquakes: earthquakes}
+	if limit != -1 && len(earthquakes) > limit {
+		page.Earthquakes = earthquakes[:limit]
+		last := page.Earthquakes[limit-1]
+		page.Next = &Cursor{OriginTime: last.OriginTime, ReportId: last.ReportId}
+	}
+	return page, nil
+}
+
+// StreamEarthquakes calls fn for each earthquake matching filter, newest first,
+// without holding the result set in memory. It honours Limit like
+// GetEarthquakes (-1 streams every match) but does not compute a next cursor.
+// The earthquake passed to fn is reused between calls.
+func StreamEarthquakes(ctx context.Context, conn DB, filter EarthquakeFilter, fn func(eq *types.Earthquake) error) error {
+	limit := filter.Limit
+	if limit == 0 {
+		limit = 50
+	}
+	return queryEarthquakes(ctx, conn, filter, limit, fn)
+}
+
+// queryEarthquakes runs the listing query for filter, returning at most limit
+// rows (-1 for no limit), and calls fn with each row.
+func queryEarthquakes(ctx context.Context, conn DB, filter EarthquakeFilter, limit int, fn func(eq *types.Earthquake) error) error {
+	query, args, err := buildEarthquakeQuery(filter, limit)
+	if err != nil {
+		return err
+	}
+
+	// Execute query
+	rows, err := conn.Query(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("error querying earthquakes: %w", err)
+	}
+	defer rows.Close()
+
+	// Loop through rows and scan into structs
+	var eq types.Earthquake
+	for rows.Next() {
+		eq = types.Earthquake{}
+		if err := scanEarthquake(rows, &eq); err != nil {
+			return fmt.Errorf("error scanning row: %w", err)
+		}
+		if err := fn(&eq); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error reading earthquakes: %w", err)
+	}
+	return nil
+}
+
+// buildEarthquakeQuery turns a filter into the listing SQL and its arguments.
+func buildEarthquakeQuery(filter EarthquakeFilter, limit int) (string, []interface{}, error) {
 	// build base query
 	query := `
-			SELECT report_id, origin_time, arrival_time, magnitude, depth_km,
-      	latitude, longitude, max_intensity, jp_location, en_location,
-      	jp_comment, en_comment, tsunami_risk
+			SELECT ` + earthquakeColumns + `
 			FROM earthquakes`
 
 	var args []interface{}
 	var conditions []string
 	argCount := 0
 
-	// Add magnitude filter if specified
-	if magnitude > 0 {
-		argCount++
-		conditions = append(conditions, fmt.Sprintf("magnitude >= $%d", argCount))
-		args = append(args, magnitude)
+	// Hide cancelled earthquakes unless asked for
+	if !filter.IncludeRetracted {
+		conditions = append(conditions, retractedCondition(filter.IncludeRetracted))
 	}
 
-	// Add date filter if specified
-	if date != "" {
+	// param adds an argument and returns its placeholder
+	param := func(arg interface{}) string {
 		argCount++
-		conditions = append(conditions, fmt.Sprintf("DATE(origin_time) = $%d", argCount))
-		args = append(args, date)
+		args = append(args, arg)
+		return fmt.Sprintf("$%d", argCount)
+	}
+	// add appends a condition whose single placeholder is filled with arg
+	add := func(condition string, arg interface{}) {
+		conditions = append(conditions, strings.Replace(condition, "$%d", param(arg), 1))
+	}
+
+	if filter.MinMagnitude != nil {
+		add("magnitude >= $%d", *filter.MinMagnitude)
+	}
+	if filter.MaxMagnitude != nil {
+		add("magnitude <= $%d", *filter.MaxMagnitude)
+	}
+	if filter.MinDepth != nil {
+		add("depth_km >= $%d", *filter.MinDepth)
+	}
+	if filter.MaxDepth != nil {
+		add("depth_km <= $%d", *filter.MaxDepth)
+	}
+	if filter.MinIntensity != "" {
+		add("max_intensity = ANY($%d)", types.IntensitiesAtLeast(filter.MinIntensity))
+	}
+	if filter.Tsunami != nil {
+		add("tsunami = $%d", *filter.Tsunami)
+	}
+
+	if filter.BBox != nil {
+		conditions = append(conditions, filter.BBox.condition(param))
+	}
+	if filter.Near != nil {
+		conditions = append(conditions, filter.Near.condition(param))
+	}
+
+	// Date filters compare origin_time against a range rather than wrapping it
+	// in DATE(), so the origin_time index can be used
+	if filter.Date != "" {
+		day, err := time.ParseInLocation("2006-01-02", filter.Date, jst)
+		if err != nil {
+			return "", nil, fmt.Errorf("error parsing date filter: %w", err)
+		}
+		add("origin_time >= $%d", day)
+		add("origin_time < $%d", day.AddDate(0, 0, 1))
+	}
+	if !filter.Start.IsZero() {
+		add("origin_time >= $%d", filter.Start)
+	}
+	if !filter.End.IsZero() {
+		add("origin_time < $%d", filter.End)
+	}
+	if !filter.UpdatedAfter.IsZero() {
+		add("report_date_time > $%d", filter.UpdatedAfter)
+	}
+
+	// Continue after the previous page's last row
+	if filter.After != nil {
+		conditions = append(conditions, fmt.Sprintf("(origin_time, report_id) < ($%d, $%d)", argCount+1, argCount+2))
+		args = append(args, filter.After.OriginTime, filter.After.ReportId)
+		argCount += 2
 	}
 
 	// Add WHERE clause if we have conditions
@@ -121,59 +485,33 @@ func GetEarthquakes(conn *pgx.Conn, limit int, magnitude float64, date string) (
 	}
 
 	// Add ORDER BY and LIMIT (only if not requesting all)
-	query += " ORDER BY origin_time DESC"
-	if limit != -1 {
-		argCount++
-		query += fmt.Sprintf(" LIMIT $%d", argCount)
-		args = append(args, limit)
+	order, ok := orderClauses[filter.Order]
+	if !ok {
+		return "", nil, fmt.Errorf("unknown earthquake order %q", filter.Order)
 	}
-
-	// Execute query
-	rows, err := conn.Query(context.Background(), query, args...)
-	if err != nil {
-		return nil, fmt.Errorf("error querying earthquakes: %w", err)
+	query += " ORDER BY " + order
+	if limit != -1 {
+		query += " LIMIT " + param(limit)
 	}
-	defer rows.Close()
-
-	// Create slice to hold the results
-	var earthquakes []types.Earthquake
-	// Loop through rows and scan into structs
-	for rows.Next() {
-		var eq types.Earthquake
-		err := rows.Scan(
-			&eq.ReportId, &eq.OriginTime, &eq.ArrivalTime, &eq.Magnitude,
-			&eq.DepthKm, &eq.Latitude, &eq.Longitude, &eq.MaxIntensity,
-			&eq.JpLocation, &eq.EnLocation, &eq.JpComment, &eq.EnComment,
-			&eq.TsunamiRisk,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("error scanning row: %w", err)
-		}
-		earthquakes = append(earthquakes, eq)
+	if filter.Offset > 0 {
+		query += " OFFSET " + param(filter.Offset)
 	}
 
-	return earthquakes, nil
+	return query, args, nil
 }
 
-func GetEarthquakeById(conn *pgx.Conn, id string) (*types.Earthquake, error) {
+func GetEarthquakeById(ctx context.Context, conn DB, id string, includeRetracted bool) (*types.Earthquake, error) {
 
 	query := `
-			SELECT report_id, origin_time, arrival_time, magnitude, depth_km,
-      	latitude, longitude, max_intensity, jp_location, en_location,
-      	jp_comment, en_comment, tsunami_risk
+			SELECT ` + earthquakeColumns + `
 			FROM earthquakes
-			WHERE report_id = $1`
+			WHERE report_id = $1 AND ` + retractedCondition(includeRetracted)
 
 	// Execute the query
-	row := conn.QueryRow(context.Background(), query, id)
+	row := conn.QueryRow(ctx, query, id)
 
 	var eq types.Earthquake
-	err := row.Scan(
-		&eq.ReportId, &eq.OriginTime, &eq.ArrivalTime, &eq.Magnitude,
-		&eq.DepthKm, &eq.Latitude, &eq.Longitude, &eq.MaxIntensity,
-		&eq.JpLocation, &eq.EnLocation, &eq.JpComment, &eq.EnComment,
-		&eq.TsunamiRisk,
-	)
+	err := scanEarthquake(row, &eq)
 	if err != nil {
 		return nil, fmt.Errorf("earthquake with id %s not found: %w", id, err)
 	}
@@ -181,40 +519,33 @@ func GetEarthquakeById(conn *pgx.Conn, id string) (*types.Earthquake, error) {
 	return &eq, nil
 }
 
-func GetRecentEarthquakes(conn *pgx.Conn) ([]types.Earthquake, error) {
-	query := `
-          SELECT report_id, origin_time, arrival_time, magnitude, depth_km,
-                 latitude, longitude, max_intensity, jp_location, en_location,
-                 jp_comment, en_comment, tsunami_risk
-          FROM earthquakes
-          WHERE origin_time >= NOW() - INTERVAL '24 hours'
-          ORDER BY origin_time DESC`
-
-	rows, err := conn.Query(context.Background(), query)
+// GetRecentEarthquakes returns a page of the earthquakes from the last 24 hours.
+// A later filter.Start narrows the window further.
+func GetRecentEarthquakes(ctx context.Context, conn DB, filter EarthquakeFilter) (*EarthquakePage, error) {
+	page, err := GetEarthquakes(ctx, conn, lastDay(filter))
 	if err != nil {
 		return nil, fmt.Errorf("error querying recent earthquakes: %w", err)
 	}
-	defer rows.Close()
+	return page, nil
+}
 
-	var earthquakes []types.Earthquake
-	for rows.Next() {
-		var eq types.Earthquake
-		err := rows.Scan(
-			&eq.ReportId, &eq.OriginTime, &eq.ArrivalTime, &eq.Magnitude,
-			&eq.DepthKm, &eq.Latitude, &eq.Longitude, &eq.MaxIntensity,
-			&eq.JpLocation, &eq.EnLocation, &eq.JpComment, &eq.EnComment,
-			&eq.TsunamiRisk,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("error scanning row: %w", err)
-		}
-		earthquakes = append(earthquakes, eq)
+// StreamRecentEarthquakes is StreamEarthquakes limited to the last 24 hours.
+func StreamRecentEarthquakes(ctx context.Context, conn DB, filter EarthquakeFilter, fn func(eq *types.Earthquake) error) error {
+	if err := StreamEarthquakes(ctx, conn, lastDay(filter), fn); err != nil {
+		return fmt.Errorf("error streaming recent earthquakes: %w", err)
 	}
+	return nil
+}
 
-	return earthquakes, nil
+// lastDay narrows filter to earthquakes from the last 24 hours.
+func lastDay(filter EarthquakeFilter) EarthquakeFilter {
+	if dayAgo := time.Now().Add(-24 * time.Hour); filter.Start.Before(dayAgo) {
+		filter.Start = dayAgo
+	}
+	return filter
 }
 
-func GetEarthquakeStats(conn *pgx.Conn) (map[string]interface{}, error) {
+func GetEarthquakeStats(ctx context.Context, conn DB, includeRetracted bool) (map[string]interface{}, error) {
 	// Get total count, average magnitude, strongest earthquake
 	query := `
           SELECT
@@ -223,13 +554,14 @@ func GetEarthquakeStats(conn *pgx.Conn) (map[string]interface{}, error) {
               MAX(magnitude) as max_magnitude,
               MIN(magnitude) as min_magnitude,
               MAX(origin_time) as latest_earthquake
-          FROM earthquakes`
+          FROM earthquakes
+          WHERE ` + retractedCondition(includeRetracted)
 
 	var totalCount int
 	var avgMagnitude, maxMagnitude, minMagnitude float64
 	var latestTime time.Time
 
-	err := conn.QueryRow(context.Background(), query).Scan(
+	err := conn.QueryRow(ctx, query).Scan(
 		&totalCount, &avgMagnitude, &maxMagnitude, &minMagnitude, &latestTime,
 	)
 	if err != nil {
@@ -240,15 +572,21 @@ func GetEarthquakeStats(conn *pgx.Conn) (map[string]interface{}, error) {
 	recentQuery := `
           SELECT COUNT(*)
           FROM earthquakes
-          WHERE origin_time >= NOW() - INTERVAL '24 hours'`
+          WHERE origin_time >= NOW() - INTERVAL '24 hours'
+            AND ` + retractedCondition(includeRetracted)
 
 	var recentCount int
-	err = conn.QueryRow(context.Background(), recentQuery).Scan(&recentCount)
+	err = conn.QueryRow(ctx, recentQuery).Scan(&recentCount)
 	if err != nil {
 		return nil, fmt.Errorf("error querying recent stats: %w", err)
 	}
 
-	stats := map[string]interface{}{
+	return earthquakeStats(totalCount, avgMagnitude, maxMagnitude, minMagnitude, latestTime, recentCount), nil
+}
+
+// earthquakeStats builds the /earthquakes/stats response, shared by every store.
+func earthquakeStats(totalCount int, avgMagnitude, maxMagnitude, minMagnitude float64, latestTime time.Time, recentCount int) map[string]interface{} {
+	return map[string]interface{}{
 		"total_earthquakes":   totalCount,
 		"average_magnitude":   math.Round(avgMagnitude*100) / 100, // Round to 2 decimals
 		"strongest_magnitude": maxMagnitude,
@@ -258,30 +596,22 @@ func GetEarthquakeStats(conn *pgx.Conn) (map[string]interface{}, error) {
 		"data_source":         "Japan Meteorological Agency (JMA)",
 		"last_updated":        time.Now().Format(time.RFC3339),
 	}
-
-	return stats, nil
 }
 
 // GetLargestEarthquakeToday returns the strongest earthquake from today
-func GetLargestEarthquakeToday(conn *pgx.Conn) (*types.Earthquake, error) {
+func GetLargestEarthquakeToday(ctx context.Context, conn DB, includeRetracted bool) (*types.Earthquake, error) {
 	query := `
-          SELECT report_id, origin_time, arrival_time, magnitude, depth_km,
-                 latitude, longitude, max_intensity, jp_location, en_location,
-                 jp_comment, en_comment, tsunami_risk
+          SELECT ` + earthquakeColumns + `
           FROM earthquakes
           WHERE DATE(origin_time) = CURRENT_DATE
+            AND ` + retractedCondition(includeRetracted) + `
           ORDER BY magnitude DESC
           LIMIT 1`
 
-	row := conn.QueryRow(context.Background(), query)
+	row := conn.QueryRow(ctx, query)
 
 	var eq types.Earthquake
-	err := row.Scan(
-		&eq.ReportId, &eq.OriginTime, &eq.ArrivalTime, &eq.Magnitude,
-		&eq.DepthKm, &eq.Latitude, &eq.Longitude, &eq.MaxIntensity,
-		&eq.JpLocation, &eq.EnLocation, &eq.JpComment, &eq.EnComment,
-		&eq.TsunamiRisk,
-	)
+	err := scanEarthquake(row, &eq)
 
 	if err != nil {
 		return nil, fmt.Errorf("no earthquakes found today: %w", err)
@@ -291,25 +621,19 @@ func GetLargestEarthquakeToday(conn *pgx.Conn) (*types.Earthquake, error) {
 }
 
 // GetLargestEarthquakeThisWeek returns the strongest earthquake from this week
-func GetLargestEarthquakeThisWeek(conn *pgx.Conn) (*types.Earthquake, error) {
+func GetLargestEarthquakeThisWeek(ctx context.Context, conn DB, includeRetracted bool) (*types.Earthquake, error) {
 	query := `
-				SELECT report_id, origin_time, arrival_time, magnitude, depth_km,
-								latitude, longitude, max_intensity, jp_location, en_location,
-								jp_comment, en_comment, tsunami_risk
+				SELECT ` + earthquakeColumns + `
 				FROM earthquakes
 				WHERE origin_time >= date_trunc('week', CURRENT_DATE)
+				  AND ` + retractedCondition(includeRetracted) + `
 				ORDER BY magnitude DESC
 				LIMIT 1`
 
-	row := conn.QueryRow(context.Background(), query)
+	row := conn.QueryRow(ctx, query)
 
 	var eq types.Earthquake
-	err := row.Scan(
-		&eq.ReportId, &eq.OriginTime, &eq.ArrivalTime, &eq.Magnitude,
-		&eq.DepthKm, &eq.Latitude, &eq.Longitude, &eq.MaxIntensity,
-		&eq.JpLocation, &eq.EnLocation, &eq.JpComment, &eq.EnComment,
-		&eq.TsunamiRisk,
-	)
+	err := scanEarthquake(row, &eq)
 	if err != nil {
 		return nil, fmt.Errorf("no earthquakes found this week: %w", err)
 	}
@@ -0,0 +1,755 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Ward-R/Jishin-API/types"
+	"github.com/jackc/pgx/v4"
+)
+
+// MemoryStore is an EarthquakeStore that keeps everything in memory. It applies
+// the same filters, orderings and paging as the SQL queries so that handlers
+// and the sync can be exercised without a database. "Today" and "this week"
+// use the process's local time zone, as Postgres uses its session time zone.
+type MemoryStore struct {
+	mu sync.Mutex
+
+	earthquakes map[string]types.Earthquake
+	revisions   map[string][]types.Revision
+	// stations holds station metadata by code; observations maps a report to
+	// the intensity each station recorded.
+	stations     map[string]types.StationObservation
+	observations map[string]map[string]string
+	regions      map[string][]types.RegionIntensity
+
+	subscriptions map[string]types.WebhookSubscription
+	// deliveries are kept in id order
+	deliveries     []types.WebhookDelivery
+	lastDeliveryID int64
+}
+
+// NewMemoryStore returns an empty in-memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		earthquakes:   map[string]types.Earthquake{},
+		revisions:     map[string][]types.Revision{},
+		stations:      map[string]types.StationObservation{},
+		observations:  map[string]map[string]string{},
+		regions:       map[string][]types.RegionIntensity{},
+		subscriptions: map[string]types.WebhookSubscription{},
+	}
+}
+
+func (s *MemoryStore) Ping(ctx context.Context) error {
+	return ctx.Err()
+}
+
+// Helper function:
+// earthquakeRow returns quake as stored: only the columns of the earthquakes
+// table are kept.
+func earthquakeRow(quake *types.Earthquake) types.Earthquake {
+	row := *quake
+	row.Stations = nil
+	row.Regions = nil
+	return row
+}
+
+// visible reports whether the earthquake with reportID exists and passes the
+// retracted condition, like the JOIN on earthquakes in the SQL.
+func (s *MemoryStore) visible(reportID string, includeRetracted bool) bool {
+	eq, ok := s.earthquakes[reportID]
+	return ok && (includeRetracted || !eq.Retracted)
+}
+
+func (s *MemoryStore) EarthquakeExists(ctx context.Context, reportID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.earthquakes[reportID]
+	return ok, nil
+}
+
+func (s *MemoryStore) GetReportTimes(ctx context.Context, reportIDs []string) (map[string]time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	times := map[string]time.Time{}
+	for _, id := range reportIDs {
+		if row, ok := s.earthquakes[id]; ok {
+			times[id] = row.ReportDateTime
+		}
+	}
+	return times, nil
+}
+
+func (s *MemoryStore) InsertEarthquake(ctx context.Context, quake *types.Earthquake) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.earthquakes[quake.ReportId]; ok {
+		return fmt.Errorf("error inserting earthquake: report_id %s already exists", quake.ReportId)
+	}
+	row := earthquakeRow(quake)
+	row.Retracted = false
+	s.earthquakes[quake.ReportId] = row
+	return nil
+}
+
+func (s *MemoryStore) UpdateEarthquake(ctx context.Context, quake *types.Earthquake) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current, ok := s.earthquakes[quake.ReportId]
+	if !ok {
+		return nil
+	}
+	row := earthquakeRow(quake)
+	row.Retracted = current.Retracted
+	s.earthquakes[quake.ReportId] = row
+	return nil
+}
+
+func (s *MemoryStore) RetractEarthquake(ctx context.Context, quake *types.Earthquake) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	row, ok := s.earthquakes[quake.ReportId]
+	if !ok {
+		return nil
+	}
+	row.Retracted = true
+	row.Serial = quake.Serial
+	row.InfoType = quake.InfoType
+	row.ReportDateTime = quake.ReportDateTime
+	s.earthquakes[quake.ReportId] = row
+	return nil
+}
+
+func (s *MemoryStore) GetEarthquakeById(ctx context.Context, id string, includeRetracted bool) (*types.Earthquake, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.visible(id, includeRetracted) {
+		return nil, fmt.Errorf("earthquake with id %s not found: %w", id, pgx.ErrNoRows)
+	}
+	eq := s.earthquakes[id]
+	return &eq, nil
+}
+
+// orderLess mirrors orderClauses: it reports whether a sorts before b.
+var orderLess = map[EarthquakeOrder]func(a, b *types.Earthquake) bool{
+	OrderTimeDesc: newerFirst,
+	OrderTimeAsc: func(a, b *types.Earthquake) bool {
+		return newerFirst(b, a)
+	},
+	OrderMagnitudeDesc: func(a, b *types.Earthquake) bool {
+		if a.Magnitude != b.Magnitude {
+			return a.Magnitude > b.Magnitude
+		}
+		return newerFirst(a, b)
+	},
+	OrderMagnitudeAsc: func(a, b *types.Earthquake) bool {
+		if a.Magnitude != b.Magnitude {
+			return a.Magnitude < b.Magnitude
+		}
+		return newerFirst(a, b)
+	},
+}
+
+// newerFirst orders by origin_time DESC, report_id DESC.
+func newerFirst(a, b *types.Earthquake) bool {
+	if !a.OriginTime.Equal(b.OriginTime) {
+		return a.OriginTime.After(b.OriginTime)
+	}
+	return a.ReportId > b.ReportId
+}
+
+// matcher turns a filter into the predicate buildEarthquakeQuery expresses in SQL.
+func matcher(filter EarthquakeFilter) (func(eq *types.Earthquake) bool, error) {
+	var dayStart, dayEnd time.Time
+	if filter.Date != "" {
+		day, err := time.ParseInLocation("2006-01-02", filter.Date, jst)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing date filter: %w", err)
+		}
+		dayStart, dayEnd = day, day.AddDate(0, 0, 1)
+	}
+	minRank := -1
+	if filter.MinIntensity != "" {
+		minRank = types.IntensityRank(filter.MinIntensity)
+	}
+
+	return func(eq *types.Earthquake) bool {
+		switch {
+		case !filter.IncludeRetracted && eq.Retracted:
+			return false
+		case filter.MinMagnitude != nil && eq.Magnitude < *filter.MinMagnitude:
+			return false
+		case filter.MaxMagnitude != nil && eq.Magnitude > *filter.MaxMagnitude:
+			return false
+		case filter.MinDepth != nil && eq.DepthKm < *filter.MinDepth:
+			return false
+		case filter.MaxDepth != nil && eq.DepthKm > *filter.MaxDepth:
+			return false
+		case filter.MinIntensity != "" && (minRank < 0 || types.IntensityRank(eq.MaxIntensity) < minRank):
+			return false
+		case filter.Tsunami != nil && eq.Tsunami != *filter.Tsunami:
+			return false
+		case filter.BBox != nil && !filter.BBox.Contains(eq.Latitude, eq.Longitude):
+			return false
+		case filter.Near != nil && !filter.Near.Contains(eq.Latitude, eq.Longitude):
+			return false
+		case filter.Date != "" && (eq.OriginTime.Before(dayStart) || !eq.OriginTime.Before(dayEnd)):
+			return false
+		case !filter.Start.IsZero() && eq.OriginTime.Before(filter.Start):
+			return false
+		case !filter.End.IsZero() && !eq.OriginTime.Before(filter.End):
+			return false
+		case !filter.UpdatedAfter.IsZero() && !eq.ReportDateTime.After(filter.UpdatedAfter):
+			return false
+		case filter.After != nil && !(eq.OriginTime.Before(filter.After.OriginTime) ||
+			eq.OriginTime.Equal(filter.After.OriginTime) && eq.ReportId < filter.After.ReportId):
+			return false
+		}
+		return true
+	}, nil
+}
+
+// queryEarthquakes is the in-memory counterpart of the package function: it
+// returns at most limit matches (-1 for no limit) in the filter's order.
+func (s *MemoryStore) queryEarthquakes(filter EarthquakeFilter, limit int) ([]types.Earthquake, error) {
+	match, err := matcher(filter)
+	if err != nil {
+		return nil, err
+	}
+	less, ok := orderLess[filter.Order]
+	if !ok {
+		return nil, fmt.Errorf("unknown earthquake order %q", filter.Order)
+	}
+
+	s.mu.Lock()
+	var earthquakes []types.Earthquake
+	for _, eq := range s.earthquakes {
+		if match(&eq) {
+			earthquakes = append(earthquakes, eq)
+		}
+	}
+	s.mu.Unlock()
+
+	sort.Slice(earthquakes, func(i, j int) bool {
+		return less(&earthquakes[i], &earthquakes[j])
+	})
+	if filter.Offset > 0 {
+		if filter.Offset >= len(earthquakes) {
+			return nil, nil
+		}
+		earthquakes = earthquakes[filter.Offset:]
+	}
+	if limit != -1 && len(earthquakes) > limit {
+		earthquakes = earthquakes[:limit]
+	}
+	return earthquakes, nil
+}
+
+func (s *MemoryStore) GetEarthquakes(ctx context.Context, filter EarthquakeFilter) (*EarthquakePage, error) {
+	limit := filter.Limit
+	if limit == 0 {
+		limit = 50
+	}
+	queryLimit := limit
+	if limit != -1 {
+		queryLimit = limit + 1
+	}
+
+	earthquakes, err := s.queryEarthquakes(filter, queryLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &EarthquakePage{Earthquakes: earthquakes}
+	if limit != -1 && len(earthquakes) > limit {
+		page.Earthquakes = earthquakes[:limit]
+		last := page.Earthquakes[limit-1]
+		page.Next = &Cursor{OriginTime: last.OriginTime, ReportId: last.ReportId}
+	}
+	return page, nil
+}
+
+func (s *MemoryStore) StreamEarthquakes(ctx context.Context, filter EarthquakeFilter, fn func(eq *types.Earthquake) error) error {
+	limit := filter.Limit
+	if limit == 0 {
+		limit = 50
+	}
+	earthquakes, err := s.queryEarthquakes(filter, limit)
+	if err != nil {
+		return err
+	}
+	for i := range earthquakes {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("error reading earthquakes: %w", err)
+		}
+		if err := fn(&earthquakes[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetRecentEarthquakes(ctx context.Context, filter EarthquakeFilter) (*EarthquakePage, error) {
+	page, err := s.GetEarthquakes(ctx, lastDay(filter))
+	if err != nil {
+		return nil, fmt.Errorf("error querying recent earthquakes: %w", err)
+	}
+	return page, nil
+}
+
+func (s *MemoryStore) StreamRecentEarthquakes(ctx context.Context, filter EarthquakeFilter, fn func(eq *types.Earthquake) error) error {
+	if err := s.StreamEarthquakes(ctx, lastDay(filter), fn); err != nil {
+		return fmt.Errorf("error streaming recent earthquakes: %w", err)
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetEarthquakeStats(ctx context.Context, includeRetracted bool) (map[string]interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var totalCount, recentCount int
+	var sum, maxMagnitude, minMagnitude float64
+	var latestTime time.Time
+	dayAgo := time.Now().Add(-24 * time.Hour)
+	for _, eq := range s.earthquakes {
+		if eq.Retracted && !includeRetracted {
+			continue
+		}
+		if totalCount == 0 || eq.Magnitude > maxMagnitude {
+			maxMagnitude = eq.Magnitude
+		}
+		if totalCount == 0 || eq.Magnitude < minMagnitude {
+			minMagnitude = eq.Magnitude
+		}
+		if eq.OriginTime.After(latestTime) {
+			latestTime = eq.OriginTime
+		}
+		if !eq.OriginTime.Before(dayAgo) {
+			recentCount++
+		}
+		sum += eq.Magnitude
+		totalCount++
+	}
+	// The aggregates are NULL over no rows, which Postgres fails to scan too
+	if totalCount == 0 {
+		return nil, fmt.Errorf("error querying stats: no earthquakes")
+	}
+
+	return earthquakeStats(totalCount, sum/float64(totalCount), maxMagnitude, minMagnitude, latestTime, recentCount), nil
+}
+
+// largestBetween returns the strongest visible earthquake with an origin time
+// in [from, until). A zero until leaves the range open.
+func (s *MemoryStore) largestBetween(from, until time.Time, includeRetracted bool) (*types.Earthquake, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var largest *types.Earthquake
+	for _, eq := range s.earthquakes {
+		if (eq.Retracted && !includeRetracted) || eq.OriginTime.Before(from) ||
+			(!until.IsZero() && !eq.OriginTime.Before(until)) {
+			continue
+		}
+		if largest == nil || orderLess[OrderMagnitudeDesc](&eq, largest) {
+			eq := eq
+			largest = &eq
+		}
+	}
+	return largest, largest != nil
+}
+
+// startOfDay is local midnight of t's day, like CURRENT_DATE.
+func startOfDay(t time.Time) time.Time {
+	t = t.Local()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+}
+
+func (s *MemoryStore) GetLargestEarthquakeToday(ctx context.Context, includeRetracted bool) (*types.Earthquake, error) {
+	today := startOfDay(time.Now())
+	eq, ok := s.largestBetween(today, today.AddDate(0, 0, 1), includeRetracted)
+	if !ok {
+		return nil, fmt.Errorf("no earthquakes found today: %w", pgx.ErrNoRows)
+	}
+	return eq, nil
+}
+
+func (s *MemoryStore) GetLargestEarthquakeThisWeek(ctx context.Context, includeRetracted bool) (*types.Earthquake, error) {
+	// date_trunc('week') starts weeks on Monday
+	today := startOfDay(time.Now())
+	monday := today.AddDate(0, 0, -(int(today.Weekday())+6)%7)
+	eq, ok := s.largestBetween(monday, time.Time{}, includeRetracted)
+	if !ok {
+		return nil, fmt.Errorf("no earthquakes found this week: %w", pgx.ErrNoRows)
+	}
+	return eq, nil
+}
+
+func (s *MemoryStore) InsertRevision(ctx context.Context, rev *types.Revision) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range s.revisions[rev.EventId] {
+		if r.Serial == rev.Serial && r.ReportDateTime.Equal(rev.ReportDateTime) {
+			return nil
+		}
+	}
+	s.revisions[rev.EventId] = append(s.revisions[rev.EventId], *rev)
+	return nil
+}
+
+func (s *MemoryStore) GetRevisions(ctx context.Context, eventID string, includeRetracted bool) ([]types.Revision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if eq, ok := s.earthquakes[eventID]; ok && eq.Retracted && !includeRetracted {
+		return nil, nil
+	}
+
+	return s.sortedRevisions(eventID), nil
+}
+
+func (s *MemoryStore) GetEventRevisions(ctx context.Context, eventIDs []string) (map[string][]types.Revision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	revisions := map[string][]types.Revision{}
+	for _, id := range eventIDs {
+		if len(s.revisions[id]) > 0 {
+			revisions[id] = s.sortedRevisions(id)
+		}
+	}
+	return revisions, nil
+}
+
+// Helper function:
+// sortedRevisions returns a copy of the revisions of eventID, oldest first.
+func (s *MemoryStore) sortedRevisions(eventID string) []types.Revision {
+	var revisions []types.Revision
+	revisions = append(revisions, s.revisions[eventID]...)
+	sort.SliceStable(revisions, func(i, j int) bool {
+		if !revisions[i].ReportDateTime.Equal(revisions[j].ReportDateTime) {
+			return revisions[i].ReportDateTime.Before(revisions[j].ReportDateTime)
+		}
+		return revisions[i].Serial < revisions[j].Serial
+	})
+	return revisions
+}
+
+func (s *MemoryStore) InsertStationObservations(ctx context.Context, reportID string, stations []types.StationObservation) error {
+	if len(stations) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.earthquakes[reportID]; !ok {
+		return fmt.Errorf("error inserting station observations: earthquake %s does not exist", reportID)
+	}
+
+	observations := map[string]string{}
+	for _, st := range stations {
+		s.stations[st.StationCode] = st
+		observations[st.StationCode] = st.Intensity
+	}
+	s.observations[reportID] = observations
+	return nil
+}
+
+func (s *MemoryStore) GetStationObservations(ctx context.Context, reportID string, includeRetracted bool) ([]types.StationObservation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stations := []types.StationObservation{}
+	if !s.visible(reportID, includeRetracted) {
+		return stations, nil
+	}
+
+	for code, intensity := range s.observations[reportID] {
+		st := s.stations[code]
+		st.ReportId = reportID
+		st.Intensity = intensity
+		stations = append(stations, st)
+	}
+	sort.Slice(stations, func(i, j int) bool {
+		a, b := stations[i], stations[j]
+		switch {
+		case a.PrefCode != b.PrefCode:
+			return a.PrefCode < b.PrefCode
+		case a.AreaCode != b.AreaCode:
+			return a.AreaCode < b.AreaCode
+		case a.CityCode != b.CityCode:
+			return a.CityCode < b.CityCode
+		}
+		return a.StationCode < b.StationCode
+	})
+	return stations, nil
+}
+
+func (s *MemoryStore) InsertRegionIntensities(ctx context.Context, reportID string, regions []types.RegionIntensity) error {
+	if len(regions) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.earthquakes[reportID]; !ok {
+		return fmt.Errorf("error inserting region intensities: earthquake %s does not exist", reportID)
+	}
+
+	// Later duplicates of a (level, code) overwrite earlier ones, like the upsert
+	var stored []types.RegionIntensity
+	index := map[[2]string]int{}
+	for _, r := range regions {
+		r.ReportId = reportID
+		key := [2]string{r.Level, r.Code}
+		if i, ok := index[key]; ok {
+			stored[i] = r
+			continue
+		}
+		index[key] = len(stored)
+		stored = append(stored, r)
+	}
+	s.regions[reportID] = stored
+	return nil
+}
+
+func (s *MemoryStore) GetRegionIntensities(ctx context.Context, reportID string, level string, includeRetracted bool) ([]types.RegionIntensity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	regions := []types.RegionIntensity{}
+	if !s.visible(reportID, includeRetracted) {
+		return regions, nil
+	}
+
+	for _, r := range s.regions[reportID] {
+		if r.Level == level {
+			regions = append(regions, r)
+		}
+	}
+	sort.Slice(regions, func(i, j int) bool {
+		return regions[i].Code < regions[j].Code
+	})
+	return regions, nil
+}
+
+// Helper function:
+// copySubscription returns sub without sharing its slices or pointers.
+func copySubscription(sub types.WebhookSubscription) types.WebhookSubscription {
+	sub.Prefectures = append([]string{}, sub.Prefectures...)
+	if sub.DisabledAt != nil {
+		disabledAt := *sub.DisabledAt
+		sub.DisabledAt = &disabledAt
+	}
+	return sub
+}
+
+func (s *MemoryStore) InsertWebhookSubscription(ctx context.Context, sub *types.WebhookSubscription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.subscriptions[sub.Id]; ok {
+		return fmt.Errorf("error inserting webhook subscription: id %s already exists", sub.Id)
+	}
+	sub.Active = true
+	sub.CreatedAt = time.Now()
+	row := copySubscription(*sub)
+	row.ConsecutiveFailures = 0
+	row.DisabledAt = nil
+	s.subscriptions[sub.Id] = row
+	return nil
+}
+
+func (s *MemoryStore) GetWebhookSubscription(ctx context.Context, id string) (*types.WebhookSubscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub, ok := s.subscriptions[id]
+	if !ok {
+		return nil, fmt.Errorf("error querying webhook subscription %s: %w", id, pgx.ErrNoRows)
+	}
+	sub = copySubscription(sub)
+	return &sub, nil
+}
+
+func (s *MemoryStore) GetActiveWebhookSubscriptions(ctx context.Context) ([]types.WebhookSubscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var subs []types.WebhookSubscription
+	for _, sub := range s.subscriptions {
+		if sub.Active {
+			subs = append(subs, copySubscription(sub))
+		}
+	}
+	sort.Slice(subs, func(i, j int) bool {
+		return subs[i].Id < subs[j].Id
+	})
+	return subs, nil
+}
+
+func (s *MemoryStore) DeleteWebhookSubscription(ctx context.Context, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.subscriptions[id]; !ok {
+		return false, nil
+	}
+	delete(s.subscriptions, id)
+
+	// Deliveries cascade with their subscription
+	kept := s.deliveries[:0]
+	for _, d := range s.deliveries {
+		if d.SubscriptionId != id {
+			kept = append(kept, d)
+		}
+	}
+	s.deliveries = kept
+	return true, nil
+}
+
+func (s *MemoryStore) InsertWebhookDelivery(ctx context.Context, delivery *types.WebhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.subscriptions[delivery.SubscriptionId]; !ok {
+		return fmt.Errorf("error inserting webhook delivery: subscription %s does not exist", delivery.SubscriptionId)
+	}
+
+	s.lastDeliveryID++
+	now := time.Now()
+	row := types.WebhookDelivery{
+		Id:             s.lastDeliveryID,
+		SubscriptionId: delivery.SubscriptionId,
+		EventType:      delivery.EventType,
+		ReportId:       delivery.ReportId,
+		Payload:        delivery.Payload,
+		Status:         types.DeliveryPending,
+		NextAttemptAt:  now,
+		CreatedAt:      now,
+	}
+	s.deliveries = append(s.deliveries, row)
+
+	delivery.Id = row.Id
+	delivery.Status = row.Status
+	delivery.NextAttemptAt = row.NextAttemptAt
+	delivery.CreatedAt = row.CreatedAt
+	return nil
+}
+
+// delivery returns the stored delivery with id, or nil.
+func (s *MemoryStore) delivery(id int64) *types.WebhookDelivery {
+	i := sort.Search(len(s.deliveries), func(i int) bool {
+		return s.deliveries[i].Id >= id
+	})
+	if i < len(s.deliveries) && s.deliveries[i].Id == id {
+		return &s.deliveries[i]
+	}
+	return nil
+}
+
+func (s *MemoryStore) ClaimDueWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]DueWebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var due []*types.WebhookDelivery
+	for i := range s.deliveries {
+		d := &s.deliveries[i]
+		sub, ok := s.subscriptions[d.SubscriptionId]
+		if d.Status != types.DeliveryPending || d.NextAttemptAt.After(now) || !ok || !sub.Active {
+			continue
+		}
+		due = append(due, d)
+	}
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].NextAttemptAt.Before(due[j].NextAttemptAt)
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].Id < due[j].Id
+	})
+
+	deliveries := make([]DueWebhookDelivery, 0, len(due))
+	for _, d := range due {
+		d.NextAttemptAt = now.Add(lease)
+		sub := s.subscriptions[d.SubscriptionId]
+		deliveries = append(deliveries, DueWebhookDelivery{
+			WebhookDelivery: *d,
+			URL:             sub.URL,
+			Secret:          sub.Secret,
+		})
+	}
+	return deliveries, nil
+}
+
+func (s *MemoryStore) RecordWebhookSuccess(ctx context.Context, delivery *types.WebhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := s.delivery(delivery.Id)
+	if d == nil {
+		return nil
+	}
+
+	now := time.Now()
+	d.Status = types.DeliveryDelivered
+	d.Attempts = delivery.Attempts
+	d.LastStatusCode = delivery.LastStatusCode
+	d.LastError = ""
+	d.DeliveredAt = &now
+	if sub, ok := s.subscriptions[d.SubscriptionId]; ok {
+		sub.ConsecutiveFailures = 0
+		s.subscriptions[d.SubscriptionId] = sub
+	}
+	return nil
+}
+
+func (s *MemoryStore) RecordWebhookFailure(ctx context.Context, delivery *types.WebhookDelivery, nextAttempt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := types.DeliveryPending
+	if nextAttempt.IsZero() {
+		status = types.DeliveryFailed
+	}
+	if d := s.delivery(delivery.Id); d != nil {
+		d.Status = status
+		d.Attempts = delivery.Attempts
+		if !nextAttempt.IsZero() {
+			d.NextAttemptAt = nextAttempt
+		}
+		d.LastStatusCode = delivery.LastStatusCode
+		d.LastError = delivery.LastError
+
+		if sub, ok := s.subscriptions[d.SubscriptionId]; ok {
+			sub.ConsecutiveFailures++
+			if status == types.DeliveryFailed {
+				now := time.Now()
+				sub.Active = false
+				sub.DisabledAt = &now
+			}
+			s.subscriptions[d.SubscriptionId] = sub
+		}
+	}
+	if status != types.DeliveryFailed {
+		return nil
+	}
+
+	for i := range s.deliveries {
+		d := &s.deliveries[i]
+		if d.SubscriptionId == delivery.SubscriptionId && d.Status == types.DeliveryPending {
+			d.Status = types.DeliveryFailed
+			d.LastError = "subscription disabled"
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetWebhookDeliveries(ctx context.Context, subscriptionID string, limit int) ([]types.WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deliveries := []types.WebhookDelivery{}
+	for i := len(s.deliveries) - 1; i >= 0 && len(deliveries) < limit; i-- {
+		if d := s.deliveries[i]; d.SubscriptionId == subscriptionID {
+			d.Payload = ""
+			deliveries = append(deliveries, d)
+		}
+	}
+	return deliveries, nil
+}
@@ -0,0 +1,203 @@
+package db
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// migrationFiles holds the schema as numbered up and down scripts, named
+// NNNN_description.up.sql and NNNN_description.down.sql.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationName matches a migration file name: version, description, direction.
+var migrationName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// migrationLockKey is the advisory lock taken while migrating, so instances
+// starting together apply each migration once. The value is "jishin" in ASCII.
+const migrationLockKey = 0x6a697368696e
+
+// Migration is one versioned schema change.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Migrations returns the embedded migrations in version order. Versions must
+// start at 1 without gaps and every migration needs both scripts.
+func Migrations() ([]Migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, fmt.Errorf("error reading migrations: %w", err)
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		match := migrationName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("unexpected migration file %s", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		sql, err := migrationFiles.ReadFile(path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("error reading migration %s: %w", entry.Name(), err)
+		}
+
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d is named both %s and %s", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(sql)
+		} else {
+			m.Down = string(sql)
+		}
+	}
+
+	var migrations []Migration
+	for _, m := range byVersion {
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migration %d is missing", i+1)
+		}
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d (%s) needs both an up and a down script", m.Version, m.Name)
+		}
+	}
+	return migrations, nil
+}
+
+// SchemaVersion returns the version of the last applied migration, 0 for a
+// database that has never been migrated.
+func SchemaVersion(ctx context.Context, conn DB) (int, error) {
+	var exists bool
+	err := conn.QueryRow(ctx, `SELECT to_regclass('schema_version') IS NOT NULL`).Scan(&exists)
+	if err != nil {
+		return 0, fmt.Errorf("error checking schema version: %w", err)
+	}
+	if !exists {
+		return 0, nil
+	}
+
+	var version int
+	err = conn.QueryRow(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("error reading schema version: %w", err)
+	}
+	return version, nil
+}
+
+// Migrate applies every pending migration and returns those it applied.
+func Migrate(ctx context.Context, conn DB) ([]Migration, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	return MigrateTo(ctx, conn, len(migrations))
+}
+
+// MigrateTo applies up or down migrations until the schema is at target, and
+// returns them in the order they ran. Each migration runs in its own
+// transaction holding the migration lock, so a failed migration leaves the
+// schema at the previous version and concurrent callers wait their turn.
+func MigrateTo(ctx context.Context, conn DB, target int) ([]Migration, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	if target < 0 || target > len(migrations) {
+		return nil, fmt.Errorf("no migration version %d: expected 0 to %d", target, len(migrations))
+	}
+
+	var ran []Migration
+	for {
+		m, done, err := migrateStep(ctx, conn, migrations, target)
+		if err != nil {
+			return ran, err
+		}
+		if done {
+			return ran, nil
+		}
+		ran = append(ran, m)
+	}
+}
+
+// migrateStep moves the schema one migration towards target, reporting done
+// once it is there.
+func migrateStep(ctx context.Context, conn DB, migrations []Migration, target int) (Migration, bool, error) {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return Migration{}, false, fmt.Errorf("error starting migration transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Released when the transaction ends
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, int64(migrationLockKey)); err != nil {
+		return Migration{}, false, fmt.Errorf("error taking migration lock: %w", err)
+	}
+	// Building an index on a large table can outlast the pool's statement timeout
+	if _, err := tx.Exec(ctx, `SET LOCAL statement_timeout = 0`); err != nil {
+		return Migration{}, false, fmt.Errorf("error lifting statement timeout: %w", err)
+	}
+	_, err = tx.Exec(ctx, `
+        CREATE TABLE IF NOT EXISTS schema_version (
+            version    INTEGER PRIMARY KEY,
+            name       TEXT NOT NULL,
+            applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+        )`)
+	if err != nil {
+		return Migration{}, false, fmt.Errorf("error creating schema_version: %w", err)
+	}
+
+	var current int
+	err = tx.QueryRow(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&current)
+	if err != nil {
+		return Migration{}, false, fmt.Errorf("error reading schema version: %w", err)
+	}
+	if current > len(migrations) {
+		return Migration{}, false, fmt.Errorf("database schema version %d is newer than this build, which knows %d migrations", current, len(migrations))
+	}
+	if current == target {
+		return Migration{}, true, tx.Commit(ctx)
+	}
+
+	var m Migration
+	if current < target {
+		m = migrations[current]
+		if _, err := tx.Exec(ctx, m.Up); err != nil {
+			return m, false, fmt.Errorf("error applying migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		_, err = tx.Exec(ctx, `INSERT INTO schema_version (version, name) VALUES ($1, $2)`, m.Version, m.Name)
+	} else {
+		m = migrations[current-1]
+		if _, err := tx.Exec(ctx, m.Down); err != nil {
+			return m, false, fmt.Errorf("error reverting migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		_, err = tx.Exec(ctx, `DELETE FROM schema_version WHERE version = $1`, m.Version)
+	}
+	if err != nil {
+		return m, false, fmt.Errorf("error recording schema version: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return m, false, fmt.Errorf("error committing migration %d (%s): %w", m.Version, m.Name, err)
+	}
+	return m, false, nil
+}
@@ -0,0 +1,65 @@
+package db_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/Ward-R/Jishin-API/db"
+)
+
+func TestMigrations(t *testing.T) {
+	migrations, err := db.Migrations()
+	if err != nil {
+		t.Fatalf("Migrations: %v", err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("no migrations embedded")
+	}
+}
+
+// TestMigrateRoundTrip reverts every migration, then applies them from
+// several goroutines at once; the advisory lock must apply each exactly once.
+func TestMigrateRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	pool := testDatabase(t)
+	migrations, err := db.Migrations()
+	if err != nil {
+		t.Fatalf("Migrations: %v", err)
+	}
+
+	if _, err := db.Migrate(ctx, pool); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	if _, err := db.MigrateTo(ctx, pool, 0); err != nil {
+		t.Fatalf("MigrateTo(0): %v", err)
+	}
+	if version, err := db.SchemaVersion(ctx, pool); err != nil || version != 0 {
+		t.Fatalf("SchemaVersion after reverting = %d, %v; want 0", version, err)
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	applied := 0
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ran, err := db.Migrate(ctx, pool)
+			if err != nil {
+				t.Errorf("concurrent Migrate: %v", err)
+			}
+			mu.Lock()
+			applied += len(ran)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if applied != len(migrations) {
+		t.Errorf("concurrent runs applied %d migrations, want %d", applied, len(migrations))
+	}
+	if version, err := db.SchemaVersion(ctx, pool); err != nil || version != len(migrations) {
+		t.Errorf("SchemaVersion = %d, %v; want %d", version, err, len(migrations))
+	}
+}
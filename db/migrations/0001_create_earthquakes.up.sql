@@ -0,0 +1,16 @@
+-- One row per JMA earthquake event, reflecting its latest report.
+CREATE TABLE IF NOT EXISTS earthquakes (
+    report_id     TEXT PRIMARY KEY,
+    origin_time   TIMESTAMPTZ,
+    arrival_time  TIMESTAMPTZ,
+    magnitude     DOUBLE PRECISION,
+    depth_km      INTEGER,
+    latitude      DOUBLE PRECISION,
+    longitude     DOUBLE PRECISION,
+    max_intensity TEXT,
+    jp_location   TEXT,
+    en_location   TEXT,
+    jp_comment    TEXT,
+    en_comment    TEXT,
+    tsunami_risk  TEXT
+);
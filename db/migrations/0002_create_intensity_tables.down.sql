@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS intensity_regions;
+DROP TABLE IF EXISTS intensity_observations;
+DROP TABLE IF EXISTS intensity_stations;
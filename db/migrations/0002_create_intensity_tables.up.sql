@@ -0,0 +1,31 @@
+-- JMA intensity observation stations, upserted from every detail report.
+CREATE TABLE IF NOT EXISTS intensity_stations (
+    station_code TEXT PRIMARY KEY,
+    jp_name      TEXT NOT NULL,
+    en_name      TEXT NOT NULL,
+    latitude     DOUBLE PRECISION,
+    longitude    DOUBLE PRECISION,
+    pref_code    TEXT,
+    area_code    TEXT,
+    city_code    TEXT
+);
+
+-- Intensity recorded at each station for a given earthquake report.
+CREATE TABLE IF NOT EXISTS intensity_observations (
+    report_id    TEXT NOT NULL REFERENCES earthquakes (report_id) ON DELETE CASCADE,
+    station_code TEXT NOT NULL REFERENCES intensity_stations (station_code),
+    intensity    TEXT NOT NULL,
+    PRIMARY KEY (report_id, station_code)
+);
+
+-- Maximum intensity per prefecture, area and city for a given earthquake report.
+CREATE TABLE IF NOT EXISTS intensity_regions (
+    report_id     TEXT NOT NULL REFERENCES earthquakes (report_id) ON DELETE CASCADE,
+    level         TEXT NOT NULL CHECK (level IN ('pref', 'area', 'city')),
+    code          TEXT NOT NULL,
+    parent_code   TEXT NOT NULL DEFAULT '',
+    jp_name       TEXT NOT NULL,
+    en_name       TEXT NOT NULL,
+    max_intensity TEXT NOT NULL,
+    PRIMARY KEY (report_id, level, code)
+);
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS earthquake_revisions;
+ALTER TABLE earthquakes DROP COLUMN IF EXISTS report_date_time;
+ALTER TABLE earthquakes DROP COLUMN IF EXISTS info_type;
+ALTER TABLE earthquakes DROP COLUMN IF EXISTS serial;
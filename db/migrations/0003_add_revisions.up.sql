@@ -0,0 +1,23 @@
+-- Report revision tracking: which JMA report the earthquake row reflects.
+ALTER TABLE earthquakes ADD COLUMN IF NOT EXISTS serial INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE earthquakes ADD COLUMN IF NOT EXISTS info_type TEXT NOT NULL DEFAULT '';
+ALTER TABLE earthquakes ADD COLUMN IF NOT EXISTS report_date_time TIMESTAMPTZ NOT NULL DEFAULT 'epoch';
+
+-- Every JMA report seen for an event. Serials restart per report kind, so the
+-- report time is part of the key.
+CREATE TABLE IF NOT EXISTS earthquake_revisions (
+    event_id         TEXT NOT NULL,
+    serial           INTEGER NOT NULL,
+    info_type        TEXT NOT NULL,
+    report_date_time TIMESTAMPTZ NOT NULL,
+    origin_time      TIMESTAMPTZ,
+    magnitude        DOUBLE PRECISION,
+    depth_km         INTEGER,
+    latitude         DOUBLE PRECISION,
+    longitude        DOUBLE PRECISION,
+    max_intensity    TEXT,
+    jp_location      TEXT,
+    en_location      TEXT,
+    recorded_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (event_id, serial, report_date_time)
+);
@@ -0,0 +1,2 @@
+-- Earthquakes cancelled (取消) by JMA are kept for audit but hidden by default.
+ALTER TABLE earthquakes ADD COLUMN IF NOT EXISTS retracted BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS earthquakes_origin_time_report_id_idx;
@@ -0,0 +1,3 @@
+-- Keyset pagination walks (origin_time, report_id) newest first.
+CREATE INDEX IF NOT EXISTS earthquakes_origin_time_report_id_idx
+    ON earthquakes (origin_time DESC, report_id DESC);
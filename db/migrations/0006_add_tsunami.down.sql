@@ -0,0 +1 @@
+ALTER TABLE earthquakes DROP COLUMN IF EXISTS tsunami;
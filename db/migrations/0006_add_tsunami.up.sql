@@ -0,0 +1,3 @@
+-- Set when JMA's forecast comment warns of a tsunami or sea-level change.
+-- Rows synced before this column existed stay FALSE until their next report.
+ALTER TABLE earthquakes ADD COLUMN IF NOT EXISTS tsunami BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS earthquakes_latitude_longitude_idx;
+DROP INDEX IF EXISTS earthquakes_depth_km_idx;
+DROP INDEX IF EXISTS earthquakes_magnitude_idx;
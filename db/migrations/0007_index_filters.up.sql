@@ -0,0 +1,6 @@
+-- Range filters on magnitude and depth.
+CREATE INDEX IF NOT EXISTS earthquakes_magnitude_idx ON earthquakes (magnitude);
+CREATE INDEX IF NOT EXISTS earthquakes_depth_km_idx ON earthquakes (depth_km);
+
+-- Spatial filters (bbox, and the bounding-box prefilter of radius searches).
+CREATE INDEX IF NOT EXISTS earthquakes_latitude_longitude_idx ON earthquakes (latitude, longitude);
@@ -0,0 +1,37 @@
+-- Callback URLs that are sent matching earthquakes. IDs are random, so knowing
+-- one is what lets a client manage its subscription.
+CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+    id                   TEXT PRIMARY KEY,
+    url                  TEXT NOT NULL,
+    secret               TEXT NOT NULL,
+    min_magnitude        DOUBLE PRECISION,
+    min_intensity        TEXT NOT NULL DEFAULT '',
+    prefectures          TEXT[] NOT NULL DEFAULT '{}',
+    tsunami_only         BOOLEAN NOT NULL DEFAULT FALSE,
+    active               BOOLEAN NOT NULL DEFAULT TRUE,
+    consecutive_failures INTEGER NOT NULL DEFAULT 0,
+    disabled_at          TIMESTAMPTZ,
+    created_at           TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- The delivery queue and log. Pending rows are retried with backoff until they
+-- are delivered or run out of attempts.
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id               BIGSERIAL PRIMARY KEY,
+    subscription_id  TEXT NOT NULL REFERENCES webhook_subscriptions (id) ON DELETE CASCADE,
+    event_type       TEXT NOT NULL,
+    report_id        TEXT NOT NULL,
+    payload          TEXT NOT NULL,
+    status           TEXT NOT NULL DEFAULT 'pending',
+    attempts         INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_status_code INTEGER,
+    last_error       TEXT NOT NULL DEFAULT '',
+    created_at       TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    delivered_at     TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx
+    ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS webhook_deliveries_subscription_id_idx
+    ON webhook_deliveries (subscription_id, id DESC);
@@ -0,0 +1,3 @@
+-- Set when JMA's forecast comment says a tsunami warning or advisory is in
+-- effect, as opposed to a possible or slight sea-level change.
+ALTER TABLE earthquakes ADD COLUMN IF NOT EXISTS tsunami_warning BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,79 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Ward-R/Jishin-API/types"
+	"github.com/jackc/pgx/v4"
+)
+
+// InsertRegionIntensities stores the per-prefecture, area and city max intensities
+// for a report, replacing any stored from an earlier revision.
+func InsertRegionIntensities(ctx context.Context, conn DB, reportID string, regions []types.RegionIntensity) error {
+	if len(regions) == 0 {
+		return nil
+	}
+
+	query := `
+        INSERT INTO intensity_regions (
+            report_id, level, code, parent_code, jp_name, en_name, max_intensity
+        ) VALUES ($1, $2, $3, $4, $5, $6, $7)
+        ON CONFLICT (report_id, level, code) DO UPDATE SET
+            parent_code = EXCLUDED.parent_code,
+            jp_name = EXCLUDED.jp_name,
+            en_name = EXCLUDED.en_name,
+            max_intensity = EXCLUDED.max_intensity`
+
+	// Batches run in an implicit transaction, so the delete and inserts apply together
+	batch := &pgx.Batch{}
+	batch.Queue(`DELETE FROM intensity_regions WHERE report_id = $1`, reportID)
+	for _, r := range regions {
+		batch.Queue(query, reportID, r.Level, r.Code, r.ParentCode, r.JpName, r.EnName, r.MaxIntensity)
+	}
+
+	results := conn.SendBatch(ctx, batch)
+	defer results.Close()
+	if _, err := results.Exec(); err != nil {
+		return fmt.Errorf("error clearing region intensities: %w", err)
+	}
+	for _, r := range regions {
+		if _, err := results.Exec(); err != nil {
+			return fmt.Errorf("error inserting %s intensity for %s: %w", r.Level, r.Code, err)
+		}
+	}
+	return nil
+}
+
+// GetRegionIntensities returns the max intensity for every region of the given
+// level ("pref", "area" or "city") recorded for a report. Retracted earthquakes
+// are hidden unless includeRetracted is set.
+func GetRegionIntensities(ctx context.Context, conn DB, reportID string, level string, includeRetracted bool) ([]types.RegionIntensity, error) {
+	query := `
+          SELECT r.report_id, r.level, r.code, r.parent_code, r.jp_name, r.en_name, r.max_intensity
+          FROM intensity_regions r
+          JOIN earthquakes e ON e.report_id = r.report_id
+          WHERE r.report_id = $1 AND r.level = $2 AND ` + retractedCondition(includeRetracted) + `
+          ORDER BY r.code`
+
+	rows, err := conn.Query(ctx, query, reportID, level)
+	if err != nil {
+		return nil, fmt.Errorf("error querying region intensities: %w", err)
+	}
+	defer rows.Close()
+
+	regions := []types.RegionIntensity{}
+	for rows.Next() {
+		var r types.RegionIntensity
+		err := rows.Scan(
+			&r.ReportId, &r.Level, &r.Code, &r.ParentCode,
+			&r.JpName, &r.EnName, &r.MaxIntensity,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		regions = append(regions, r)
+	}
+
+	return regions, nil
+}
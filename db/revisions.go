@@ -0,0 +1,115 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Ward-R/Jishin-API/types"
+)
+
+// InsertRevision records a JMA report in the revision history. JMA restarts the
+// serial number for each kind of report, so the report time is part of the key.
+// Reports already recorded are ignored.
+func InsertRevision(ctx context.Context, conn DB, rev *types.Revision) error {
+	query := `
+        INSERT INTO earthquake_revisions (
+            event_id, serial, info_type, report_date_time, origin_time,
+            magnitude, depth_km, latitude, longitude, max_intensity,
+            jp_location, en_location
+        ) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+        ON CONFLICT (event_id, serial, report_date_time) DO NOTHING`
+
+	_, err := conn.Exec(ctx, query,
+		rev.EventId,
+		rev.Serial,
+		rev.InfoType,
+		rev.ReportDateTime,
+		rev.OriginTime,
+		rev.Magnitude,
+		rev.DepthKm,
+		rev.Latitude,
+		rev.Longitude,
+		rev.MaxIntensity,
+		rev.JpLocation,
+		rev.EnLocation,
+	)
+	if err != nil {
+		return fmt.Errorf("error inserting revision: %w", err)
+	}
+	return nil
+}
+
+// GetRevisions returns every recorded report for an event, oldest first. The
+// history of a retracted earthquake is hidden unless includeRetracted is set.
+func GetRevisions(ctx context.Context, conn DB, eventID string, includeRetracted bool) ([]types.Revision, error) {
+	query := `
+          SELECT event_id, serial, info_type, report_date_time, origin_time,
+                 magnitude, depth_km, latitude, longitude, max_intensity,
+                 jp_location, en_location
+          FROM earthquake_revisions
+          WHERE event_id = $1
+            AND ($2 OR NOT EXISTS (
+                SELECT 1 FROM earthquakes
+                WHERE report_id = $1 AND retracted
+            ))
+          ORDER BY report_date_time, serial`
+
+	rows, err := conn.Query(ctx, query, eventID, includeRetracted)
+	if err != nil {
+		return nil, fmt.Errorf("error querying revisions: %w", err)
+	}
+	defer rows.Close()
+
+	var revisions []types.Revision
+	for rows.Next() {
+		var rev types.Revision
+		err := rows.Scan(
+			&rev.EventId, &rev.Serial, &rev.InfoType, &rev.ReportDateTime, &rev.OriginTime,
+			&rev.Magnitude, &rev.DepthKm, &rev.Latitude, &rev.Longitude, &rev.MaxIntensity,
+			&rev.JpLocation, &rev.EnLocation,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		revisions = append(revisions, rev)
+	}
+
+	return revisions, nil
+}
+
+// GetEventRevisions returns the recorded reports of each event among eventIDs,
+// oldest first, retracted or not, in one query. Events without revisions are
+// left out.
+func GetEventRevisions(ctx context.Context, conn DB, eventIDs []string) (map[string][]types.Revision, error) {
+	query := `
+          SELECT event_id, serial, info_type, report_date_time, origin_time,
+                 magnitude, depth_km, latitude, longitude, max_intensity,
+                 jp_location, en_location
+          FROM earthquake_revisions
+          WHERE event_id = ANY($1)
+          ORDER BY event_id, report_date_time, serial`
+
+	rows, err := conn.Query(ctx, query, eventIDs)
+	if err != nil {
+		return nil, fmt.Errorf("error querying revisions: %w", err)
+	}
+	defer rows.Close()
+
+	revisions := map[string][]types.Revision{}
+	for rows.Next() {
+		var rev types.Revision
+		err := rows.Scan(
+			&rev.EventId, &rev.Serial, &rev.InfoType, &rev.ReportDateTime, &rev.OriginTime,
+			&rev.Magnitude, &rev.DepthKm, &rev.Latitude, &rev.Longitude, &rev.MaxIntensity,
+			&rev.JpLocation, &rev.EnLocation,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		revisions[rev.EventId] = append(revisions[rev.EventId], rev)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading revisions: %w", err)
+	}
+	return revisions, nil
+}
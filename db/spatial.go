@@ -0,0 +1,115 @@
+package db
+
+import (
+	"fmt"
+	"math"
+)
+
+// earthRadiusKm is the mean Earth radius used for great-circle distances.
+const earthRadiusKm = 6371.0088
+
+// KmPerDegree is the length of one degree of great-circle arc, used to convert
+// distances given in degrees (as FDSN clients do) to kilometres.
+const KmPerDegree = earthRadiusKm * math.Pi / 180
+
+// BoundingBox selects earthquakes whose epicenter lies inside a lon/lat box.
+// A box whose MinLon is greater than its MaxLon wraps across the antimeridian.
+type BoundingBox struct {
+	MinLon, MinLat, MaxLon, MaxLat float64
+}
+
+// Circle selects earthquakes whose epicenter lies within RadiusKm of a point,
+// measured along the Earth's surface. A non-zero MinRadiusKm turns it into a
+// ring that also excludes epicenters closer than that.
+type Circle struct {
+	Latitude, Longitude, RadiusKm float64
+	MinRadiusKm                   float64
+}
+
+// condition returns the SQL for the box. param adds an argument and returns its
+// placeholder.
+func (b BoundingBox) condition(param func(interface{}) string) string {
+	lat := fmt.Sprintf("latitude BETWEEN %s AND %s", param(b.MinLat), param(b.MaxLat))
+	if b.MinLon > b.MaxLon {
+		return fmt.Sprintf("%s AND (longitude >= %s OR longitude <= %s)", lat, param(b.MinLon), param(b.MaxLon))
+	}
+	return fmt.Sprintf("%s AND longitude BETWEEN %s AND %s", lat, param(b.MinLon), param(b.MaxLon))
+}
+
+// Contains reports whether the point lies inside the box.
+func (b BoundingBox) Contains(latitude, longitude float64) bool {
+	if latitude < b.MinLat || latitude > b.MaxLat {
+		return false
+	}
+	if b.MinLon > b.MaxLon {
+		return longitude >= b.MinLon || longitude <= b.MaxLon
+	}
+	return longitude >= b.MinLon && longitude <= b.MaxLon
+}
+
+// bounds returns a box enclosing the circle, so the (latitude, longitude) index
+// can discard most rows before the distance is computed.
+func (c Circle) bounds() BoundingBox {
+	latDelta := c.RadiusKm / KmPerDegree
+	box := BoundingBox{
+		MinLat: math.Max(c.Latitude-latDelta, -90),
+		MaxLat: math.Min(c.Latitude+latDelta, 90),
+		MinLon: -180,
+		MaxLon: 180,
+	}
+	// Near the poles, or for huge radii, every longitude can be in range
+	if box.MinLat == -90 || box.MaxLat == 90 {
+		return box
+	}
+	lonDelta := math.Asin(math.Min(1, math.Sin(c.RadiusKm/earthRadiusKm)/math.Cos(c.Latitude*math.Pi/180))) * 180 / math.Pi
+	if lonDelta >= 180 || math.IsNaN(lonDelta) {
+		return box
+	}
+	box.MinLon = normalizeLongitude(c.Longitude - lonDelta)
+	box.MaxLon = normalizeLongitude(c.Longitude + lonDelta)
+	return box
+}
+
+// condition returns the SQL for the circle: a bounding box prefilter followed
+// by the haversine distance.
+func (c Circle) condition(param func(interface{}) string) string {
+	lat, lon := param(c.Latitude), param(c.Longitude)
+	distance := fmt.Sprintf(`2 * %g * ASIN(LEAST(1, SQRT(
+		POWER(SIN(RADIANS(latitude - %s) / 2), 2) +
+		COS(RADIANS(%s)) * COS(RADIANS(latitude)) * POWER(SIN(RADIANS(longitude - %s) / 2), 2))))`,
+		earthRadiusKm, lat, lat, lon)
+	condition := fmt.Sprintf("%s AND %s <= %s", c.bounds().condition(param), distance, param(c.RadiusKm))
+	if c.MinRadiusKm > 0 {
+		condition += fmt.Sprintf(" AND %s >= %s", distance, param(c.MinRadiusKm))
+	}
+	return condition
+}
+
+// normalizeLongitude wraps a longitude into [-180, 180].
+func normalizeLongitude(lon float64) float64 {
+	for lon > 180 {
+		lon -= 360
+	}
+	for lon < -180 {
+		lon += 360
+	}
+	return lon
+}
+
+// Contains reports whether the point lies within the circle, or within the
+// ring when MinRadiusKm is set. It uses the same haversine formula as the SQL.
+func (c Circle) Contains(latitude, longitude float64) bool {
+	if !c.bounds().Contains(latitude, longitude) {
+		return false
+	}
+	distance := distanceKm(c.Latitude, c.Longitude, latitude, longitude)
+	return distance <= c.RadiusKm && (c.MinRadiusKm <= 0 || distance >= c.MinRadiusKm)
+}
+
+// distanceKm is the great-circle distance between two points.
+func distanceKm(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := math.Pi / 180
+	h := math.Pow(math.Sin((lat2-lat1)*rad/2), 2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Pow(math.Sin((lon2-lon1)*rad/2), 2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(h)))
+}
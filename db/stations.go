@@ -0,0 +1,102 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Ward-R/Jishin-API/types"
+)
+
+// InsertStationObservations stores the per-station intensity readings for a report,
+// replacing any readings stored from an earlier revision. Stations are upserted so
+// their names and coordinates track the latest JMA data.
+func InsertStationObservations(ctx context.Context, conn DB, reportID string, stations []types.StationObservation) error {
+	if len(stations) == 0 {
+		return nil
+	}
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("error starting station transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `DELETE FROM intensity_observations WHERE report_id = $1`, reportID)
+	if err != nil {
+		return fmt.Errorf("error clearing station observations: %w", err)
+	}
+
+	stationQuery := `
+        INSERT INTO intensity_stations (
+            station_code, jp_name, en_name, latitude, longitude,
+            pref_code, area_code, city_code
+        ) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+        ON CONFLICT (station_code) DO UPDATE SET
+            jp_name = EXCLUDED.jp_name,
+            en_name = EXCLUDED.en_name,
+            latitude = EXCLUDED.latitude,
+            longitude = EXCLUDED.longitude,
+            pref_code = EXCLUDED.pref_code,
+            area_code = EXCLUDED.area_code,
+            city_code = EXCLUDED.city_code`
+
+	observationQuery := `
+        INSERT INTO intensity_observations (report_id, station_code, intensity)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (report_id, station_code) DO UPDATE SET
+            intensity = EXCLUDED.intensity`
+
+	for _, st := range stations {
+		_, err = tx.Exec(ctx, stationQuery,
+			st.StationCode, st.JpName, st.EnName, st.Latitude, st.Longitude,
+			st.PrefCode, st.AreaCode, st.CityCode,
+		)
+		if err != nil {
+			return fmt.Errorf("error inserting station %s: %w", st.StationCode, err)
+		}
+
+		_, err = tx.Exec(ctx, observationQuery, reportID, st.StationCode, st.Intensity)
+		if err != nil {
+			return fmt.Errorf("error inserting observation for station %s: %w", st.StationCode, err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("error committing station observations: %w", err)
+	}
+	return nil
+}
+
+// GetStationObservations returns every station reading recorded for a report.
+// Readings for retracted earthquakes are hidden unless includeRetracted is set.
+func GetStationObservations(ctx context.Context, conn DB, reportID string, includeRetracted bool) ([]types.StationObservation, error) {
+	query := `
+          SELECT o.report_id, s.station_code, s.jp_name, s.en_name, o.intensity,
+                 s.latitude, s.longitude, s.pref_code, s.area_code, s.city_code
+          FROM intensity_observations o
+          JOIN intensity_stations s ON s.station_code = o.station_code
+          JOIN earthquakes e ON e.report_id = o.report_id
+          WHERE o.report_id = $1 AND ` + retractedCondition(includeRetracted) + `
+          ORDER BY s.pref_code, s.area_code, s.city_code, s.station_code`
+
+	rows, err := conn.Query(ctx, query, reportID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying station observations: %w", err)
+	}
+	defer rows.Close()
+
+	stations := []types.StationObservation{}
+	for rows.Next() {
+		var st types.StationObservation
+		err := rows.Scan(
+			&st.ReportId, &st.StationCode, &st.JpName, &st.EnName, &st.Intensity,
+			&st.Latitude, &st.Longitude, &st.PrefCode, &st.AreaCode, &st.CityCode,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		stations = append(stations, st)
+	}
+
+	return stations, nil
+}
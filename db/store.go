@@ -0,0 +1,186 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/Ward-R/Jishin-API/types"
+)
+
+// EarthquakeStore is everything the API and the sync need from storage. The
+// Postgres store is used in production; the memory store backs tests and
+// local experiments. Both must pass the conformance suite in db/storetest.
+//
+// Lookups of a single row that find nothing return an error wrapping
+// pgx.ErrNoRows, whichever store is used.
+type EarthquakeStore interface {
+	Ping(ctx context.Context) error
+
+	EarthquakeExists(ctx context.Context, reportID string) (bool, error)
+	GetReportTimes(ctx context.Context, reportIDs []string) (map[string]time.Time, error)
+	InsertEarthquake(ctx context.Context, quake *types.Earthquake) error
+	UpdateEarthquake(ctx context.Context, quake *types.Earthquake) error
+	RetractEarthquake(ctx context.Context, quake *types.Earthquake) error
+	GetEarthquakeById(ctx context.Context, id string, includeRetracted bool) (*types.Earthquake, error)
+	GetEarthquakes(ctx context.Context, filter EarthquakeFilter) (*EarthquakePage, error)
+	StreamEarthquakes(ctx context.Context, filter EarthquakeFilter, fn func(eq *types.Earthquake) error) error
+	GetRecentEarthquakes(ctx context.Context, filter EarthquakeFilter) (*EarthquakePage, error)
+	StreamRecentEarthquakes(ctx context.Context, filter EarthquakeFilter, fn func(eq *types.Earthquake) error) error
+	GetEarthquakeStats(ctx context.Context, includeRetracted bool) (map[string]interface{}, error)
+	GetLargestEarthquakeToday(ctx context.Context, includeRetracted bool) (*types.Earthquake, error)
+	GetLargestEarthquakeThisWeek(ctx context.Context, includeRetracted bool) (*types.Earthquake, error)
+
+	InsertRevision(ctx context.Context, rev *types.Revision) error
+	GetRevisions(ctx context.Context, eventID string, includeRetracted bool) ([]types.Revision, error)
+	GetEventRevisions(ctx context.Context, eventIDs []string) (map[string][]types.Revision, error)
+	InsertStationObservations(ctx context.Context, reportID string, stations []types.StationObservation) error
+	GetStationObservations(ctx context.Context, reportID string, includeRetracted bool) ([]types.StationObservation, error)
+	InsertRegionIntensities(ctx context.Context, reportID string, regions []types.RegionIntensity) error
+	GetRegionIntensities(ctx context.Context, reportID string, level string, includeRetracted bool) ([]types.RegionIntensity, error)
+
+	InsertWebhookSubscription(ctx context.Context, sub *types.WebhookSubscription) error
+	GetWebhookSubscription(ctx context.Context, id string) (*types.WebhookSubscription, error)
+	GetActiveWebhookSubscriptions(ctx context.Context) ([]types.WebhookSubscription, error)
+	DeleteWebhookSubscription(ctx context.Context, id string) (bool, error)
+	InsertWebhookDelivery(ctx context.Context, delivery *types.WebhookDelivery) error
+	ClaimDueWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]DueWebhookDelivery, error)
+	RecordWebhookSuccess(ctx context.Context, delivery *types.WebhookDelivery) error
+	RecordWebhookFailure(ctx context.Context, delivery *types.WebhookDelivery, nextAttempt time.Time) error
+	GetWebhookDeliveries(ctx context.Context, subscriptionID string, limit int) ([]types.WebhookDelivery, error)
+}
+
+// PostgresStore is the EarthquakeStore backed by PostgreSQL. Each method runs
+// the package function of the same name on its connection.
+type PostgresStore struct {
+	conn DB
+}
+
+// NewPostgresStore returns a store that queries conn, usually a *pgxpool.Pool.
+func NewPostgresStore(conn DB) *PostgresStore {
+	return &PostgresStore{conn: conn}
+}
+
+func (s *PostgresStore) Ping(ctx context.Context) error {
+	return s.conn.Ping(ctx)
+}
+
+func (s *PostgresStore) EarthquakeExists(ctx context.Context, reportID string) (bool, error) {
+	return EarthquakeExists(ctx, s.conn, reportID)
+}
+
+func (s *PostgresStore) GetReportTimes(ctx context.Context, reportIDs []string) (map[string]time.Time, error) {
+	return GetReportTimes(ctx, s.conn, reportIDs)
+}
+
+func (s *PostgresStore) InsertEarthquake(ctx context.Context, quake *types.Earthquake) error {
+	return InsertEarthquake(ctx, s.conn, quake)
+}
+
+func (s *PostgresStore) UpdateEarthquake(ctx context.Context, quake *types.Earthquake) error {
+	return UpdateEarthquake(ctx, s.conn, quake)
+}
+
+func (s *PostgresStore) RetractEarthquake(ctx context.Context, quake *types.Earthquake) error {
+	return RetractEarthquake(ctx, s.conn, quake)
+}
+
+func (s *PostgresStore) GetEarthquakeById(ctx context.Context, id string, includeRetracted bool) (*types.Earthquake, error) {
+	return GetEarthquakeById(ctx, s.conn, id, includeRetracted)
+}
+
+func (s *PostgresStore) GetEarthquakes(ctx context.Context, filter EarthquakeFilter) (*EarthquakePage, error) {
+	return GetEarthquakes(ctx, s.conn, filter)
+}
+
+func (s *PostgresStore) StreamEarthquakes(ctx context.Context, filter EarthquakeFilter, fn func(eq *types.Earthquake) error) error {
+	return StreamEarthquakes(ctx, s.conn, filter, fn)
+}
+
+func (s *PostgresStore) GetRecentEarthquakes(ctx context.Context, filter EarthquakeFilter) (*EarthquakePage, error) {
+	return GetRecentEarthquakes(ctx, s.conn, filter)
+}
+
+func (s *PostgresStore) StreamRecentEarthquakes(ctx context.Context, filter EarthquakeFilter, fn func(eq *types.Earthquake) error) error {
+	return StreamRecentEarthquakes(ctx, s.conn, filter, fn)
+}
+
+func (s *PostgresStore) GetEarthquakeStats(ctx context.Context, includeRetracted bool) (map[string]interface{}, error) {
+	return GetEarthquakeStats(ctx, s.conn, includeRetracted)
+}
+
+func (s *PostgresStore) GetLargestEarthquakeToday(ctx context.Context, includeRetracted bool) (*types.Earthquake, error) {
+	return GetLargestEarthquakeToday(ctx, s.conn, includeRetracted)
+}
+
+func (s *PostgresStore) GetLargestEarthquakeThisWeek(ctx context.Context, includeRetracted bool) (*types.Earthquake, error) {
+	return GetLargestEarthquakeThisWeek(ctx, s.conn, includeRetracted)
+}
+
+func (s *PostgresStore) InsertRevision(ctx context.Context, rev *types.Revision) error {
+	return InsertRevision(ctx, s.conn, rev)
+}
+
+func (s *PostgresStore) GetRevisions(ctx context.Context, eventID string, includeRetracted bool) ([]types.Revision, error) {
+	return GetRevisions(ctx, s.conn, eventID, includeRetracted)
+}
+
+func (s *PostgresStore) GetEventRevisions(ctx context.Context, eventIDs []string) (map[string][]types.Revision, error) {
+	return GetEventRevisions(ctx, s.conn, eventIDs)
+}
+
+func (s *PostgresStore) InsertStationObservations(ctx context.Context, reportID string, stations []types.StationObservation) error {
+	return InsertStationObservations(ctx, s.conn, reportID, stations)
+}
+
+func (s *PostgresStore) GetStationObservations(ctx context.Context, reportID string, includeRetracted bool) ([]types.StationObservation, error) {
+	return GetStationObservations(ctx, s.conn, reportID, includeRetracted)
+}
+
+func (s *PostgresStore) InsertRegionIntensities(ctx context.Context, reportID string, regions []types.RegionIntensity) error {
+	return InsertRegionIntensities(ctx, s.conn, reportID, regions)
+}
+
+func (s *PostgresStore) GetRegionIntensities(ctx context.Context, reportID string, level string, includeRetracted bool) ([]types.RegionIntensity, error) {
+	return GetRegionIntensities(ctx, s.conn, reportID, level, includeRetracted)
+}
+
+func (s *PostgresStore) InsertWebhookSubscription(ctx context.Context, sub *types.WebhookSubscription) error {
+	return InsertWebhookSubscription(ctx, s.conn, sub)
+}
+
+func (s *PostgresStore) GetWebhookSubscription(ctx context.Context, id string) (*types.WebhookSubscription, error) {
+	return GetWebhookSubscription(ctx, s.conn, id)
+}
+
+func (s *PostgresStore) GetActiveWebhookSubscriptions(ctx context.Context) ([]types.WebhookSubscription, error) {
+	return GetActiveWebhookSubscriptions(ctx, s.conn)
+}
+
+func (s *PostgresStore) DeleteWebhookSubscription(ctx context.Context, id string) (bool, error) {
+	return DeleteWebhookSubscription(ctx, s.conn, id)
+}
+
+func (s *PostgresStore) InsertWebhookDelivery(ctx context.Context, delivery *types.WebhookDelivery) error {
+	return InsertWebhookDelivery(ctx, s.conn, delivery)
+}
+
+func (s *PostgresStore) ClaimDueWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]DueWebhookDelivery, error) {
+	return ClaimDueWebhookDeliveries(ctx, s.conn, limit, lease)
+}
+
+func (s *PostgresStore) RecordWebhookSuccess(ctx context.Context, delivery *types.WebhookDelivery) error {
+	return RecordWebhookSuccess(ctx, s.conn, delivery)
+}
+
+func (s *PostgresStore) RecordWebhookFailure(ctx context.Context, delivery *types.WebhookDelivery, nextAttempt time.Time) error {
+	return RecordWebhookFailure(ctx, s.conn, delivery, nextAttempt)
+}
+
+func (s *PostgresStore) GetWebhookDeliveries(ctx context.Context, subscriptionID string, limit int) ([]types.WebhookDelivery, error) {
+	return GetWebhookDeliveries(ctx, s.conn, subscriptionID, limit)
+}
+
+var (
+	_ EarthquakeStore = (*PostgresStore)(nil)
+	_ EarthquakeStore = (*MemoryStore)(nil)
+)
@@ -0,0 +1,52 @@
+package db_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/Ward-R/Jishin-API/db"
+	"github.com/Ward-R/Jishin-API/db/storetest"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+func TestMemoryStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) db.EarthquakeStore {
+		return db.NewMemoryStore()
+	})
+}
+
+// testDatabase connects to the database in TEST_DATABASE_URL, skipping the
+// test when it is not set. Tests empty or drop its tables, so never point it
+// at real data.
+func testDatabase(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	pool, err := pgxpool.Connect(context.Background(), url)
+	if err != nil {
+		t.Fatalf("connecting to test database: %v", err)
+	}
+	t.Cleanup(pool.Close)
+	return pool
+}
+
+func TestPostgresStore(t *testing.T) {
+	ctx := context.Background()
+	pool := testDatabase(t)
+	if _, err := db.Migrate(ctx, pool); err != nil {
+		t.Fatalf("migrating test database: %v", err)
+	}
+
+	storetest.Run(t, func(t *testing.T) db.EarthquakeStore {
+		_, err := pool.Exec(ctx, `TRUNCATE earthquakes, earthquake_revisions, intensity_stations,
+			intensity_observations, intensity_regions, webhook_subscriptions, webhook_deliveries
+			RESTART IDENTITY CASCADE`)
+		if err != nil {
+			t.Fatalf("emptying test database: %v", err)
+		}
+		return db.NewPostgresStore(pool)
+	})
+}
@@ -0,0 +1,824 @@
+// Package storetest is the conformance suite for db.EarthquakeStore. Every
+// store runs it from its own test, so the in-memory store used in tests cannot
+// drift from what Postgres does in production.
+package storetest
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Ward-R/Jishin-API/db"
+	"github.com/Ward-R/Jishin-API/types"
+	"github.com/jackc/pgx/v4"
+)
+
+// Factory returns an empty store for one test.
+type Factory func(t *testing.T) db.EarthquakeStore
+
+// Run runs every conformance test against stores made by newStore.
+func Run(t *testing.T, newStore Factory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, store db.EarthquakeStore)
+	}{
+		{"InsertUpdateRetract", testInsertUpdateRetract},
+		{"ReportTimes", testReportTimes},
+		{"Filters", testFilters},
+		{"Orders", testOrders},
+		{"Paging", testPaging},
+		{"Stream", testStream},
+		{"Recent", testRecent},
+		{"Stats", testStats},
+		{"Largest", testLargest},
+		{"Revisions", testRevisions},
+		{"Stations", testStations},
+		{"Regions", testRegions},
+		{"WebhookSubscriptions", testWebhookSubscriptions},
+		{"WebhookDeliveries", testWebhookDeliveries},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newStore(t))
+		})
+	}
+}
+
+// base is 2024-01-01 12:00 JST. Times are whole seconds so they survive the
+// microsecond precision of Postgres.
+var base = time.Date(2024, 1, 1, 3, 0, 0, 0, time.UTC)
+
+// quake returns an earthquake with plausible defaults; its latest report is
+// five minutes after the origin time.
+func quake(id string, origin time.Time, magnitude float64) *types.Earthquake {
+	return &types.Earthquake{
+		ReportId:       id,
+		OriginTime:     origin,
+		ArrivalTime:    origin.Add(time.Minute),
+		Magnitude:      magnitude,
+		DepthKm:        10,
+		Latitude:       35.0,
+		Longitude:      139.0,
+		MaxIntensity:   "1",
+		JpLocation:     "東京湾",
+		EnLocation:     "Tokyo Bay",
+		Serial:         1,
+		InfoType:       types.InfoTypeIssued,
+		ReportDateTime: origin.Add(5 * time.Minute),
+	}
+}
+
+// insert stores each earthquake, retracting those marked Retracted.
+func insert(t *testing.T, store db.EarthquakeStore, quakes ...*types.Earthquake) {
+	t.Helper()
+	ctx := context.Background()
+	for _, eq := range quakes {
+		if err := store.InsertEarthquake(ctx, eq); err != nil {
+			t.Fatalf("InsertEarthquake(%s): %v", eq.ReportId, err)
+		}
+		if eq.Retracted {
+			if err := store.RetractEarthquake(ctx, eq); err != nil {
+				t.Fatalf("RetractEarthquake(%s): %v", eq.ReportId, err)
+			}
+		}
+	}
+}
+
+// ids joins the report IDs of earthquakes for easy comparison.
+func ids(earthquakes []types.Earthquake) string {
+	var out []string
+	for _, eq := range earthquakes {
+		out = append(out, eq.ReportId)
+	}
+	return strings.Join(out, " ")
+}
+
+func list(t *testing.T, store db.EarthquakeStore, filter db.EarthquakeFilter) *db.EarthquakePage {
+	t.Helper()
+	page, err := store.GetEarthquakes(context.Background(), filter)
+	if err != nil {
+		t.Fatalf("GetEarthquakes(%+v): %v", filter, err)
+	}
+	return page
+}
+
+func float(v float64) *float64 { return &v }
+func integer(v int) *int       { return &v }
+func boolean(v bool) *bool     { return &v }
+
+func testInsertUpdateRetract(t *testing.T, store db.EarthquakeStore) {
+	ctx := context.Background()
+	eq := quake("q1", base, 5.2)
+	eq.Tsunami, eq.TsunamiWarning = true, true
+	eq.Stations = []types.StationObservation{{StationCode: "1"}}
+	insert(t, store, eq)
+
+	if exists, err := store.EarthquakeExists(ctx, "q1"); err != nil || !exists {
+		t.Errorf("EarthquakeExists(q1) = %v, %v; want true", exists, err)
+	}
+	if exists, err := store.EarthquakeExists(ctx, "missing"); err != nil || exists {
+		t.Errorf("EarthquakeExists(missing) = %v, %v; want false", exists, err)
+	}
+	if err := store.InsertEarthquake(ctx, quake("q1", base, 1)); err == nil {
+		t.Error("inserting a duplicate report ID succeeded")
+	}
+
+	got, err := store.GetEarthquakeById(ctx, "q1", false)
+	if err != nil {
+		t.Fatalf("GetEarthquakeById: %v", err)
+	}
+	if !got.OriginTime.Equal(eq.OriginTime) || !got.ReportDateTime.Equal(eq.ReportDateTime) ||
+		got.Magnitude != 5.2 || got.EnLocation != "Tokyo Bay" || !got.Tsunami || !got.TsunamiWarning || got.Retracted || got.Stations != nil {
+		t.Errorf("GetEarthquakeById = %+v, want the inserted row", got)
+	}
+	if _, err := store.GetEarthquakeById(ctx, "missing", true); !errors.Is(err, pgx.ErrNoRows) {
+		t.Errorf("GetEarthquakeById(missing) error = %v, want pgx.ErrNoRows", err)
+	}
+
+	update := quake("q1", base, 5.4)
+	update.Serial = 2
+	if err := store.UpdateEarthquake(ctx, update); err != nil {
+		t.Fatalf("UpdateEarthquake: %v", err)
+	}
+	if got, _ := store.GetEarthquakeById(ctx, "q1", false); got == nil || got.Magnitude != 5.4 || got.Serial != 2 {
+		t.Errorf("after update got %+v, want magnitude 5.4 serial 2", got)
+	}
+
+	cancel := quake("q1", base, 0)
+	cancel.Serial = 3
+	cancel.InfoType = types.InfoTypeCancelled
+	if err := store.RetractEarthquake(ctx, cancel); err != nil {
+		t.Fatalf("RetractEarthquake: %v", err)
+	}
+	if _, err := store.GetEarthquakeById(ctx, "q1", false); !errors.Is(err, pgx.ErrNoRows) {
+		t.Errorf("retracted earthquake visible by default, error = %v", err)
+	}
+	got, err = store.GetEarthquakeById(ctx, "q1", true)
+	if err != nil {
+		t.Fatalf("GetEarthquakeById(include retracted): %v", err)
+	}
+	if !got.Retracted || got.Serial != 3 || got.InfoType != types.InfoTypeCancelled || got.Magnitude != 5.4 {
+		t.Errorf("retracted row = %+v, want retracted serial 3 keeping magnitude 5.4", got)
+	}
+
+	// A later update does not bring a retracted earthquake back
+	if err := store.UpdateEarthquake(ctx, update); err != nil {
+		t.Fatalf("UpdateEarthquake: %v", err)
+	}
+	if got, _ := store.GetEarthquakeById(ctx, "q1", true); got == nil || !got.Retracted {
+		t.Errorf("update cleared retracted: %+v", got)
+	}
+}
+
+func testReportTimes(t *testing.T, store db.EarthquakeStore) {
+	ctx := context.Background()
+	insert(t, store, quake("q1", base, 4), quake("q2", base.Add(time.Hour), 4))
+	retract(t, store, "q2")
+
+	times, err := store.GetReportTimes(ctx, []string{"q1", "q2", "missing"})
+	if err != nil {
+		t.Fatalf("GetReportTimes: %v", err)
+	}
+	q2, _ := store.GetEarthquakeById(ctx, "q2", true)
+	if len(times) != 2 || !times["q1"].Equal(base.Add(5*time.Minute)) || q2 == nil || !times["q2"].Equal(q2.ReportDateTime) {
+		t.Errorf("GetReportTimes = %v, want the report times of q1 and retracted q2 only", times)
+	}
+
+	if times, err := store.GetReportTimes(ctx, nil); err != nil || len(times) != 0 {
+		t.Errorf("GetReportTimes(nil) = %v, %v; want none", times, err)
+	}
+}
+
+func testFilters(t *testing.T, store db.EarthquakeStore) {
+	a := quake("a", base, 3.0)
+	b := quake("b", base.Add(time.Hour), 5.0)
+	b.DepthKm, b.Latitude, b.Longitude, b.MaxIntensity, b.Tsunami = 50, 38.0, 142.0, "4", true
+	c := quake("c", base.Add(2*time.Hour), 6.5)
+	c.DepthKm, c.Latitude, c.Longitude, c.MaxIntensity = 300, 24.0, 125.0, "5+"
+	c.ReportDateTime = base.Add(26 * time.Hour)
+	d := quake("d", base.Add(3*time.Hour), 4.0)
+	d.Latitude, d.Longitude, d.MaxIntensity = -10.0, 179.5, "2"
+	e := quake("e", base.Add(4*time.Hour), 7.0)
+	e.Retracted = true
+	// 23:00 JST on the previous day
+	f := quake("f", base.Add(-13*time.Hour), 2.0)
+	f.Latitude, f.Longitude = 43.0, 141.3
+	insert(t, store, a, b, c, d, e, f)
+
+	tests := []struct {
+		name   string
+		filter db.EarthquakeFilter
+		want   string
+	}{
+		{"none", db.EarthquakeFilter{}, "d c b a f"},
+		{"include retracted", db.EarthquakeFilter{IncludeRetracted: true}, "e d c b a f"},
+		{"min magnitude", db.EarthquakeFilter{MinMagnitude: float(4)}, "d c b"},
+		{"max magnitude", db.EarthquakeFilter{MaxMagnitude: float(4)}, "d a f"},
+		{"depth range", db.EarthquakeFilter{MinDepth: integer(50), MaxDepth: integer(100)}, "b"},
+		{"min intensity", db.EarthquakeFilter{MinIntensity: "4"}, "c b"},
+		{"unknown intensity", db.EarthquakeFilter{MinIntensity: "9"}, ""},
+		{"tsunami", db.EarthquakeFilter{Tsunami: boolean(true)}, "b"},
+		{"no tsunami", db.EarthquakeFilter{Tsunami: boolean(false)}, "d c a f"},
+		{"bbox", db.EarthquakeFilter{BBox: &db.BoundingBox{MinLon: 138, MinLat: 34, MaxLon: 143, MaxLat: 39}}, "b a"},
+		{"bbox across antimeridian", db.EarthquakeFilter{BBox: &db.BoundingBox{MinLon: 170, MinLat: -20, MaxLon: -170, MaxLat: 0}}, "d"},
+		{"near", db.EarthquakeFilter{Near: &db.Circle{Latitude: 35, Longitude: 139, RadiusKm: 100}}, "a"},
+		{"ring", db.EarthquakeFilter{Near: &db.Circle{Latitude: 35, Longitude: 139, RadiusKm: 1000, MinRadiusKm: 100}}, "b f"},
+		{"date in JST", db.EarthquakeFilter{Date: "2023-12-31"}, "f"},
+		{"start and end", db.EarthquakeFilter{Start: base.Add(time.Hour), End: base.Add(3 * time.Hour)}, "c b"},
+		{"updated after", db.EarthquakeFilter{UpdatedAfter: d.ReportDateTime}, "c"},
+		{"combined", db.EarthquakeFilter{MinMagnitude: float(3), Date: "2024-01-01", Tsunami: boolean(false)}, "d c a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ids(list(t, store, tt.filter).Earthquakes); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	ctx := context.Background()
+	if _, err := store.GetEarthquakes(ctx, db.EarthquakeFilter{Date: "2024-13-01"}); err == nil {
+		t.Error("invalid date accepted")
+	}
+	if _, err := store.GetEarthquakes(ctx, db.EarthquakeFilter{Order: "sideways"}); err == nil {
+		t.Error("unknown order accepted")
+	}
+}
+
+func testOrders(t *testing.T, store db.EarthquakeStore) {
+	d := quake("d", base.Add(2*time.Hour), 5.5)
+	e := quake("e", base.Add(-time.Hour), 6.0)
+	insert(t, store,
+		quake("a", base, 5.0),
+		quake("b", base, 5.0),
+		quake("c", base.Add(time.Hour), 5.0),
+		d, e,
+	)
+
+	tests := []struct {
+		order db.EarthquakeOrder
+		want  string
+	}{
+		{db.OrderTimeDesc, "d c b a e"},
+		{db.OrderTimeAsc, "e a b c d"},
+		{db.OrderMagnitudeDesc, "e d c b a"},
+		{db.OrderMagnitudeAsc, "c b a d e"},
+	}
+	for _, tt := range tests {
+		if got := ids(list(t, store, db.EarthquakeFilter{Order: tt.order}).Earthquakes); got != tt.want {
+			t.Errorf("order %q: got %q, want %q", tt.order, got, tt.want)
+		}
+	}
+}
+
+func testPaging(t *testing.T, store db.EarthquakeStore) {
+	insert(t, store,
+		quake("p1", base, 1),
+		quake("p2", base.Add(time.Hour), 2),
+		quake("p3", base.Add(time.Hour), 3),
+		quake("p4", base.Add(2*time.Hour), 4),
+		quake("p5", base.Add(3*time.Hour), 5),
+	)
+
+	var pages []string
+	filter := db.EarthquakeFilter{Limit: 2}
+	for i := 0; i < 5; i++ {
+		page := list(t, store, filter)
+		pages = append(pages, ids(page.Earthquakes))
+		if page.Next == nil {
+			break
+		}
+		filter.After = page.Next
+	}
+	if got := strings.Join(pages, " | "); got != "p5 p4 | p3 p2 | p1" {
+		t.Errorf("keyset pages = %q", got)
+	}
+
+	page := list(t, store, db.EarthquakeFilter{Limit: 2})
+	if page.Next == nil || page.Next.ReportId != "p4" || !page.Next.OriginTime.Equal(base.Add(2*time.Hour)) {
+		t.Errorf("Next = %+v, want the last row of the page", page.Next)
+	}
+	if page := list(t, store, db.EarthquakeFilter{Limit: 5}); page.Next != nil {
+		t.Errorf("exactly full last page has Next %+v", page.Next)
+	}
+	if got := ids(list(t, store, db.EarthquakeFilter{Limit: 2, Offset: 1}).Earthquakes); got != "p4 p3" {
+		t.Errorf("offset page = %q", got)
+	}
+	if got := ids(list(t, store, db.EarthquakeFilter{Offset: 10}).Earthquakes); got != "" {
+		t.Errorf("offset past the end = %q", got)
+	}
+	page = list(t, store, db.EarthquakeFilter{Limit: -1})
+	if ids(page.Earthquakes) != "p5 p4 p3 p2 p1" || page.Next != nil {
+		t.Errorf("unlimited = %q, next %+v", ids(page.Earthquakes), page.Next)
+	}
+	if got := ids(list(t, store, db.EarthquakeFilter{}).Earthquakes); got != "p5 p4 p3 p2 p1" {
+		t.Errorf("default limit = %q", got)
+	}
+}
+
+func testStream(t *testing.T, store db.EarthquakeStore) {
+	ctx := context.Background()
+	insert(t, store,
+		quake("s1", base, 1),
+		quake("s2", base.Add(time.Hour), 2),
+		quake("s3", base.Add(2*time.Hour), 3),
+	)
+
+	var got []types.Earthquake
+	err := store.StreamEarthquakes(ctx, db.EarthquakeFilter{Limit: 2}, func(eq *types.Earthquake) error {
+		got = append(got, *eq)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("StreamEarthquakes: %v", err)
+	}
+	if ids(got) != "s3 s2" {
+		t.Errorf("streamed %q, want %q", ids(got), "s3 s2")
+	}
+
+	stop := errors.New("stop")
+	calls := 0
+	err = store.StreamEarthquakes(ctx, db.EarthquakeFilter{Limit: -1}, func(eq *types.Earthquake) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Errorf("callback error: got %v after %d calls, want stop after 1", err, calls)
+	}
+}
+
+func testRecent(t *testing.T, store db.EarthquakeStore) {
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+	insert(t, store,
+		quake("r1", now.Add(-time.Hour), 3),
+		quake("r2", now.Add(-23*time.Hour), 4),
+		quake("r3", now.Add(-25*time.Hour), 5),
+	)
+
+	page, err := store.GetRecentEarthquakes(ctx, db.EarthquakeFilter{})
+	if err != nil {
+		t.Fatalf("GetRecentEarthquakes: %v", err)
+	}
+	if ids(page.Earthquakes) != "r1 r2" {
+		t.Errorf("recent = %q, want %q", ids(page.Earthquakes), "r1 r2")
+	}
+	page, err = store.GetRecentEarthquakes(ctx, db.EarthquakeFilter{Start: now.Add(-2 * time.Hour)})
+	if err != nil {
+		t.Fatalf("GetRecentEarthquakes: %v", err)
+	}
+	if ids(page.Earthquakes) != "r1" {
+		t.Errorf("recent with later start = %q, want %q", ids(page.Earthquakes), "r1")
+	}
+
+	var streamed []types.Earthquake
+	err = store.StreamRecentEarthquakes(ctx, db.EarthquakeFilter{}, func(eq *types.Earthquake) error {
+		streamed = append(streamed, *eq)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("StreamRecentEarthquakes: %v", err)
+	}
+	if ids(streamed) != "r1 r2" {
+		t.Errorf("streamed recent = %q, want %q", ids(streamed), "r1 r2")
+	}
+}
+
+func testStats(t *testing.T, store db.EarthquakeStore) {
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+	retracted := quake("x4", now.Add(-time.Minute), 7.0)
+	retracted.Retracted = true
+	insert(t, store,
+		quake("x1", now.Add(-time.Hour), 3.0),
+		quake("x2", now.Add(-48*time.Hour), 4.0),
+		quake("x3", now.Add(-72*time.Hour), 5.5),
+		retracted,
+	)
+
+	tests := []struct {
+		includeRetracted bool
+		total, recent    int
+		avg, max, min    float64
+		latest           time.Time
+	}{
+		{false, 3, 1, 4.17, 5.5, 3.0, now.Add(-time.Hour)},
+		{true, 4, 2, 4.88, 7.0, 3.0, now.Add(-time.Minute)},
+	}
+	for _, tt := range tests {
+		stats, err := store.GetEarthquakeStats(ctx, tt.includeRetracted)
+		if err != nil {
+			t.Fatalf("GetEarthquakeStats(%v): %v", tt.includeRetracted, err)
+		}
+		latest, _ := time.Parse(time.RFC3339, stats["latest_earthquake"].(string))
+		if stats["total_earthquakes"] != tt.total || stats["last_24_hours"] != tt.recent ||
+			math.Abs(stats["average_magnitude"].(float64)-tt.avg) > 1e-9 ||
+			stats["strongest_magnitude"] != tt.max || stats["weakest_magnitude"] != tt.min ||
+			!latest.Equal(tt.latest) {
+			t.Errorf("GetEarthquakeStats(%v) = %v", tt.includeRetracted, stats)
+		}
+	}
+}
+
+func testLargest(t *testing.T, store db.EarthquakeStore) {
+	ctx := context.Background()
+	if _, err := store.GetLargestEarthquakeToday(ctx, true); !errors.Is(err, pgx.ErrNoRows) {
+		t.Errorf("largest today of nothing: error = %v, want pgx.ErrNoRows", err)
+	}
+	if _, err := store.GetLargestEarthquakeThisWeek(ctx, true); !errors.Is(err, pgx.ErrNoRows) {
+		t.Errorf("largest this week of nothing: error = %v, want pgx.ErrNoRows", err)
+	}
+
+	now := time.Now().Truncate(time.Second)
+	retracted := quake("l2", now, 5.0)
+	retracted.Retracted = true
+	insert(t, store,
+		quake("l1", now, 4.0),
+		retracted,
+		quake("l3", now.AddDate(0, 0, -10), 8.0),
+	)
+
+	tests := []struct {
+		name             string
+		get              func(context.Context, bool) (*types.Earthquake, error)
+		includeRetracted bool
+		want             string
+	}{
+		{"today", store.GetLargestEarthquakeToday, false, "l1"},
+		{"today with retracted", store.GetLargestEarthquakeToday, true, "l2"},
+		{"week", store.GetLargestEarthquakeThisWeek, false, "l1"},
+		{"week with retracted", store.GetLargestEarthquakeThisWeek, true, "l2"},
+	}
+	for _, tt := range tests {
+		eq, err := tt.get(ctx, tt.includeRetracted)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if eq.ReportId != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, eq.ReportId, tt.want)
+		}
+	}
+}
+
+func testRevisions(t *testing.T, store db.EarthquakeStore) {
+	ctx := context.Background()
+	revision := func(serial int, infoType string, reported time.Time, magnitude float64) *types.Revision {
+		return &types.Revision{
+			EventId: "ev", Serial: serial, InfoType: infoType, ReportDateTime: reported,
+			OriginTime: base, Magnitude: magnitude, MaxIntensity: "3",
+		}
+	}
+	// JMA restarts serials per report kind, so serial 1 appears twice
+	revisions := []*types.Revision{
+		revision(2, types.InfoTypeIssued, base.Add(10*time.Minute), 5.1),
+		revision(1, types.InfoTypeIssued, base.Add(5*time.Minute), 4.9),
+		revision(1, types.InfoTypeCorrection, base.Add(time.Hour), 5.2),
+		revision(1, types.InfoTypeIssued, base.Add(5*time.Minute), 9.9),
+	}
+	for _, rev := range revisions {
+		if err := store.InsertRevision(ctx, rev); err != nil {
+			t.Fatalf("InsertRevision: %v", err)
+		}
+	}
+
+	// The history is visible before the earthquake row exists
+	got, err := store.GetRevisions(ctx, "ev", false)
+	if err != nil {
+		t.Fatalf("GetRevisions: %v", err)
+	}
+	var summary []string
+	for _, rev := range got {
+		summary = append(summary, rev.InfoType+"/"+strconv.FormatFloat(rev.Magnitude, 'f', -1, 64))
+	}
+	if want := "発表/4.9 発表/5.1 訂正/5.2"; strings.Join(summary, " ") != want {
+		t.Errorf("revisions = %q, want %q", strings.Join(summary, " "), want)
+	}
+	if got, err := store.GetRevisions(ctx, "missing", true); err != nil || len(got) != 0 {
+		t.Errorf("GetRevisions(missing) = %v, %v; want none", got, err)
+	}
+
+	eq := quake("ev", base, 5.2)
+	eq.Retracted = true
+	insert(t, store, eq)
+	if got, _ := store.GetRevisions(ctx, "ev", false); len(got) != 0 {
+		t.Errorf("retracted history visible by default: %d revisions", len(got))
+	}
+	if got, _ := store.GetRevisions(ctx, "ev", true); len(got) != 3 {
+		t.Errorf("retracted history with include_retracted: %d revisions, want 3", len(got))
+	}
+
+	other := revision(1, types.InfoTypeIssued, base, 4.0)
+	other.EventId = "other"
+	if err := store.InsertRevision(ctx, other); err != nil {
+		t.Fatalf("InsertRevision: %v", err)
+	}
+	byEvent, err := store.GetEventRevisions(ctx, []string{"ev", "other", "missing"})
+	if err != nil {
+		t.Fatalf("GetEventRevisions: %v", err)
+	}
+	if len(byEvent) != 2 || len(byEvent["ev"]) != 3 || byEvent["ev"][2].InfoType != types.InfoTypeCorrection || len(byEvent["other"]) != 1 {
+		t.Errorf("GetEventRevisions = %+v, want the three revisions of retracted ev, oldest first, and one of other", byEvent)
+	}
+}
+
+func testStations(t *testing.T, store db.EarthquakeStore) {
+	ctx := context.Background()
+	insert(t, store, quake("st", base, 5))
+
+	stations := []types.StationObservation{
+		{StationCode: "3", JpName: "丙", EnName: "C", Intensity: "2", PrefCode: "13", AreaCode: "350", CityCode: "1310100"},
+		{StationCode: "1", JpName: "甲", EnName: "A", Intensity: "4", PrefCode: "13", AreaCode: "350", CityCode: "1310100"},
+		{StationCode: "2", JpName: "乙", EnName: "B", Intensity: "3", PrefCode: "11", AreaCode: "331", CityCode: "1110000"},
+	}
+	if err := store.InsertStationObservations(ctx, "st", stations); err != nil {
+		t.Fatalf("InsertStationObservations: %v", err)
+	}
+	got, err := store.GetStationObservations(ctx, "st", false)
+	if err != nil {
+		t.Fatalf("GetStationObservations: %v", err)
+	}
+	var codes []string
+	for _, st := range got {
+		codes = append(codes, st.StationCode+"="+st.Intensity)
+		if st.ReportId != "st" {
+			t.Errorf("station %s has report ID %q", st.StationCode, st.ReportId)
+		}
+	}
+	if want := "2=3 1=4 3=2"; strings.Join(codes, " ") != want {
+		t.Errorf("stations = %q, want %q", strings.Join(codes, " "), want)
+	}
+
+	// A later revision replaces the readings and renames the station
+	stations[0].Intensity, stations[0].EnName = "5-", "C2"
+	if err := store.InsertStationObservations(ctx, "st", stations[:1]); err != nil {
+		t.Fatalf("InsertStationObservations: %v", err)
+	}
+	if err := store.InsertStationObservations(ctx, "st", nil); err != nil {
+		t.Fatalf("InsertStationObservations(nil): %v", err)
+	}
+	got, _ = store.GetStationObservations(ctx, "st", false)
+	if len(got) != 1 || got[0].Intensity != "5-" || got[0].EnName != "C2" {
+		t.Errorf("after replacing: %+v", got)
+	}
+
+	if got, err := store.GetStationObservations(ctx, "missing", true); err != nil || got == nil || len(got) != 0 {
+		t.Errorf("GetStationObservations(missing) = %#v, %v; want an empty list", got, err)
+	}
+	retract(t, store, "st")
+	if got, _ := store.GetStationObservations(ctx, "st", false); len(got) != 0 {
+		t.Errorf("stations of a retracted earthquake visible by default: %+v", got)
+	}
+	if got, _ := store.GetStationObservations(ctx, "st", true); len(got) != 1 {
+		t.Errorf("stations of a retracted earthquake with include_retracted: %+v", got)
+	}
+}
+
+func testRegions(t *testing.T, store db.EarthquakeStore) {
+	ctx := context.Background()
+	insert(t, store, quake("rg", base, 5))
+
+	regions := []types.RegionIntensity{
+		{Level: types.RegionLevelPref, Code: "13", JpName: "東京都", EnName: "Tokyo", MaxIntensity: "4"},
+		{Level: types.RegionLevelPref, Code: "11", JpName: "埼玉県", EnName: "Saitama", MaxIntensity: "3"},
+		{Level: types.RegionLevelArea, Code: "350", ParentCode: "13", JpName: "東京都２３区", EnName: "Tokyo 23 wards", MaxIntensity: "4"},
+		{Level: types.RegionLevelCity, Code: "1310100", ParentCode: "350", JpName: "千代田区", EnName: "Chiyoda", MaxIntensity: "4"},
+	}
+	if err := store.InsertRegionIntensities(ctx, "rg", regions); err != nil {
+		t.Fatalf("InsertRegionIntensities: %v", err)
+	}
+	got, err := store.GetRegionIntensities(ctx, "rg", types.RegionLevelPref, false)
+	if err != nil {
+		t.Fatalf("GetRegionIntensities: %v", err)
+	}
+	if len(got) != 2 || got[0].Code != "11" || got[1].Code != "13" || got[1].ReportId != "rg" {
+		t.Errorf("prefectures = %+v, want 11 then 13", got)
+	}
+	if got, _ := store.GetRegionIntensities(ctx, "rg", types.RegionLevelArea, false); len(got) != 1 || got[0].ParentCode != "13" {
+		t.Errorf("areas = %+v", got)
+	}
+
+	if err := store.InsertRegionIntensities(ctx, "rg", regions[:1]); err != nil {
+		t.Fatalf("InsertRegionIntensities: %v", err)
+	}
+	if got, _ := store.GetRegionIntensities(ctx, "rg", types.RegionLevelCity, false); got == nil || len(got) != 0 {
+		t.Errorf("cities kept after replacing: %#v, want an empty list", got)
+	}
+
+	retract(t, store, "rg")
+	if got, _ := store.GetRegionIntensities(ctx, "rg", types.RegionLevelPref, false); len(got) != 0 {
+		t.Errorf("regions of a retracted earthquake visible by default: %+v", got)
+	}
+	if got, _ := store.GetRegionIntensities(ctx, "rg", types.RegionLevelPref, true); len(got) != 1 {
+		t.Errorf("regions of a retracted earthquake with include_retracted: %+v", got)
+	}
+}
+
+// retract cancels a stored earthquake.
+func retract(t *testing.T, store db.EarthquakeStore, id string) {
+	t.Helper()
+	cancel := quake(id, base, 0)
+	cancel.InfoType = types.InfoTypeCancelled
+	if err := store.RetractEarthquake(context.Background(), cancel); err != nil {
+		t.Fatalf("RetractEarthquake(%s): %v", id, err)
+	}
+}
+
+func subscribe(t *testing.T, store db.EarthquakeStore, id string) *types.WebhookSubscription {
+	t.Helper()
+	sub := &types.WebhookSubscription{
+		Id:           id,
+		URL:          "https://example.com/" + id,
+		Secret:       "secret-" + id,
+		MinMagnitude: float(4.5),
+	}
+	if err := store.InsertWebhookSubscription(context.Background(), sub); err != nil {
+		t.Fatalf("InsertWebhookSubscription(%s): %v", id, err)
+	}
+	return sub
+}
+
+func queue(t *testing.T, store db.EarthquakeStore, subscriptionID, reportID string) *types.WebhookDelivery {
+	t.Helper()
+	delivery := &types.WebhookDelivery{
+		SubscriptionId: subscriptionID,
+		EventType:      "earthquake.created",
+		ReportId:       reportID,
+		Payload:        `{"report_id":"` + reportID + `"}`,
+	}
+	if err := store.InsertWebhookDelivery(context.Background(), delivery); err != nil {
+		t.Fatalf("InsertWebhookDelivery: %v", err)
+	}
+	return delivery
+}
+
+func testWebhookSubscriptions(t *testing.T, store db.EarthquakeStore) {
+	ctx := context.Background()
+	sub := subscribe(t, store, "sub_a")
+	if !sub.Active || sub.CreatedAt.IsZero() {
+		t.Errorf("inserted subscription = %+v, want active with a creation time", sub)
+	}
+
+	got, err := store.GetWebhookSubscription(ctx, "sub_a")
+	if err != nil {
+		t.Fatalf("GetWebhookSubscription: %v", err)
+	}
+	if got.URL != sub.URL || got.Secret != sub.Secret || got.MinMagnitude == nil || *got.MinMagnitude != 4.5 ||
+		got.Prefectures == nil || len(got.Prefectures) != 0 || !got.Active || got.DisabledAt != nil {
+		t.Errorf("GetWebhookSubscription = %+v", got)
+	}
+	if _, err := store.GetWebhookSubscription(ctx, "missing"); !errors.Is(err, pgx.ErrNoRows) {
+		t.Errorf("GetWebhookSubscription(missing) error = %v, want pgx.ErrNoRows", err)
+	}
+
+	subscribe(t, store, "sub_b")
+	active, err := store.GetActiveWebhookSubscriptions(ctx)
+	if err != nil || len(active) != 2 {
+		t.Fatalf("GetActiveWebhookSubscriptions = %v, %v; want 2", active, err)
+	}
+
+	queue(t, store, "sub_a", "q1")
+	deleted, err := store.DeleteWebhookSubscription(ctx, "sub_a")
+	if err != nil || !deleted {
+		t.Errorf("DeleteWebhookSubscription = %v, %v; want true", deleted, err)
+	}
+	if deleted, _ := store.DeleteWebhookSubscription(ctx, "sub_a"); deleted {
+		t.Error("deleting twice reported a deletion")
+	}
+	if deliveries, _ := store.GetWebhookDeliveries(ctx, "sub_a", 10); len(deliveries) != 0 {
+		t.Errorf("deliveries outlived their subscription: %+v", deliveries)
+	}
+	if active, _ := store.GetActiveWebhookSubscriptions(ctx); len(active) != 1 || active[0].Id != "sub_b" {
+		t.Errorf("active after delete = %+v", active)
+	}
+}
+
+func testWebhookDeliveries(t *testing.T, store db.EarthquakeStore) {
+	ctx := context.Background()
+	subscribe(t, store, "sub_a")
+	subscribe(t, store, "sub_b")
+	d1 := queue(t, store, "sub_a", "q1")
+	d2 := queue(t, store, "sub_a", "q2")
+	d3 := queue(t, store, "sub_a", "q3")
+	d4 := queue(t, store, "sub_b", "q1")
+	if d1.Status != types.DeliveryPending || !(d1.Id < d2.Id && d2.Id < d3.Id && d3.Id < d4.Id) {
+		t.Fatalf("queued deliveries = %+v %+v %+v %+v", d1, d2, d3, d4)
+	}
+
+	// claim leases the due deliveries for lease; with no lease they stay due
+	claim := func(limit int, lease time.Duration) string {
+		t.Helper()
+		deliveries, err := store.ClaimDueWebhookDeliveries(ctx, limit, lease)
+		if err != nil {
+			t.Fatalf("ClaimDueWebhookDeliveries: %v", err)
+		}
+		var out []string
+		for _, d := range deliveries {
+			out = append(out, d.ReportId+"@"+d.SubscriptionId)
+			if d.URL != "https://example.com/"+d.SubscriptionId || d.Secret != "secret-"+d.SubscriptionId || d.Payload == "" {
+				t.Errorf("due delivery %d lacks its endpoint: %+v", d.Id, d)
+			}
+		}
+		return strings.Join(out, " ")
+	}
+	due := func(limit int) string {
+		t.Helper()
+		return claim(limit, 0)
+	}
+	if got := due(10); got != "q1@sub_a q2@sub_a q3@sub_a q1@sub_b" {
+		t.Errorf("due = %q", got)
+	}
+	if got := due(2); got != "q1@sub_a q2@sub_a" {
+		t.Errorf("due with limit = %q", got)
+	}
+
+	d1.Attempts, d1.LastStatusCode = 1, 200
+	if err := store.RecordWebhookSuccess(ctx, d1); err != nil {
+		t.Fatalf("RecordWebhookSuccess: %v", err)
+	}
+	d2.Attempts, d2.LastStatusCode, d2.LastError = 1, 500, "endpoint responded 500"
+	if err := store.RecordWebhookFailure(ctx, d2, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("RecordWebhookFailure: %v", err)
+	}
+	if got := due(10); got != "q3@sub_a q1@sub_b" {
+		t.Errorf("due after one success and one retry = %q", got)
+	}
+	if sub, _ := store.GetWebhookSubscription(ctx, "sub_a"); sub == nil || sub.ConsecutiveFailures != 1 || !sub.Active {
+		t.Errorf("after a failure: %+v", sub)
+	}
+
+	log, err := store.GetWebhookDeliveries(ctx, "sub_a", 10)
+	if err != nil {
+		t.Fatalf("GetWebhookDeliveries: %v", err)
+	}
+	if len(log) != 3 || log[0].Id != d3.Id || log[2].Id != d1.Id {
+		t.Fatalf("delivery log = %+v, want newest first", log)
+	}
+	if log[2].Status != types.DeliveryDelivered || log[2].DeliveredAt == nil || log[2].LastStatusCode != 200 {
+		t.Errorf("delivered entry = %+v", log[2])
+	}
+	if log[1].Status != types.DeliveryPending || log[1].LastStatusCode != 500 || log[1].LastError == "" || log[1].Attempts != 1 {
+		t.Errorf("retrying entry = %+v", log[1])
+	}
+	if log[0].LastStatusCode != 0 || log[0].Payload != "" {
+		t.Errorf("untried entry = %+v", log[0])
+	}
+	if log, _ := store.GetWebhookDeliveries(ctx, "sub_a", 1); len(log) != 1 || log[0].Id != d3.Id {
+		t.Errorf("delivery log with limit = %+v", log)
+	}
+
+	// Running out of attempts disables the subscription and fails the rest
+	d3.Attempts, d3.LastError = 8, "connection refused"
+	if err := store.RecordWebhookFailure(ctx, d3, time.Time{}); err != nil {
+		t.Fatalf("RecordWebhookFailure: %v", err)
+	}
+	sub, _ := store.GetWebhookSubscription(ctx, "sub_a")
+	if sub == nil || sub.Active || sub.DisabledAt == nil || sub.ConsecutiveFailures != 2 {
+		t.Errorf("after giving up: %+v", sub)
+	}
+	log, _ = store.GetWebhookDeliveries(ctx, "sub_a", 10)
+	for _, d := range log {
+		if d.Id != d1.Id && d.Status != types.DeliveryFailed {
+			t.Errorf("delivery %d is %s, want failed", d.Id, d.Status)
+		}
+	}
+	if got := due(10); got != "q1@sub_b" {
+		t.Errorf("due after disabling = %q", got)
+	}
+	if active, _ := store.GetActiveWebhookSubscriptions(ctx); len(active) != 1 || active[0].Id != "sub_b" {
+		t.Errorf("active after disabling = %+v", active)
+	}
+
+	// A success resets the failure count
+	d4.Attempts, d4.LastStatusCode = 1, 502
+	if err := store.RecordWebhookFailure(ctx, d4, time.Now()); err != nil {
+		t.Fatalf("RecordWebhookFailure: %v", err)
+	}
+	d4.Attempts, d4.LastStatusCode = 2, 204
+	if err := store.RecordWebhookSuccess(ctx, d4); err != nil {
+		t.Fatalf("RecordWebhookSuccess: %v", err)
+	}
+	if sub, _ := store.GetWebhookSubscription(ctx, "sub_b"); sub == nil || sub.ConsecutiveFailures != 0 {
+		t.Errorf("after a success: %+v", sub)
+	}
+
+	// A claimed delivery is not handed out again until its outcome is recorded
+	d5 := queue(t, store, "sub_b", "q5")
+	if got := claim(10, time.Hour); got != "q5@sub_b" {
+		t.Errorf("claimed = %q", got)
+	}
+	if got := claim(10, time.Hour); got != "" {
+		t.Errorf("claimed again = %q, want nothing while leased", got)
+	}
+	d5.Attempts, d5.LastStatusCode = 1, 503
+	if err := store.RecordWebhookFailure(ctx, d5, time.Now()); err != nil {
+		t.Fatalf("RecordWebhookFailure: %v", err)
+	}
+	if got := due(10); got != "q5@sub_b" {
+		t.Errorf("due after the retry was recorded = %q", got)
+	}
+}
@@ -0,0 +1,258 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Ward-R/Jishin-API/types"
+	"github.com/jackc/pgx/v4"
+)
+
+const webhookSubscriptionColumns = `id, url, secret, min_magnitude, min_intensity, prefectures,
+            tsunami_only, active, consecutive_failures, disabled_at, created_at`
+
+func scanWebhookSubscription(row pgx.Row, sub *types.WebhookSubscription) error {
+	return row.Scan(&sub.Id, &sub.URL, &sub.Secret, &sub.MinMagnitude, &sub.MinIntensity, &sub.Prefectures,
+		&sub.TsunamiOnly, &sub.Active, &sub.ConsecutiveFailures, &sub.DisabledAt, &sub.CreatedAt)
+}
+
+// InsertWebhookSubscription stores a new, active subscription and fills in its
+// creation time.
+func InsertWebhookSubscription(ctx context.Context, conn DB, sub *types.WebhookSubscription) error {
+	query := `
+        INSERT INTO webhook_subscriptions (
+            id, url, secret, min_magnitude, min_intensity, prefectures, tsunami_only
+        ) VALUES ($1, $2, $3, $4, $5, $6, $7)
+        RETURNING active, created_at`
+
+	prefectures := sub.Prefectures
+	if prefectures == nil {
+		prefectures = []string{}
+	}
+	err := conn.QueryRow(ctx, query,
+		sub.Id, sub.URL, sub.Secret, sub.MinMagnitude, sub.MinIntensity, prefectures, sub.TsunamiOnly,
+	).Scan(&sub.Active, &sub.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("error inserting webhook subscription: %w", err)
+	}
+	return nil
+}
+
+// GetWebhookSubscription returns one subscription. It wraps pgx.ErrNoRows when
+// there is none.
+func GetWebhookSubscription(ctx context.Context, conn DB, id string) (*types.WebhookSubscription, error) {
+	query := `SELECT ` + webhookSubscriptionColumns + ` FROM webhook_subscriptions WHERE id = $1`
+
+	var sub types.WebhookSubscription
+	if err := scanWebhookSubscription(conn.QueryRow(ctx, query, id), &sub); err != nil {
+		return nil, fmt.Errorf("error querying webhook subscription %s: %w", id, err)
+	}
+	return &sub, nil
+}
+
+// GetActiveWebhookSubscriptions returns every subscription still receiving
+// deliveries.
+func GetActiveWebhookSubscriptions(ctx context.Context, conn DB) ([]types.WebhookSubscription, error) {
+	query := `SELECT ` + webhookSubscriptionColumns + ` FROM webhook_subscriptions WHERE active`
+
+	rows, err := conn.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("error querying webhook subscriptions: %w", err)
+	}
+	defer rows.Close()
+
+	var subs []types.WebhookSubscription
+	for rows.Next() {
+		var sub types.WebhookSubscription
+		if err := scanWebhookSubscription(rows, &sub); err != nil {
+			return nil, fmt.Errorf("error scanning webhook subscription: %w", err)
+		}
+		subs = append(subs, sub)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading webhook subscriptions: %w", err)
+	}
+	return subs, nil
+}
+
+// DeleteWebhookSubscription removes a subscription and its delivery log,
+// reporting whether it existed.
+func DeleteWebhookSubscription(ctx context.Context, conn DB, id string) (bool, error) {
+	tag, err := conn.Exec(ctx, `DELETE FROM webhook_subscriptions WHERE id = $1`, id)
+	if err != nil {
+		return false, fmt.Errorf("error deleting webhook subscription: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// InsertWebhookDelivery queues a delivery for its first attempt.
+func InsertWebhookDelivery(ctx context.Context, conn DB, delivery *types.WebhookDelivery) error {
+	query := `
+        INSERT INTO webhook_deliveries (subscription_id, event_type, report_id, payload)
+        VALUES ($1, $2, $3, $4)
+        RETURNING id, status, next_attempt_at, created_at`
+
+	err := conn.QueryRow(ctx, query,
+		delivery.SubscriptionId, delivery.EventType, delivery.ReportId, delivery.Payload,
+	).Scan(&delivery.Id, &delivery.Status, &delivery.NextAttemptAt, &delivery.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("error inserting webhook delivery: %w", err)
+	}
+	return nil
+}
+
+// DueWebhookDelivery is a pending delivery with where and how to send it.
+type DueWebhookDelivery struct {
+	types.WebhookDelivery
+	URL    string
+	Secret string
+}
+
+// ClaimDueWebhookDeliveries returns up to limit pending deliveries of active
+// subscriptions whose next attempt is due, oldest first, and leases them by
+// pushing their next attempt lease ahead. Rows locked by a concurrent claim are
+// skipped, so two runs never send the same delivery; one that dies before
+// recording the outcome leaves it to be retried once the lease is up.
+func ClaimDueWebhookDeliveries(ctx context.Context, conn DB, limit int, lease time.Duration) ([]DueWebhookDelivery, error) {
+	query := `
+          WITH due AS (
+              SELECT d.id
+              FROM webhook_deliveries d
+              JOIN webhook_subscriptions s ON s.id = d.subscription_id
+              WHERE d.status = 'pending' AND d.next_attempt_at <= NOW() AND s.active
+              ORDER BY d.next_attempt_at, d.id
+              LIMIT $1
+              FOR UPDATE OF d SKIP LOCKED
+          ), claimed AS (
+              UPDATE webhook_deliveries d SET next_attempt_at = NOW() + $2 * INTERVAL '1 second'
+              FROM due
+              WHERE d.id = due.id
+              RETURNING d.id, d.subscription_id, d.event_type, d.report_id, d.payload, d.status,
+                        d.attempts, d.next_attempt_at, d.created_at
+          )
+          SELECT c.id, c.subscription_id, c.event_type, c.report_id, c.payload, c.status,
+                 c.attempts, c.next_attempt_at, c.created_at, s.url, s.secret
+          FROM claimed c
+          JOIN webhook_subscriptions s ON s.id = c.subscription_id
+          ORDER BY c.id`
+
+	rows, err := conn.Query(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("error claiming due webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	var deliveries []DueWebhookDelivery
+	for rows.Next() {
+		var d DueWebhookDelivery
+		err := rows.Scan(&d.Id, &d.SubscriptionId, &d.EventType, &d.ReportId, &d.Payload, &d.Status,
+			&d.Attempts, &d.NextAttemptAt, &d.CreatedAt, &d.URL, &d.Secret)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning webhook delivery: %w", err)
+		}
+		deliveries = append(deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading webhook deliveries: %w", err)
+	}
+	return deliveries, nil
+}
+
+// RecordWebhookSuccess marks a delivery delivered and resets its subscription's
+// failure count.
+func RecordWebhookSuccess(ctx context.Context, conn DB, delivery *types.WebhookDelivery) error {
+	query := `
+        WITH delivered AS (
+            UPDATE webhook_deliveries SET
+                status = 'delivered', attempts = $2, last_status_code = $3,
+                last_error = '', delivered_at = NOW()
+            WHERE id = $1
+            RETURNING subscription_id
+        )
+        UPDATE webhook_subscriptions SET consecutive_failures = 0
+        WHERE id IN (SELECT subscription_id FROM delivered)`
+
+	_, err := conn.Exec(ctx, query, delivery.Id, delivery.Attempts, delivery.LastStatusCode)
+	if err != nil {
+		return fmt.Errorf("error recording webhook delivery: %w", err)
+	}
+	return nil
+}
+
+// RecordWebhookFailure records a failed attempt. A zero nextAttempt gives up on
+// the delivery and disables the subscription, failing its other pending
+// deliveries too.
+func RecordWebhookFailure(ctx context.Context, conn DB, delivery *types.WebhookDelivery, nextAttempt time.Time) error {
+	status := types.DeliveryPending
+	if nextAttempt.IsZero() {
+		status = types.DeliveryFailed
+	}
+	query := `
+        WITH failed AS (
+            UPDATE webhook_deliveries SET
+                status = $2, attempts = $3, next_attempt_at = COALESCE($4, next_attempt_at),
+                last_status_code = $5, last_error = $6
+            WHERE id = $1
+            RETURNING subscription_id
+        )
+        UPDATE webhook_subscriptions SET
+            consecutive_failures = consecutive_failures + 1,
+            active = active AND $2 <> 'failed',
+            disabled_at = CASE WHEN $2 = 'failed' THEN NOW() ELSE disabled_at END
+        WHERE id IN (SELECT subscription_id FROM failed)`
+
+	var next *time.Time
+	if !nextAttempt.IsZero() {
+		next = &nextAttempt
+	}
+	_, err := conn.Exec(ctx, query,
+		delivery.Id, status, delivery.Attempts, next, delivery.LastStatusCode, delivery.LastError)
+	if err != nil {
+		return fmt.Errorf("error recording webhook failure: %w", err)
+	}
+	if status != types.DeliveryFailed {
+		return nil
+	}
+
+	_, err = conn.Exec(ctx, `
+        UPDATE webhook_deliveries SET status = 'failed', last_error = 'subscription disabled'
+        WHERE subscription_id = $1 AND status = 'pending'`, delivery.SubscriptionId)
+	if err != nil {
+		return fmt.Errorf("error failing pending webhook deliveries: %w", err)
+	}
+	return nil
+}
+
+// GetWebhookDeliveries returns the latest deliveries of a subscription, newest
+// first.
+func GetWebhookDeliveries(ctx context.Context, conn DB, subscriptionID string, limit int) ([]types.WebhookDelivery, error) {
+	query := `
+          SELECT id, subscription_id, event_type, report_id, status, attempts, next_attempt_at,
+                 COALESCE(last_status_code, 0), last_error, created_at, delivered_at
+          FROM webhook_deliveries
+          WHERE subscription_id = $1
+          ORDER BY id DESC
+          LIMIT $2`
+
+	rows, err := conn.Query(ctx, query, subscriptionID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error querying webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	deliveries := []types.WebhookDelivery{}
+	for rows.Next() {
+		var d types.WebhookDelivery
+		err := rows.Scan(&d.Id, &d.SubscriptionId, &d.EventType, &d.ReportId, &d.Status, &d.Attempts,
+			&d.NextAttemptAt, &d.LastStatusCode, &d.LastError, &d.CreatedAt, &d.DeliveredAt)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning webhook delivery: %w", err)
+		}
+		deliveries = append(deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading webhook deliveries: %w", err)
+	}
+	return deliveries, nil
+}
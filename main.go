@@ -2,72 +2,363 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
-	"strings"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/Ward-R/Jishin-API/api"
 	"github.com/Ward-R/Jishin-API/db"
 	"github.com/Ward-R/Jishin-API/service"
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
-	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/joho/godotenv"
 )
 
-// Global db connection for lambda invocations
-var dbConn *pgx.Conn
+// Global connection pool, shared by lambda invocations and http requests
+var dbPool *pgxpool.Pool
 
-func init() {
-	// Initialize database connection once
+// Store the sync and handlers read and write through, backed by dbPool
+var store db.EarthquakeStore
+
+// Client the syncs fetch JMA reports with, configured from the flags
+var jma *service.JMAClient
+
+// Router shared by the lambda and http modes, built once the db is connected
+var router *api.Router
+
+// setup connects to the database, brings its schema up to date unless
+// autoMigrate is off, and syncs with JMA once per process.
+func setup(autoMigrate bool) {
+	// Initialize the connection pool once
 	var err error
-	dbConn, err = db.Connect()
+	dbPool, err = db.Connect(context.Background())
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
-	
+	store = db.NewPostgresStore(dbPool)
+
+	// The migration lock makes concurrent cold starts apply each migration once
+	if autoMigrate {
+		applied, err := db.Migrate(context.Background(), dbPool)
+		for _, m := range applied {
+			log.Printf("Applied migration %d (%s)", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatalf("Failed to migrate database: %v", err)
+		}
+	}
+
 	// Sync earthquake data on startup
 	log.Println("Syncing earthquake data from JMA on startup")
-	recordsAdded, err := service.SyncEarthquakes(dbConn)
+	result, err := service.SyncEarthquakes(context.Background(), store, jma)
 	if err != nil {
 		log.Printf("Error syncing earthquake data: %v", err)
 	} else {
-		log.Printf("Startup sync complete: added %d new earthquake records", recordsAdded)
+		log.Printf("Startup sync complete: added %d new, updated %d and retracted %d earthquake records",
+			result.RecordsAdded, result.RecordsUpdated, result.RecordsRetracted)
+		logSyncTimings(result)
 	}
+
+	router = api.NewAPIRouter(store, jma)
 }
 
-func HandleRequest(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	// Route based on path and method
-	path := request.Path
-	method := request.HTTPMethod
+// loadDotEnv loads .env, where local development keeps DATABASE_URL. It is fine
+// if there is none.
+func loadDotEnv() {
+	if err := godotenv.Load(); err == nil {
+		log.Println("Loaded environment from .env")
+	}
+}
 
+// runMigrate implements the migrate subcommand:
+//
+//	migrate [up]        apply every pending migration
+//	migrate down [n]    revert the last n migrations (default 1)
+//	migrate to VERSION  migrate up or down to VERSION
+//	migrate status      print the current version and pending migrations
+func runMigrate(args []string) {
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
 	switch {
-	// Simple routes
-	case path == "/" && method == "GET":
-		return api.HandleRoot(dbConn)
-	case path == "/health" && method == "GET":
-		return api.HandleHealth(dbConn)
-	case path == "/earthquakes/stats" && method == "GET":
-		return api.HandleStats(dbConn)
-	case path == "/earthquakes/recent" && method == "GET":
-		return api.HandleRecent(dbConn)
-	case path == "/earthquakes/largest/today" && method == "GET":
-		return api.HandleLargestToday(dbConn)
-	case path == "/earthquakes/largest/week" && method == "GET":
-		return api.HandleLargestWeek(dbConn)
-	// Complex routes
-	case path == "/earthquakes" && method == "GET":
-		return api.HandleEarthquakes(dbConn, request) // Needs ?Limit=X&magnitude=Y
-	case strings.HasPrefix(path, "/earthquake/") && method == "GET":
-		return api.HandleEarthquakeById(dbConn, request) // Needs ID from path
-	case path == "/sync" && method == "POST":
-		return api.HandleSync(dbConn)
-	}
-
-	return events.APIGatewayProxyResponse{
-		StatusCode: 404,
-		Body:       `{"error": "Not found"}`,
-	}, nil
+	case command == "up" && len(args) <= 1:
+	case command == "down" && len(args) <= 2:
+	case (command == "to" && len(args) == 2) || (command == "status" && len(args) == 1):
+	default:
+		log.Fatalf("Usage: migrate [up | down [n] | to VERSION | status]")
+	}
+
+	ctx := context.Background()
+	pool, err := db.Connect(ctx)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer pool.Close()
+
+	migrations, err := db.Migrations()
+	if err != nil {
+		log.Fatalf("Invalid migrations: %v", err)
+	}
+	current, err := db.SchemaVersion(ctx, pool)
+	if err != nil {
+		log.Fatalf("Failed to read schema version: %v", err)
+	}
+
+	target := len(migrations)
+	switch command {
+	case "down":
+		steps := 1
+		if len(args) == 2 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				log.Fatalf("Invalid number of migrations to revert: %q", args[1])
+			}
+		}
+		target = current - steps
+		if target < 0 {
+			target = 0
+		}
+	case "to":
+		if target, err = strconv.Atoi(args[1]); err != nil {
+			log.Fatalf("Invalid migration version: %q", args[1])
+		}
+	case "status":
+		fmt.Printf("Schema version %d of %d\n", current, len(migrations))
+		for _, m := range migrations {
+			state := "pending"
+			if m.Version <= current {
+				state = "applied"
+			}
+			fmt.Printf("  %04d %-30s %s\n", m.Version, m.Name, state)
+		}
+		return
+	}
+
+	ran, err := db.MigrateTo(ctx, pool, target)
+	for _, m := range ran {
+		if m.Version > target {
+			log.Printf("Reverted migration %d (%s)", m.Version, m.Name)
+		} else {
+			log.Printf("Applied migration %d (%s)", m.Version, m.Name)
+		}
+	}
+	if err != nil {
+		log.Fatalf("Migration failed: %v", err)
+	}
+	log.Printf("Schema is at version %d", target)
+}
+
+// envOrDefault returns the environment variable key, or fallback when unset.
+func envOrDefault(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// logSyncTimings logs how much of list.json a sync fetched and how long each
+// phase took.
+func logSyncTimings(result *service.SyncResult) {
+	t := result.Timings
+	log.Printf("Fetched %d of %d listed reports (%d failed): list %s, diff %s, fetch %s, apply %s, total %s",
+		result.ReportsFetched, result.ReportsListed, len(result.Errors),
+		t.List.Round(time.Millisecond), t.Diff.Round(time.Millisecond), t.Fetch.Round(time.Millisecond),
+		t.Apply.Round(time.Millisecond), t.Total.Round(time.Millisecond))
+}
+
+// syncPeriodically syncs with JMA every interval until ctx is cancelled, so the
+// standalone server keeps its data and event stream fresh without POST /sync.
+func syncPeriodically(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			result, err := service.SyncEarthquakes(ctx, store, jma)
+			if err != nil {
+				log.Printf("Error syncing earthquake data: %v", err)
+				continue
+			}
+			if result.RecordsAdded+result.RecordsUpdated+result.RecordsRetracted > 0 {
+				log.Printf("Periodic sync complete: added %d new, updated %d and retracted %d earthquake records",
+					result.RecordsAdded, result.RecordsUpdated, result.RecordsRetracted)
+				logSyncTimings(result)
+			}
+		}
+	}
+}
+
+// deliverWebhooks attempts the webhook deliveries that are due and logs the
+// outcome.
+func deliverWebhooks(ctx context.Context) (*service.WebhookResult, error) {
+	result, err := service.DeliverWebhooks(ctx, store)
+	if err != nil {
+		log.Printf("Error delivering webhooks: %v", err)
+		return nil, err
+	}
+	if result.Delivered+result.Retrying+result.Failed > 0 {
+		log.Printf("Webhooks: delivered %d, retrying %d and failed %d", result.Delivered, result.Retrying, result.Failed)
+	}
+	return result, nil
+}
+
+// deliverWebhooksPeriodically delivers due webhooks every interval until ctx is
+// cancelled, independently of the syncs that queue them.
+func deliverWebhooksPeriodically(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deliverWebhooks(ctx)
+		}
+	}
+}
+
+// scheduledEvent holds the fields that tell an EventBridge scheduled event
+// apart from an API Gateway request.
+type scheduledEvent struct {
+	Source     string `json:"source"`
+	DetailType string `json:"detail-type"`
+}
+
+// handleLambda serves API Gateway requests through the router. An EventBridge
+// schedule invokes the same function to deliver the due webhooks, since a
+// Lambda has no background ticker.
+func handleLambda(ctx context.Context, payload json.RawMessage) (any, error) {
+	var scheduled scheduledEvent
+	if err := json.Unmarshal(payload, &scheduled); err == nil && scheduled.Source == "aws.events" {
+		return deliverWebhooks(ctx)
+	}
+	var request events.APIGatewayProxyRequest
+	if err := json.Unmarshal(payload, &request); err != nil {
+		return nil, fmt.Errorf("error decoding lambda event: %w", err)
+	}
+	return api.LambdaHandler(router.ServeRequest)(ctx, request)
+}
+
+// serveHTTP runs the standalone net/http server until SIGINT or SIGTERM.
+func serveHTTP(addr string, syncInterval, webhookInterval time.Duration) {
+	api.RegisterStreamRoutes(router, service.Events)
+	hub := api.NewHub(service.Events)
+	go hub.Run()
+
+	// WebSockets take over the raw connection, so they bypass the router
+	mux := http.NewServeMux()
+	mux.Handle("/ws/earthquakes", hub)
+	mux.Handle("/", api.HTTPHandler(router.ServeRequest))
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      60 * time.Second,
+		IdleTimeout:       120 * time.Second,
+	}
+
+	go func() {
+		log.Printf("Server starting on %s", addr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Server failed: %v", err)
+		}
+	}()
+
+	syncCtx, stopSync := context.WithCancel(context.Background())
+	if syncInterval > 0 {
+		log.Printf("Syncing with JMA every %s", syncInterval)
+		go syncPeriodically(syncCtx, syncInterval)
+	}
+	if webhookInterval > 0 {
+		go deliverWebhooksPeriodically(syncCtx, webhookInterval)
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+
+	log.Println("Shutting down server")
+	stopSync()
+	// Shutdown does not wait for hijacked connections
+	hub.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Error during shutdown: %v", err)
+	}
+	dbPool.Close()
 }
 
 func main() {
-	lambda.Start(HandleRequest)
+	mode := flag.String("mode", envOrDefault("JISHIN_MODE", "lambda"), "run mode: lambda or http (env JISHIN_MODE)")
+	addr := flag.String("addr", ":"+envOrDefault("PORT", "8080"), "listen address for http mode (env PORT)")
+	defaultSyncInterval, err := time.ParseDuration(envOrDefault("SYNC_INTERVAL", "1m"))
+	if err != nil {
+		log.Fatalf("Invalid SYNC_INTERVAL: %v", err)
+	}
+	syncInterval := flag.Duration("sync-interval", defaultSyncInterval, "how often http mode syncs with JMA, 0 to disable (env SYNC_INTERVAL)")
+	defaultWebhookInterval, err := time.ParseDuration(envOrDefault("WEBHOOK_INTERVAL", "30s"))
+	if err != nil {
+		log.Fatalf("Invalid WEBHOOK_INTERVAL: %v", err)
+	}
+	webhookInterval := flag.Duration("webhook-interval", defaultWebhookInterval, "how often http mode delivers due webhooks, 0 to disable (env WEBHOOK_INTERVAL)")
+	defaultAutoMigrate, err := strconv.ParseBool(envOrDefault("AUTO_MIGRATE", "true"))
+	if err != nil {
+		log.Fatalf("Invalid AUTO_MIGRATE: %v", err)
+	}
+	autoMigrate := flag.Bool("auto-migrate", defaultAutoMigrate, "apply pending schema migrations on startup (env AUTO_MIGRATE)")
+	jmaBaseURL := flag.String("jma-base-url", envOrDefault("JMA_BASE_URL", service.DefaultJMABaseURL), "where list.json and the detail reports are fetched from (env JMA_BASE_URL)")
+	defaultJMATimeout, err := time.ParseDuration(envOrDefault("JMA_TIMEOUT", "15s"))
+	if err != nil {
+		log.Fatalf("Invalid JMA_TIMEOUT: %v", err)
+	}
+	jmaTimeout := flag.Duration("jma-timeout", defaultJMATimeout, "timeout for each request to JMA (env JMA_TIMEOUT)")
+	defaultJMAConcurrency, err := strconv.Atoi(envOrDefault("JMA_CONCURRENCY", "8"))
+	if err != nil {
+		log.Fatalf("Invalid JMA_CONCURRENCY: %v", err)
+	}
+	jmaConcurrency := flag.Int("jma-concurrency", defaultJMAConcurrency, "detail reports a sync fetches at once (env JMA_CONCURRENCY)")
+	defaultJMAInterval, err := time.ParseDuration(envOrDefault("JMA_REQUEST_INTERVAL", "50ms"))
+	if err != nil {
+		log.Fatalf("Invalid JMA_REQUEST_INTERVAL: %v", err)
+	}
+	jmaInterval := flag.Duration("jma-request-interval", defaultJMAInterval, "least time between two requests to JMA (env JMA_REQUEST_INTERVAL)")
+	flag.Parse()
+
+	jma = service.NewJMAClient(*jmaBaseURL)
+	jma.HTTPClient.Timeout = *jmaTimeout
+	jma.Concurrency = *jmaConcurrency
+	jma.MinInterval = *jmaInterval
+
+	if flag.Arg(0) == "migrate" {
+		loadDotEnv()
+		runMigrate(flag.Args()[1:])
+		return
+	}
+
+	switch *mode {
+	case "lambda":
+		setup(*autoMigrate)
+		lambda.Start(handleLambda)
+	case "http":
+		loadDotEnv()
+		log.Println("Starting Jishin API...")
+		setup(*autoMigrate)
+		serveHTTP(*addr, *syncInterval, *webhookInterval)
+	default:
+		log.Fatalf("Unknown mode %q: expected lambda or http", *mode)
+	}
 }
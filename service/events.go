@@ -0,0 +1,113 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Ward-R/Jishin-API/types"
+)
+
+// Event types published when a sync changes an earthquake.
+const (
+	EventCreated   = "earthquake.created"
+	EventUpdated   = "earthquake.updated"
+	EventRetracted = "earthquake.retracted"
+)
+
+// defaultEventHistory is how many events the default broker keeps for clients
+// resuming a stream.
+const defaultEventHistory = 500
+
+// subscriberBuffer is how many events a subscriber may fall behind before it is
+// dropped.
+const subscriberBuffer = 64
+
+// Event is a change to an earthquake made by SyncEarthquakes.
+type Event struct {
+	// ID increases with every event. IDs are derived from the clock, so they
+	// keep increasing across restarts.
+	ID         uint64
+	Type       string
+	Earthquake types.Earthquake
+}
+
+// Broker fans events out to in-process subscribers and keeps a ring buffer of
+// the latest ones so a client can resume where it left off.
+type Broker struct {
+	mu          sync.Mutex
+	lastID      uint64
+	history     []Event
+	next        int
+	subscribers map[chan Event]struct{}
+}
+
+// NewBroker returns a broker that remembers the last historySize events.
+func NewBroker(historySize int) *Broker {
+	return &Broker{
+		history:     make([]Event, 0, historySize),
+		subscribers: map[chan Event]struct{}{},
+	}
+}
+
+// Events is the broker SyncEarthquakes publishes to.
+var Events = NewBroker(defaultEventHistory)
+
+// Publish records an event and delivers it to every subscriber. A subscriber
+// whose buffer is full is dropped; its channel is closed so it can reconnect
+// and catch up from the history.
+func (b *Broker) Publish(eventType string, eq *types.Earthquake) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	id := uint64(time.Now().UnixNano())
+	if id <= b.lastID {
+		id = b.lastID + 1
+	}
+	b.lastID = id
+	event := Event{ID: id, Type: eventType, Earthquake: *eq}
+	event.Earthquake.Stations = nil
+
+	if len(b.history) < cap(b.history) {
+		b.history = append(b.history, event)
+	} else if cap(b.history) > 0 {
+		b.history[b.next] = event
+		b.next = (b.next + 1) % cap(b.history)
+	}
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+	return event
+}
+
+// Subscribe registers a subscriber. It returns the remembered events newer
+// than lastID, oldest first, and a channel of the events published from then
+// on. cancel must be called once the subscriber is done.
+func (b *Broker) Subscribe(lastID uint64) (replay []Event, events <-chan Event, cancel func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for i := range b.history {
+		event := b.history[(b.next+i)%len(b.history)]
+		if event.ID > lastID {
+			replay = append(replay, event)
+		}
+	}
+
+	ch := make(chan Event, subscriberBuffer)
+	b.subscribers[ch] = struct{}{}
+	cancel = func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subscribers[ch]; ok {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+	return replay, ch, cancel
+}
@@ -0,0 +1,233 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultJMABaseURL is where JMA publishes list.json and the detail reports it
+// points to.
+const DefaultJMABaseURL = "https://www.jma.go.jp/bosai/quake/data/"
+
+// Defaults used by NewJMAClient.
+const (
+	defaultJMATimeout     = 15 * time.Second
+	defaultJMAMaxRetries  = 3
+	defaultJMABackoff     = 500 * time.Millisecond
+	defaultJMAMaxBackoff  = 10 * time.Second
+	defaultJMAConcurrency = 8
+	defaultJMAMinInterval = 50 * time.Millisecond
+	// maxJMAResponse caps a response body; list.json is well under 1 MB.
+	maxJMAResponse = 16 << 20
+)
+
+// JMAClient fetches earthquake reports from JMA, or from a mirror or stub that
+// serves the same files under BaseURL.
+type JMAClient struct {
+	// BaseURL is the directory holding list.json and the detail reports.
+	BaseURL string
+	// HTTPClient sends the requests. Its Timeout bounds each attempt.
+	HTTPClient *http.Client
+	UserAgent  string
+	// MaxRetries is how often a request that fails with a network error, 429
+	// or 5xx is retried. Backoff is the delay before the first retry; it
+	// doubles with every further retry up to MaxBackoff. A Retry-After header
+	// from the server is honoured up to MaxBackoff too.
+	MaxRetries int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Concurrency is how many detail reports a sync fetches at once.
+	Concurrency int
+	// MinInterval is the least time between two requests, retries included,
+	// however many fetches are running.
+	MinInterval time.Duration
+
+	mu          sync.Mutex
+	nextRequest time.Time
+}
+
+// NewJMAClient returns a client for baseURL with the default timeout and
+// retry policy.
+func NewJMAClient(baseURL string) *JMAClient {
+	return &JMAClient{
+		BaseURL:     baseURL,
+		HTTPClient:  &http.Client{Timeout: defaultJMATimeout},
+		UserAgent:   "Jishin-API/1.0 (+https://github.com/Ward-R/Jishin-API)",
+		MaxRetries:  defaultJMAMaxRetries,
+		Backoff:     defaultJMABackoff,
+		MaxBackoff:  defaultJMAMaxBackoff,
+		Concurrency: defaultJMAConcurrency,
+		MinInterval: defaultJMAMinInterval,
+	}
+}
+
+// JMAStatusError is returned when JMA answers with a non-2xx status.
+type JMAStatusError struct {
+	URL        string
+	StatusCode int
+	// RetryAfter is the delay the server asked for, if any.
+	RetryAfter time.Duration
+}
+
+func (e *JMAStatusError) Error() string {
+	return fmt.Sprintf("%s responded with status code %d", e.URL, e.StatusCode)
+}
+
+// FetchQuakeList fetches list.json, the summaries of the latest reports.
+func (c *JMAClient) FetchQuakeList(ctx context.Context) ([]byte, error) {
+	body, err := c.get(ctx, "list.json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch earthquake list: %w", err)
+	}
+	return body, nil
+}
+
+// FetchDetail fetches one detail report, named as in the list's "json" field.
+func (c *JMAClient) FetchDetail(ctx context.Context, detailJSON string) ([]byte, error) {
+	body, err := c.get(ctx, detailJSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch detail %s: %w", detailJSON, err)
+	}
+	return body, nil
+}
+
+// Helper function:
+// get fetches name relative to BaseURL, retrying transient failures.
+func (c *JMAClient) get(ctx context.Context, name string) ([]byte, error) {
+	url := strings.TrimRight(c.BaseURL, "/") + "/" + strings.TrimLeft(name, "/")
+	for attempt := 0; ; attempt++ {
+		if err := c.waitTurn(ctx); err != nil {
+			return nil, err
+		}
+		body, err := c.getOnce(ctx, url)
+		if err == nil || attempt >= c.MaxRetries || !retryableJMAError(ctx, err) {
+			return body, err
+		}
+
+		delay := c.backoff(attempt)
+		var statusErr *JMAStatusError
+		if errors.As(err, &statusErr) && statusErr.RetryAfter > delay {
+			delay = min(statusErr.RetryAfter, c.MaxBackoff)
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Helper function:
+// waitTurn blocks until MinInterval has passed since the previous request was
+// let through, or ctx is done.
+func (c *JMAClient) waitTurn(ctx context.Context) error {
+	c.mu.Lock()
+	now := time.Now()
+	turn := c.nextRequest
+	if turn.Before(now) {
+		turn = now
+	}
+	c.nextRequest = turn.Add(c.MinInterval)
+	c.mu.Unlock()
+
+	delay := time.Until(turn)
+	if delay <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Helper function:
+// getOnce makes a single attempt at url.
+func (c *JMAClient) getOnce(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", c.UserAgent)
+	req.Header.Set("Accept", "application/json")
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+		return nil, &JMAStatusError{
+			URL:        url,
+			StatusCode: res.StatusCode,
+			RetryAfter: parseRetryAfter(res.Header.Get("Retry-After")),
+		}
+	}
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxJMAResponse+1))
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", url, err)
+	}
+	if len(body) > maxJMAResponse {
+		return nil, fmt.Errorf("%s is larger than %d bytes", url, maxJMAResponse)
+	}
+	return body, nil
+}
+
+// Helper function:
+// retryableJMAError reports whether a failed attempt is worth repeating:
+// network errors, rate limiting and server errors, unless ctx is done.
+func retryableJMAError(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var statusErr *JMAStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == http.StatusTooManyRequests || statusErr.StatusCode >= 500
+	}
+	return true
+}
+
+// Helper function:
+// backoff is the delay before retry number attempt+1.
+func (c *JMAClient) backoff(attempt int) time.Duration {
+	delay := c.Backoff
+	for i := 0; i < attempt && delay < c.MaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, c.MaxBackoff)
+}
+
+// Helper function:
+// parseRetryAfter reads a Retry-After header given in seconds or as an HTTP
+// date, returning 0 when it is absent or invalid.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return time.Until(at)
+	}
+	return 0
+}
@@ -0,0 +1,131 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testJMAClient returns a client for server that retries without waiting long.
+func testJMAClient(server *httptest.Server) *JMAClient {
+	jma := NewJMAClient(server.URL)
+	jma.HTTPClient = server.Client()
+	jma.Backoff = time.Millisecond
+	jma.MaxBackoff = 5 * time.Millisecond
+	jma.MinInterval = 0
+	return jma
+}
+
+func TestJMAClientRetriesServerErrors(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/list.json" {
+			t.Errorf("requested %s, want /list.json", r.URL.Path)
+		}
+		if r.UserAgent() == "" || r.UserAgent() == "Go-http-client/1.1" {
+			t.Errorf("request sent without our User-Agent: %q", r.UserAgent())
+		}
+		switch calls.Add(1) {
+		case 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			w.Write([]byte(`[]`))
+		}
+	}))
+	defer server.Close()
+
+	body, err := testJMAClient(server).FetchQuakeList(context.Background())
+	if err != nil {
+		t.Fatalf("FetchQuakeList: %v", err)
+	}
+	if string(body) != "[]" {
+		t.Errorf("body = %q, want []", body)
+	}
+	if n := calls.Load(); n != 3 {
+		t.Errorf("server saw %d requests, want 3", n)
+	}
+}
+
+func TestJMAClientGivesUp(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		calls  int32
+	}{
+		{"server error after retries", http.StatusBadGateway, 4},
+		{"not found without retrying", http.StatusNotFound, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls.Add(1)
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			_, err := testJMAClient(server).FetchDetail(context.Background(), "20250812113450_0_VXSE53_270000.json")
+			var statusErr *JMAStatusError
+			if !errors.As(err, &statusErr) || statusErr.StatusCode != tt.status {
+				t.Fatalf("err = %v, want a JMAStatusError with status %d", err, tt.status)
+			}
+			if n := calls.Load(); n != tt.calls {
+				t.Errorf("server saw %d requests, want %d", n, tt.calls)
+			}
+		})
+	}
+}
+
+func TestJMAClientStopsOnCancel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	jma := testJMAClient(server)
+	jma.Backoff, jma.MaxBackoff = time.Hour, time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := jma.FetchQuakeList(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("FetchQuakeList returned after %s, want it to stop when the context ends", elapsed)
+	}
+}
+
+func TestJMAClientSpacesRequests(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	jma := testJMAClient(server)
+	jma.MinInterval = 20 * time.Millisecond
+	start := time.Now()
+	done := make(chan error)
+	for i := 0; i < 5; i++ {
+		go func() {
+			_, err := jma.FetchDetail(context.Background(), "detail.json")
+			done <- err
+		}()
+	}
+	for i := 0; i < 5; i++ {
+		if err := <-done; err != nil {
+			t.Fatalf("FetchDetail: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Errorf("5 requests took %s, want at least 4 intervals of 20ms", elapsed)
+	}
+}
@@ -1,57 +1,20 @@
 package service
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/Ward-R/Jishin-API/db"
 	"github.com/Ward-R/Jishin-API/types"
-	"github.com/jackc/pgx/v4"
 )
 
-const (
-	JMAQuakeURL = "https://www.jma.go.jp/bosai/quake/data/list.json"
-)
-
-// fetchQuakeData fetches the list of earthquake summaries from the JMA.
-func FetchQuakeData() ([]byte, error) {
-	res, err := http.Get(JMAQuakeURL)
-	if err != nil {
-		return nil, fmt.Errorf("Failed to fetch data: %w", err)
-	}
-	defer res.Body.Close()
-
-	body, err := io.ReadAll(res.Body)
-	if res.StatusCode > 400 {
-		return nil, fmt.Errorf("Response failed with status code: %d", res.StatusCode)
-	}
-	return body, err
-}
-
-// fetchDetailQuakeData fetches the detailed JSON for a specific earthquake.
-func FetchDetailQuakeData(detailJSON string) ([]byte, error) {
-	rootURL := "https://www.jma.go.jp/bosai/quake/data/"
-	detailURL := rootURL + detailJSON
-	res, err := http.Get(detailURL)
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch data: %w", err)
-	}
-	defer res.Body.Close()
-
-	body, err := io.ReadAll(res.Body)
-	if res.StatusCode > 400 {
-		return nil, fmt.Errorf("Response failed with status code: %d", res.StatusCode)
-	}
-	return body, err
-}
-
 // Helper function:
 // parseCoordinate parses the concatenated coordinate string into lat, long, depth, and tsunami risk.
 func parseCoordinate(cod string) (latitude, longitude, depthKm float64, tsunamiRisk string, err error) {
@@ -107,6 +70,22 @@ func ParseDetailQuakeData(id string, data []byte) (*types.Earthquake, error) {
 	}
 
 	quake.ReportId = id
+	quake.InfoType = detailData.Head.InfoType
+
+	if detailData.Head.Serial != "" {
+		quake.Serial, err = strconv.Atoi(detailData.Head.Serial)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing serial: %w", err)
+		}
+	}
+
+	if detailData.Head.ReportDateTime != "" {
+		parsedReportTime, err := time.Parse(time.RFC3339, detailData.Head.ReportDateTime)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing report time: %w", err)
+		}
+		quake.ReportDateTime = parsedReportTime
+	}
 
 	if detailData.Body.Earthquake.OriginTime != "" {
 		parsedOriginTime, err := time.Parse(time.RFC3339, detailData.Body.Earthquake.OriginTime)
@@ -144,53 +123,457 @@ func ParseDetailQuakeData(id string, data []byte) (*types.Earthquake, error) {
 	quake.MaxIntensity = detailData.Body.Intensity.Observation.MaxIntensity
 	quake.JpComment = detailData.Body.Comments.ForecastComment.Text
 	quake.EnComment = detailData.Body.Comments.ForecastComment.EnText
+	quake.Tsunami = tsunamiExpected(detailData.Body.Comments.ForecastComment.Code)
+	quake.TsunamiWarning = tsunamiWarningInEffect(detailData.Body.Comments.ForecastComment.Code)
+	quake.Stations = parseStationObservations(id, detailData.Body.Intensity)
+	quake.Regions = parseRegionIntensities(id, detailData.Body.Intensity)
 
 	return quake, nil
 }
 
-// function syncs database with JMA earthquake data when called. If it exists,
-// in the db already it skips adding it based on recordID.
-func SyncEarthquakes(conn *pgx.Conn) (int, error) {
-	data, err := FetchQuakeData()
-	recordsAdded := 0
-	if err != nil {
-		return 0, fmt.Errorf("error fetching summary data: %w", err)
+// tsunamiCommentCodes are the JMA forecast comment codes (固定付加文) that warn of
+// a tsunami or sea-level change. 0215 ("no tsunami risk") is deliberately absent.
+var tsunamiCommentCodes = map[string]bool{
+	"0211": true, // tsunami warning or advisory in effect
+	"0212": true, // slight sea-level change possible, no damage expected
+	"0213": true, // sea-level change continuing, take care at the coast
+	"0214": true, // sea-level change continuing
+	"0216": true, // tsunami possible if the hypocenter is under the sea
+	"0221": true, // (overseas) widespread Pacific tsunami possible
+	"0222": true, // (overseas) Pacific tsunami possible
+	"0223": true, // (overseas) northwest Pacific tsunami possible
+	"0224": true, // (overseas) tsunami possible near the epicenter
+	"0225": true, // (overseas) small tsunami possible near the epicenter
+	"0227": true, // (overseas) tsunami to Japan under investigation
+}
+
+// Helper function:
+// tsunamiExpected reports whether any of the space-separated forecast comment
+// codes warns of a tsunami.
+func tsunamiExpected(codes string) bool {
+	for _, code := range strings.Fields(codes) {
+		if tsunamiCommentCodes[code] {
+			return true
+		}
 	}
+	return false
+}
+
+// Helper function:
+// tsunamiWarningInEffect reports whether the forecast comment codes include
+// 0211, a tsunami warning or advisory in effect. The other tsunami codes only
+// say a tsunami or sea-level change is possible.
+func tsunamiWarningInEffect(codes string) bool {
+	for _, code := range strings.Fields(codes) {
+		if code == "0211" {
+			return true
+		}
+	}
+	return false
+}
+
+// Helper function:
+// parseStationObservations flattens the Pref -> Area -> City -> IntensityStation
+// tree into one observation per station.
+func parseStationObservations(id string, intensity types.JsonIntensity) []types.StationObservation {
+	var stations []types.StationObservation
+	for _, pref := range intensity.Observation.Pref {
+		for _, area := range pref.Area {
+			for _, city := range area.City {
+				for _, station := range city.IntensityStation {
+					stations = append(stations, types.StationObservation{
+						ReportId:    id,
+						StationCode: station.Code,
+						JpName:      station.JpName,
+						EnName:      station.EnName,
+						Intensity:   station.Int,
+						Latitude:    station.LatLon.Lat,
+						Longitude:   station.LatLon.Lon,
+						PrefCode:    pref.Code,
+						AreaCode:    area.Code,
+						CityCode:    city.Code,
+					})
+				}
+			}
+		}
+	}
+	return stations
+}
+
+// Helper function:
+// parseRegionIntensities collects the max intensity JMA reported for every
+// prefecture, area and city in the observation tree.
+func parseRegionIntensities(id string, intensity types.JsonIntensity) []types.RegionIntensity {
+	var regions []types.RegionIntensity
+	for _, pref := range intensity.Observation.Pref {
+		regions = append(regions, types.RegionIntensity{
+			ReportId:     id,
+			Level:        types.RegionLevelPref,
+			Code:         pref.Code,
+			JpName:       pref.JpName,
+			EnName:       pref.EnName,
+			MaxIntensity: pref.MaxInt,
+		})
+		for _, area := range pref.Area {
+			regions = append(regions, types.RegionIntensity{
+				ReportId:     id,
+				Level:        types.RegionLevelArea,
+				Code:         area.Code,
+				ParentCode:   pref.Code,
+				JpName:       area.JpName,
+				EnName:       area.EnName,
+				MaxIntensity: area.MaxInt,
+			})
+			for _, city := range area.City {
+				regions = append(regions, types.RegionIntensity{
+					ReportId:     id,
+					Level:        types.RegionLevelCity,
+					Code:         city.Code,
+					ParentCode:   area.Code,
+					JpName:       city.JpName,
+					EnName:       city.EnName,
+					MaxIntensity: city.MaxInt,
+				})
+			}
+		}
+	}
+	return regions
+}
+
+// Helper function:
+// newRevision snapshots the fields of a report that clients compare between revisions.
+func newRevision(quake *types.Earthquake) *types.Revision {
+	return &types.Revision{
+		EventId:        quake.ReportId,
+		Serial:         quake.Serial,
+		InfoType:       quake.InfoType,
+		ReportDateTime: quake.ReportDateTime,
+		OriginTime:     quake.OriginTime,
+		Magnitude:      quake.Magnitude,
+		DepthKm:        quake.DepthKm,
+		Latitude:       quake.Latitude,
+		Longitude:      quake.Longitude,
+		MaxIntensity:   quake.MaxIntensity,
+		JpLocation:     quake.JpLocation,
+		EnLocation:     quake.EnLocation,
+	}
+}
+
+// Helper function:
+// isNewerReport reports whether candidate supersedes the report currently stored.
+// JMA restarts serials per report kind, so the report time is compared first.
+func isNewerReport(candidate, current *types.Earthquake) bool {
+	if !candidate.ReportDateTime.Equal(current.ReportDateTime) {
+		return candidate.ReportDateTime.After(current.ReportDateTime)
+	}
+	return candidate.Serial > current.Serial
+}
+
+// Helper function:
+// mergeReport returns the stored earthquake brought up to date with a newer
+// report. JMA issues several kinds of report per event: the intensity flash
+// (VXSE51) has no hypocenter or magnitude, the hypocenter report (VXSE52) no
+// intensity, so fields the report does not carry keep their stored value.
+func mergeReport(current, report *types.Earthquake) *types.Earthquake {
+	merged := *report
+	if report.OriginTime.IsZero() {
+		merged.OriginTime = current.OriginTime
+	}
+	if report.ArrivalTime.IsZero() {
+		merged.ArrivalTime = current.ArrivalTime
+	}
+	if report.Latitude == 0 && report.Longitude == 0 && report.JpLocation == "" && report.EnLocation == "" {
+		merged.Latitude, merged.Longitude, merged.DepthKm = current.Latitude, current.Longitude, current.DepthKm
+		merged.JpLocation, merged.EnLocation, merged.TsunamiRisk = current.JpLocation, current.EnLocation, current.TsunamiRisk
+	}
+	if report.Magnitude == 0 {
+		merged.Magnitude = current.Magnitude
+	}
+	if report.MaxIntensity == "" {
+		merged.MaxIntensity = current.MaxIntensity
+	}
+	if report.JpComment == "" && report.EnComment == "" {
+		merged.JpComment, merged.EnComment = current.JpComment, current.EnComment
+		merged.Tsunami, merged.TsunamiWarning = current.Tsunami, current.TsunamiWarning
+	}
+	return &merged
+}
+
+// SyncResult counts what a sync changed in the database.
+type SyncResult struct {
+	RecordsAdded     int
+	RecordsUpdated   int
+	RecordsRetracted int
+	// ReportsListed is the number of reports in list.json, ReportsFetched the
+	// number of those that were new or newer than the stored earthquake.
+	ReportsListed  int
+	ReportsFetched int
+	// Errors holds the reports that could not be fetched, parsed or stored.
+	// The sync carries on without them.
+	Errors  []*SyncError
+	Timings SyncTimings
+}
+
+// SyncError is a report the sync had to skip.
+type SyncError struct {
+	ReportID   string
+	DetailJSON string
+	Err        error
+}
+
+func (e *SyncError) Error() string {
+	return fmt.Sprintf("report %s (%s): %v", e.ReportID, e.DetailJSON, e.Err)
+}
+
+func (e *SyncError) Unwrap() error {
+	return e.Err
+}
+
+// SyncTimings is how long each phase of a sync took: fetching list.json,
+// comparing it with the store, fetching the detail reports and applying them.
+type SyncTimings struct {
+	List  time.Duration
+	Diff  time.Duration
+	Fetch time.Duration
+	Apply time.Duration
+	Total time.Duration
+}
+
+// fetchedReport is a detail report fetched and parsed by the worker pool.
+type fetchedReport struct {
+	summary    types.QuakeSummary
+	earthquake *types.Earthquake
+	err        error
+}
 
+// function syncs database with JMA earthquake data when called. The list is
+// compared with the stored report times in one query, and only reports for new
+// events or newer than the stored one are fetched, jma.Concurrency at a time.
+// Every fetched report is kept in the revision history; the earthquake row is
+// inserted for new events, overwritten when a newer report for a known event
+// arrives and retracted when JMA cancels the event. Each change is published
+// to Events and queued for the webhook subscriptions it matches.
+func SyncEarthquakes(ctx context.Context, store db.EarthquakeStore, jma *JMAClient) (*SyncResult, error) {
+	result := &SyncResult{}
+	start := time.Now()
+	defer func() { result.Timings.Total = time.Since(start) }()
+
+	data, err := jma.FetchQuakeList(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching summary data: %w", err)
+	}
 	events, err := ParseQuakeData(data)
 	if err != nil {
-		return 0, fmt.Errorf("error parsing summary data: %w", err)
+		return nil, fmt.Errorf("error parsing summary data: %w", err)
 	}
+	result.ReportsListed = len(events)
+	result.Timings.List = time.Since(start)
 
+	phase := time.Now()
+	ids := make([]string, 0, len(events))
 	for _, event := range events {
-		detailData, err := FetchDetailQuakeData(event.DetailJSON)
+		ids = append(ids, event.ID)
+	}
+	known, err := store.GetReportTimes(ctx, ids)
+	if err != nil {
+		return nil, fmt.Errorf("error comparing summary data with the database: %w", err)
+	}
+	pending := reportsToFetch(events, known)
+	result.ReportsFetched = len(pending)
+	result.Timings.Diff = time.Since(phase)
+
+	phase = time.Now()
+	reports := fetchReports(ctx, jma, pending)
+	result.Timings.Fetch = time.Since(phase)
+	if ctx.Err() != nil {
+		return result, ctx.Err()
+	}
+
+	// Webhooks are queued as changes are made; DeliverWebhooks sends them on its
+	// own schedule
+	phase = time.Now()
+	subscriptions, err := store.GetActiveWebhookSubscriptions(ctx)
+	if err != nil {
+		log.Printf("Error loading webhook subscriptions: %v", err)
+	}
+
+	// list.json is newest first; apply reports in the order JMA issued them so a
+	// cancellation always lands after the report it cancels.
+	for i := len(reports) - 1; i >= 0; i-- {
+		report := reports[i]
+		err := report.err
+		if err == nil {
+			err = applyReport(ctx, store, subscriptions, report.earthquake, result)
+		}
 		if err != nil {
-			log.Printf("Error fetching detailed data for ID %s: %v", event.ID, err)
-			continue
+			syncErr := &SyncError{ReportID: report.summary.ID, DetailJSON: report.summary.DetailJSON, Err: err}
+			log.Printf("Error syncing %v", syncErr)
+			result.Errors = append(result.Errors, syncErr)
 		}
+	}
+	result.Timings.Apply = time.Since(phase)
+	return result, nil
+}
 
-		earthquake, err := ParseDetailQuakeData(event.ID, detailData)
+// Helper function:
+// reportsToFetch keeps the list entries the store has not seen: reports for
+// unknown events and reports issued after the one the stored row reflects.
+// Entries without a readable report time are kept to be safe.
+func reportsToFetch(events []types.QuakeSummary, known map[string]time.Time) []types.QuakeSummary {
+	var pending []types.QuakeSummary
+	for _, event := range events {
+		stored, ok := known[event.ID]
+		if ok {
+			reportTime, err := time.Parse(time.RFC3339, event.ReportDateTime)
+			if err == nil && !reportTime.After(stored) {
+				continue
+			}
+		}
+		pending = append(pending, event)
+	}
+	return pending
+}
+
+// Helper function:
+// fetchReports fetches and parses the detail reports with jma.Concurrency
+// workers, returning them in the order given. Each failure is kept with its
+// report; once ctx is done the remaining reports are not fetched.
+func fetchReports(ctx context.Context, jma *JMAClient, summaries []types.QuakeSummary) []fetchedReport {
+	reports := make([]fetchedReport, len(summaries))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < max(jma.Concurrency, 1); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				report := &reports[i]
+				report.summary = summaries[i]
+				data, err := jma.FetchDetail(ctx, report.summary.DetailJSON)
+				if err != nil {
+					report.err = err
+					continue
+				}
+				report.earthquake, report.err = ParseDetailQuakeData(report.summary.ID, data)
+			}
+		}()
+	}
+
+feed:
+	for i := range summaries {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return reports
+}
+
+// Helper function:
+// applyReport records one fetched report and brings the earthquake row up to
+// date with it, counting the change in result. Reports older than the stored
+// row only add to the revision history.
+func applyReport(ctx context.Context, store db.EarthquakeStore, subscriptions []types.WebhookSubscription, earthquake *types.Earthquake, result *SyncResult) error {
+	err := store.InsertRevision(ctx, newRevision(earthquake))
+	if err != nil {
+		return fmt.Errorf("error recording revision: %w", err)
+	}
+
+	// Check if earthquake already exists
+	exists, err := store.EarthquakeExists(ctx, earthquake.ReportId)
+	if err != nil {
+		return err
+	}
+
+	if earthquake.InfoType == types.InfoTypeCancelled {
+		// A cancellation carries no earthquake data, only which event to retract
+		if !exists {
+			return nil
+		}
+		current, err := store.GetEarthquakeById(ctx, earthquake.ReportId, true)
+		if err != nil {
+			return err
+		}
+		if current.Retracted || !isNewerReport(earthquake, current) {
+			return nil
+		}
+		err = store.RetractEarthquake(ctx, earthquake)
+		if err != nil {
+			return err
+		}
+		log.Printf("Earthquake %s retracted by JMA", earthquake.ReportId)
+		result.RecordsRetracted++
+		current.Retracted = true
+		current.Serial, current.InfoType, current.ReportDateTime = earthquake.Serial, earthquake.InfoType, earthquake.ReportDateTime
+		loadStoredRegions(ctx, store, current)
+		queueWebhooks(ctx, store, subscriptions, Events.Publish(EventRetracted, current))
+		return nil
+	}
+
+	var eventType string
+	if !exists {
+		// Insert new earthquake
+		err = store.InsertEarthquake(ctx, earthquake)
 		if err != nil {
-			log.Printf("Error parsing data for ID %s: %v", event.ID, err)
-			continue
+			return err
 		}
+		result.RecordsAdded++
+		eventType = EventCreated
+	} else {
+		current, err := store.GetEarthquakeById(ctx, earthquake.ReportId, true)
+		if err != nil {
+			return err
+		}
+		if current.Retracted || !isNewerReport(earthquake, current) {
+			return nil
+		}
+		earthquake = mergeReport(current, earthquake)
+		err = store.UpdateEarthquake(ctx, earthquake)
+		if err != nil {
+			return err
+		}
+		result.RecordsUpdated++
+		eventType = EventUpdated
+	}
 
-		// Check if earthquake already exists
-		exists, err := db.EarthquakeExists(conn, earthquake.ReportId)
+	// Station observations and region intensities are stored separately from the
+	// earthquake row. Hypocenter-only reports carry none, so keep what we have.
+	if len(earthquake.Stations) > 0 {
+		err = store.InsertStationObservations(ctx, earthquake.ReportId, earthquake.Stations)
+		if err != nil {
+			log.Printf("Error inserting station observations for ID %s: %v", earthquake.ReportId, err)
+		}
+	}
+	if len(earthquake.Regions) > 0 {
+		err = store.InsertRegionIntensities(ctx, earthquake.ReportId, earthquake.Regions)
 		if err != nil {
-			// Log error but continue with next earthquake
-			continue
+			log.Printf("Error inserting region intensities for ID %s: %v", earthquake.ReportId, err)
 		}
+	}
+	loadStoredRegions(ctx, store, earthquake)
+	queueWebhooks(ctx, store, subscriptions, Events.Publish(eventType, earthquake))
+	return nil
+}
 
-		if !exists {
-			// Insert new earthquake
-			err = db.InsertEarthquake(conn, earthquake)
-			if err != nil {
-				// Log error but continue
-				continue
-			}
-			recordsAdded++ // Increment counter
+// Helper function:
+// loadStoredRegions fills in the region intensities stored for eq when its
+// report carried none, like a cancellation or a hypocenter-only report, so
+// subscribers filtering by prefecture or region still receive the event.
+func loadStoredRegions(ctx context.Context, store db.EarthquakeStore, eq *types.Earthquake) {
+	if len(eq.Regions) > 0 {
+		return
+	}
+	var regions []types.RegionIntensity
+	for _, level := range []string{types.RegionLevelPref, types.RegionLevelArea, types.RegionLevelCity} {
+		stored, err := store.GetRegionIntensities(ctx, eq.ReportId, level, true)
+		if err != nil {
+			log.Printf("Error loading region intensities for ID %s: %v", eq.ReportId, err)
+			return
 		}
+		regions = append(regions, stored...)
 	}
-	return recordsAdded, nil
+	eq.Regions = regions
 }
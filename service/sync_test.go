@@ -0,0 +1,265 @@
+package service
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Ward-R/Jishin-API/db"
+	"github.com/Ward-R/Jishin-API/types"
+)
+
+// stubList and stubDetail are trimmed copies of what JMA serves for one report.
+const stubList = `[{"eid": "20250812113450", "rdt": "2025-08-12T11:38:00+09:00", "json": "20250812113450_20250812113811_VXSE5k_1.json"}]`
+
+const stubDetail = `{
+	"Head": {
+		"Title": "震源・震度情報",
+		"ReportDateTime": "2025-08-12T11:38:00+09:00",
+		"EventID": "20250812113450",
+		"InfoType": "発表",
+		"Serial": "1"
+	},
+	"Body": {
+		"Earthquake": {
+			"OriginTime": "2025-08-12T11:34:00+09:00",
+			"ArrivalTime": "2025-08-12T11:34:00+09:00",
+			"Magnitude": "4.2",
+			"Hypocenter": {"Area": {"Name": "福島県沖", "enName": "Off the Coast of Fukushima", "Coordinate": "+37.5+141.6-50000/"}}
+		},
+		"Intensity": {"Observation": {"MaxInt": "3"}},
+		"Comments": {"ForecastComment": {"Code": "0215", "Text": "この地震による津波の心配はありません。"}}
+	}
+}`
+
+// stubJMA serves files from a map by path, the way JMA's data directory does,
+// and counts the requests for each path.
+type stubJMA struct {
+	*httptest.Server
+	mu    sync.Mutex
+	files map[string]string
+	hits  map[string]int
+}
+
+func newStubJMA(t *testing.T, files map[string]string) *stubJMA {
+	t.Helper()
+	stub := &stubJMA{files: files, hits: map[string]int{}}
+	stub.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stub.mu.Lock()
+		stub.hits[r.URL.Path]++
+		body, ok := stub.files[r.URL.Path]
+		stub.mu.Unlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(stub.Close)
+	return stub
+}
+
+// serve replaces the file at path.
+func (s *stubJMA) serve(path, body string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[path] = body
+}
+
+// requests returns how often path was requested.
+func (s *stubJMA) requests(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hits[path]
+}
+
+func TestSyncEarthquakesFromStub(t *testing.T) {
+	ctx := context.Background()
+	server := newStubJMA(t, map[string]string{
+		"/list.json": stubList,
+		"/20250812113450_20250812113811_VXSE5k_1.json": stubDetail,
+	})
+	store := db.NewMemoryStore()
+
+	result, err := SyncEarthquakes(ctx, store, testJMAClient(server.Server))
+	if err != nil {
+		t.Fatalf("SyncEarthquakes: %v", err)
+	}
+	if result.RecordsAdded != 1 || result.RecordsUpdated != 0 || result.RecordsRetracted != 0 {
+		t.Errorf("result = %+v, want one record added", result)
+	}
+
+	quake, err := store.GetEarthquakeById(ctx, "20250812113450", false)
+	if err != nil {
+		t.Fatalf("GetEarthquakeById: %v", err)
+	}
+	if quake.Magnitude != 4.2 || quake.Latitude != 37.5 || quake.EnLocation != "Off the Coast of Fukushima" {
+		t.Errorf("stored %+v, want the stub report", quake)
+	}
+
+	// The same list again changes nothing
+	result, err = SyncEarthquakes(ctx, store, testJMAClient(server.Server))
+	if err != nil {
+		t.Fatalf("second SyncEarthquakes: %v", err)
+	}
+	if result.RecordsAdded+result.RecordsUpdated+result.RecordsRetracted != 0 {
+		t.Errorf("second sync = %+v, want no changes", result)
+	}
+	if n := server.requests("/20250812113450_20250812113811_VXSE5k_1.json"); n != 1 {
+		t.Errorf("detail fetched %d times, want once", n)
+	}
+}
+
+func TestSyncEarthquakesFetchesOnlyNewReports(t *testing.T) {
+	ctx := context.Background()
+	first := "/20250812113450_20250812113811_VXSE5k_1.json"
+	server := newStubJMA(t, map[string]string{"/list.json": stubList, first: stubDetail})
+	store := db.NewMemoryStore()
+	if _, err := SyncEarthquakes(ctx, store, testJMAClient(server.Server)); err != nil {
+		t.Fatalf("SyncEarthquakes: %v", err)
+	}
+
+	// JMA revises the magnitude and lists a new earthquake whose report is gone
+	revised := strings.NewReplacer(
+		`"2025-08-12T11:38:00+09:00"`, `"2025-08-12T11:45:00+09:00"`,
+		`"Serial": "1"`, `"Serial": "2"`,
+		`"4.2"`, `"4.5"`,
+	).Replace(stubDetail)
+	server.serve("/20250812113450_20250812114511_VXSE5k_2.json", revised)
+	server.serve("/list.json", `[
+		{"eid": "20250812120000", "rdt": "2025-08-12T12:03:00+09:00", "json": "20250812120000_20250812120311_VXSE5k_1.json"},
+		{"eid": "20250812113450", "rdt": "2025-08-12T11:45:00+09:00", "json": "20250812113450_20250812114511_VXSE5k_2.json"},
+		{"eid": "20250812113450", "rdt": "2025-08-12T11:38:00+09:00", "json": "20250812113450_20250812113811_VXSE5k_1.json"}
+	]`)
+
+	jma := testJMAClient(server.Server)
+	jma.MaxRetries = 0
+	result, err := SyncEarthquakes(ctx, store, jma)
+	if err != nil {
+		t.Fatalf("SyncEarthquakes: %v", err)
+	}
+	if result.ReportsListed != 3 || result.ReportsFetched != 2 || result.RecordsUpdated != 1 || result.RecordsAdded != 0 {
+		t.Errorf("result = %+v, want 2 of 3 reports fetched and one record updated", result)
+	}
+	if n := server.requests(first); n != 1 {
+		t.Errorf("known report fetched %d times, want once", n)
+	}
+	if len(result.Errors) != 1 || result.Errors[0].ReportID != "20250812120000" {
+		t.Errorf("errors = %v, want the missing report only", result.Errors)
+	}
+	if quake, err := store.GetEarthquakeById(ctx, "20250812113450", false); err != nil || quake.Magnitude != 4.5 {
+		t.Errorf("GetEarthquakeById = %+v, %v; want the revised magnitude", quake, err)
+	}
+}
+
+func TestSyncEarthquakesMergesReportKinds(t *testing.T) {
+	ctx := context.Background()
+	flash := `{
+		"Head": {"Title": "震度速報", "ReportDateTime": "2025-08-12T11:36:00+09:00", "EventID": "20250812113450", "InfoType": "発表", "Serial": "1"},
+		"Body": {"Intensity": {"Observation": {"MaxInt": "3"}}}
+	}`
+	hypocenter := `{
+		"Head": {"Title": "震源に関する情報", "ReportDateTime": "2025-08-12T11:38:00+09:00", "EventID": "20250812113450", "InfoType": "発表", "Serial": "1"},
+		"Body": {
+			"Earthquake": {
+				"OriginTime": "2025-08-12T11:34:00+09:00",
+				"Magnitude": "4.2",
+				"Hypocenter": {"Area": {"Name": "福島県沖", "enName": "Off the Coast of Fukushima", "Coordinate": "+37.5+141.6-50000/"}}
+			},
+			"Comments": {"ForecastComment": {"Code": "0215", "Text": "この地震による津波の心配はありません。"}}
+		}
+	}`
+	server := newStubJMA(t, map[string]string{
+		"/list.json":       `[{"eid": "20250812113450", "rdt": "2025-08-12T11:36:00+09:00", "json": "flash.json"}]`,
+		"/flash.json":      flash,
+		"/hypocenter.json": hypocenter,
+	})
+	store := db.NewMemoryStore()
+	if _, err := SyncEarthquakes(ctx, store, testJMAClient(server.Server)); err != nil {
+		t.Fatalf("SyncEarthquakes: %v", err)
+	}
+
+	server.serve("/list.json", `[
+		{"eid": "20250812113450", "rdt": "2025-08-12T11:38:00+09:00", "json": "hypocenter.json"},
+		{"eid": "20250812113450", "rdt": "2025-08-12T11:36:00+09:00", "json": "flash.json"}
+	]`)
+	result, err := SyncEarthquakes(ctx, store, testJMAClient(server.Server))
+	if err != nil {
+		t.Fatalf("SyncEarthquakes: %v", err)
+	}
+	if result.RecordsUpdated != 1 {
+		t.Errorf("result = %+v, want one record updated", result)
+	}
+
+	quake, err := store.GetEarthquakeById(ctx, "20250812113450", false)
+	if err != nil {
+		t.Fatalf("GetEarthquakeById: %v", err)
+	}
+	if quake.MaxIntensity != "3" || quake.Magnitude != 4.2 || quake.Latitude != 37.5 || quake.OriginTime.IsZero() {
+		t.Errorf("stored %+v, want the flash's intensity with the hypocenter report's magnitude and location", quake)
+	}
+}
+
+func TestSyncEarthquakesPublishesStoredRegions(t *testing.T) {
+	ctx := context.Background()
+	full := strings.Replace(stubDetail, `"Intensity": {"Observation": {"MaxInt": "3"}}`,
+		`"Intensity": {"Observation": {"MaxInt": "3", "Pref": [{"Code": "07", "Name": "福島県", "enName": "Fukushima", "MaxInt": "3",
+			"Area": [{"Code": "250", "Name": "福島県中通り", "enName": "Fukushima Nakadori", "MaxInt": "3"}]}]}}`, 1)
+	hypocenter := strings.NewReplacer(
+		`"2025-08-12T11:38:00+09:00"`, `"2025-08-12T11:45:00+09:00"`,
+		`"Intensity": {"Observation": {"MaxInt": "3"}},`, ``,
+		`"4.2"`, `"4.4"`,
+	).Replace(stubDetail)
+	cancel := `{"Head": {"ReportDateTime": "2025-08-12T11:50:00+09:00", "EventID": "20250812113450", "InfoType": "取消", "Serial": "3"}, "Body": {}}`
+
+	server := newStubJMA(t, map[string]string{
+		"/list.json":       `[{"eid": "20250812113450", "rdt": "2025-08-12T11:38:00+09:00", "json": "full.json"}]`,
+		"/full.json":       full,
+		"/hypocenter.json": hypocenter,
+		"/cancel.json":     cancel,
+	})
+	store := db.NewMemoryStore()
+	sub := &types.WebhookSubscription{Id: "sub", URL: "http://127.0.0.1:1/hook", Secret: "secret", Prefectures: []string{"Fukushima"}, Active: true}
+	if err := store.InsertWebhookSubscription(ctx, sub); err != nil {
+		t.Fatalf("InsertWebhookSubscription: %v", err)
+	}
+	_, events, stop := Events.Subscribe(math.MaxUint64)
+	defer stop()
+
+	lists := []string{
+		`[{"eid": "20250812113450", "rdt": "2025-08-12T11:38:00+09:00", "json": "full.json"}]`,
+		`[{"eid": "20250812113450", "rdt": "2025-08-12T11:45:00+09:00", "json": "hypocenter.json"}]`,
+		`[{"eid": "20250812113450", "rdt": "2025-08-12T11:50:00+09:00", "json": "cancel.json"}]`,
+	}
+	for _, list := range lists {
+		server.serve("/list.json", list)
+		if _, err := SyncEarthquakes(ctx, store, testJMAClient(server.Server)); err != nil {
+			t.Fatalf("SyncEarthquakes: %v", err)
+		}
+	}
+
+	for _, want := range []string{EventCreated, EventUpdated, EventRetracted} {
+		event := <-events
+		if event.Type != want || len(event.Earthquake.Regions) != 2 || event.Earthquake.Regions[0].Code != "07" {
+			t.Errorf("published %s with regions %+v, want %s with the stored prefecture and area", event.Type, event.Earthquake.Regions, want)
+		}
+	}
+	deliveries, err := store.GetWebhookDeliveries(ctx, "sub", 10)
+	if err != nil {
+		t.Fatalf("GetWebhookDeliveries: %v", err)
+	}
+	if len(deliveries) != 3 {
+		t.Errorf("queued %d deliveries for the Fukushima subscription, want created, updated and retracted", len(deliveries))
+	}
+	// Delivery runs on its own schedule, not inside the sync
+	for _, d := range deliveries {
+		if d.Status != types.DeliveryPending || d.Attempts != 0 {
+			t.Errorf("sync attempted delivery %d: status %s after %d attempts", d.Id, d.Status, d.Attempts)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Ward-R/Jishin-API/db"
+	"github.com/Ward-R/Jishin-API/types"
+)
+
+// Headers sent with every webhook delivery.
+const (
+	// WebhookSignatureHeader carries "t=<unix time>,v1=<hex HMAC-SHA256>", the
+	// HMAC of "<unix time>.<body>" keyed with the subscription secret.
+	WebhookSignatureHeader = "X-Jishin-Signature"
+	WebhookEventHeader     = "X-Jishin-Event"
+	WebhookDeliveryHeader  = "X-Jishin-Delivery"
+)
+
+const (
+	// webhookMaxAttempts is how often a delivery is tried before the endpoint
+	// is considered dead and its subscription disabled.
+	webhookMaxAttempts = 8
+	// webhookBaseBackoff is the delay before the first retry; it doubles with
+	// every further attempt up to webhookMaxBackoff.
+	webhookBaseBackoff = 30 * time.Second
+	webhookMaxBackoff  = time.Hour
+	webhookTimeout     = 10 * time.Second
+	// webhookBatchSize caps the deliveries attempted by one DeliverWebhooks.
+	webhookBatchSize = 100
+	// webhookConcurrency is how many endpoints are called at once.
+	webhookConcurrency = 8
+	// webhookLease is how long claimed deliveries are held back from other
+	// runs. It outlasts a full batch of webhookTimeout requests, after which a
+	// run that died without recording them gives them up.
+	webhookLease = 5 * time.Minute
+)
+
+// webhookPayload is the JSON body of a delivery.
+type webhookPayload struct {
+	Type       string           `json:"type"`
+	EventId    uint64           `json:"event_id"`
+	CreatedAt  time.Time        `json:"created_at"`
+	Earthquake types.Earthquake `json:"earthquake"`
+}
+
+// SignWebhook returns the signature header value for body sent at timestamp.
+func SignWebhook(secret string, timestamp time.Time, body []byte) string {
+	unix := strconv.FormatInt(timestamp.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unix + "."))
+	mac.Write(body)
+	return "t=" + unix + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Helper function:
+// webhookBackoff is the delay after the given failed attempt, counting from 1.
+func webhookBackoff(attempt int) time.Duration {
+	backoff := webhookBaseBackoff
+	for i := 1; i < attempt && backoff < webhookMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > webhookMaxBackoff {
+		return webhookMaxBackoff
+	}
+	return backoff
+}
+
+// Helper function:
+// queueWebhooks queues event for every subscription it matches.
+func queueWebhooks(ctx context.Context, store db.EarthquakeStore, subscriptions []types.WebhookSubscription, event Event) {
+	var payload []byte
+	for i := range subscriptions {
+		sub := &subscriptions[i]
+		if !sub.Matches(&event.Earthquake) {
+			continue
+		}
+		if payload == nil {
+			var err error
+			payload, err = json.Marshal(webhookPayload{
+				Type:       event.Type,
+				EventId:    event.ID,
+				CreatedAt:  time.Now().UTC(),
+				Earthquake: event.Earthquake,
+			})
+			if err != nil {
+				log.Printf("Error encoding webhook payload for %s: %v", event.Earthquake.ReportId, err)
+				return
+			}
+		}
+		err := store.InsertWebhookDelivery(ctx, &types.WebhookDelivery{
+			SubscriptionId: sub.Id,
+			EventType:      event.Type,
+			ReportId:       event.Earthquake.ReportId,
+			Payload:        string(payload),
+		})
+		if err != nil {
+			log.Printf("Error queueing webhook for subscription %s: %v", sub.Id, err)
+		}
+	}
+}
+
+// WebhookResult counts the outcome of one DeliverWebhooks run.
+type WebhookResult struct {
+	Delivered int
+	Retrying  int
+	Failed    int
+}
+
+// DeliverWebhooks claims the deliveries that are due and attempts them. Endpoints
+// are called concurrently and each outcome is recorded as soon as its request
+// returns. A delivery that fails every attempt disables its subscription.
+func DeliverWebhooks(ctx context.Context, store db.EarthquakeStore) (*WebhookResult, error) {
+	deliveries, err := store.ClaimDueWebhookDeliveries(ctx, webhookBatchSize, webhookLease)
+	if err != nil {
+		return nil, fmt.Errorf("error claiming due webhook deliveries: %w", err)
+	}
+
+	result := &WebhookResult{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, webhookConcurrency)
+	for i := range deliveries {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(d *db.DueWebhookDelivery) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			d.Attempts++
+			status, err := postWebhook(ctx, d)
+			d.LastStatusCode = status
+			if err != nil {
+				d.LastError = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			recordWebhookOutcome(ctx, store, d, result)
+		}(&deliveries[i])
+	}
+	wg.Wait()
+	return result, nil
+}
+
+// Helper function:
+// recordWebhookOutcome stores the outcome of an attempted delivery and counts
+// it in result. It still records when ctx is done, so a cancelled run does not
+// send a delivery that already succeeded again.
+func recordWebhookOutcome(ctx context.Context, store db.EarthquakeStore, d *db.DueWebhookDelivery, result *WebhookResult) {
+	ctx = context.WithoutCancel(ctx)
+	var err error
+	if d.LastError == "" {
+		result.Delivered++
+		err = store.RecordWebhookSuccess(ctx, &d.WebhookDelivery)
+	} else if d.Attempts >= webhookMaxAttempts {
+		result.Failed++
+		log.Printf("Disabling webhook subscription %s after %d failed attempts: %s", d.SubscriptionId, d.Attempts, d.LastError)
+		err = store.RecordWebhookFailure(ctx, &d.WebhookDelivery, time.Time{})
+	} else {
+		result.Retrying++
+		err = store.RecordWebhookFailure(ctx, &d.WebhookDelivery, time.Now().Add(webhookBackoff(d.Attempts)))
+	}
+	if err != nil {
+		log.Printf("Error recording webhook delivery %d: %v", d.Id, err)
+	}
+}
+
+// Helper function:
+// postWebhook sends one delivery, returning the response status. Anything but
+// a 2xx response is an error.
+func postWebhook(ctx context.Context, d *db.DueWebhookDelivery) (int, error) {
+	body := []byte(d.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("invalid url: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Jishin-API-Webhooks/1.0")
+	req.Header.Set(WebhookEventHeader, d.EventType)
+	req.Header.Set(WebhookDeliveryHeader, strconv.FormatInt(d.Id, 10))
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(d.Secret, time.Now(), body))
+
+	res, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("endpoint responded %s", res.Status)
+	}
+	return res.StatusCode, nil
+}
+
+// webhookClient does not follow redirects and, unless WEBHOOK_ALLOW_PRIVATE is
+// set, refuses to connect to loopback, private and link-local addresses, so a
+// subscription cannot be used to reach the API's own network.
+var webhookClient = &http.Client{
+	Timeout: webhookTimeout,
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: webhookTimeout,
+			Control: refusePrivateAddresses,
+		}).DialContext,
+		TLSHandshakeTimeout: webhookTimeout,
+		MaxIdleConnsPerHost: 2,
+	},
+}
+
+// errPrivateAddress is returned when a webhook URL resolves to a private address.
+var errPrivateAddress = errors.New("webhook url resolves to a private address")
+
+// Helper function:
+// refusePrivateAddresses is a net.Dialer Control that rejects non-public IPs.
+// It runs after DNS resolution, so a hostname cannot be re-pointed later.
+func refusePrivateAddresses(network, address string, _ syscall.RawConn) error {
+	if os.Getenv("WEBHOOK_ALLOW_PRIVATE") == "true" {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsUnspecified() || ip.IsMulticast() {
+		return errPrivateAddress
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Ward-R/Jishin-API/db"
+	"github.com/Ward-R/Jishin-API/types"
+)
+
+func TestDeliverWebhooksClaimsOnce(t *testing.T) {
+	t.Setenv("WEBHOOK_ALLOW_PRIVATE", "true")
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		time.Sleep(50 * time.Millisecond)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	store := db.NewMemoryStore()
+	sub := &types.WebhookSubscription{Id: "sub", URL: server.URL, Secret: "secret", Active: true}
+	if err := store.InsertWebhookSubscription(ctx, sub); err != nil {
+		t.Fatalf("InsertWebhookSubscription: %v", err)
+	}
+	delivery := &types.WebhookDelivery{SubscriptionId: "sub", EventType: EventCreated, ReportId: "q1", Payload: `{}`}
+	if err := store.InsertWebhookDelivery(ctx, delivery); err != nil {
+		t.Fatalf("InsertWebhookDelivery: %v", err)
+	}
+
+	// Overlapping runs, as from two replicas, send the delivery once
+	var wg sync.WaitGroup
+	var delivered atomic.Int32
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := DeliverWebhooks(ctx, store)
+			if err != nil {
+				t.Errorf("DeliverWebhooks: %v", err)
+				return
+			}
+			delivered.Add(int32(result.Delivered))
+		}()
+	}
+	wg.Wait()
+
+	if n := calls.Load(); n != 1 || delivered.Load() != 1 {
+		t.Errorf("endpoint called %d times and %d deliveries counted, want once", n, delivered.Load())
+	}
+	log, err := store.GetWebhookDeliveries(ctx, "sub", 10)
+	if err != nil {
+		t.Fatalf("GetWebhookDeliveries: %v", err)
+	}
+	if len(log) != 1 || log[0].Status != types.DeliveryDelivered || log[0].Attempts != 1 {
+		t.Errorf("delivery log = %+v, want one delivered attempt", log)
+	}
+}
@@ -0,0 +1,46 @@
+package types
+
+// IntensityScale lists the JMA seismic intensity classes (震度) from weakest to
+// strongest, as they appear in the detail reports. Lower (弱) and upper (強)
+// classes are written with "-" and "+".
+var IntensityScale = []string{"0", "1", "2", "3", "4", "5-", "5+", "6-", "6+", "7"}
+
+// intensityAliases maps the Japanese spellings to the report form.
+var intensityAliases = map[string]string{
+	"5弱": "5-",
+	"5強": "5+",
+	"6弱": "6-",
+	"6強": "6+",
+}
+
+// NormalizeIntensity returns the IntensityScale form of an intensity, accepting
+// the Japanese 弱/強 spellings, and false if it is not a JMA intensity.
+func NormalizeIntensity(intensity string) (string, bool) {
+	if alias, ok := intensityAliases[intensity]; ok {
+		intensity = alias
+	}
+	if IntensityRank(intensity) < 0 {
+		return "", false
+	}
+	return intensity, true
+}
+
+// IntensityRank returns the position of an intensity in IntensityScale, so
+// intensities can be compared, or -1 if it is unknown.
+func IntensityRank(intensity string) int {
+	for i, v := range IntensityScale {
+		if v == intensity {
+			return i
+		}
+	}
+	return -1
+}
+
+// IntensitiesAtLeast returns every intensity class at or above min.
+func IntensitiesAtLeast(min string) []string {
+	rank := IntensityRank(min)
+	if rank < 0 {
+		return nil
+	}
+	return append([]string(nil), IntensityScale[rank:]...)
+}
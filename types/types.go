@@ -19,18 +19,97 @@ type Earthquake struct {
 	JpComment    string
 	EnComment    string
 	TsunamiRisk  string
+	// Tsunami is set when JMA's forecast comment says a tsunami or sea-level
+	// change may follow. TsunamiWarning narrows it to a tsunami warning or
+	// advisory (津波警報等) being in effect.
+	Tsunami        bool
+	TsunamiWarning bool
+
+	// Serial, InfoType and ReportDateTime identify which JMA report revision
+	// the row currently reflects.
+	Serial         int
+	InfoType       string
+	ReportDateTime time.Time
+	// Retracted is set once JMA cancels (取消) the event.
+	Retracted bool
+
+	// Stations holds the per-station observations parsed from the detail
+	// report. They are stored in their own tables, not on the earthquake row.
+	Stations []StationObservation `json:"-"`
+	// Regions holds the maximum intensity per prefecture, area and city.
+	Regions []RegionIntensity `json:"-"`
+}
+
+// Values of the JMA report Head.InfoType.
+const (
+	InfoTypeIssued     = "発表"
+	InfoTypeCorrection = "訂正"
+	InfoTypeCancelled  = "取消"
+)
+
+// Administrative levels of the JMA intensity observation tree.
+const (
+	RegionLevelPref = "pref"
+	RegionLevelArea = "area"
+	RegionLevelCity = "city"
+)
+
+// RegionIntensity is the maximum seismic intensity JMA reported for one
+// prefecture, area or city. ParentCode links an area to its prefecture and a
+// city to its area.
+type RegionIntensity struct {
+	ReportId     string
+	Level        string
+	Code         string
+	ParentCode   string
+	JpName       string
+	EnName       string
+	MaxIntensity string
+}
+
+// StationObservation is the seismic intensity recorded at a single JMA
+// observation station for one earthquake report.
+type StationObservation struct {
+	ReportId    string
+	StationCode string
+	JpName      string
+	EnName      string
+	Intensity   string
+	Latitude    float64
+	Longitude   float64
+	PrefCode    string
+	AreaCode    string
+	CityCode    string
+}
+
+// Revision is one JMA report issued for an earthquake event, kept so clients
+// can see how the magnitude and hypocenter evolved between reports.
+type Revision struct {
+	EventId        string
+	Serial         int
+	InfoType       string
+	ReportDateTime time.Time
+	OriginTime     time.Time
+	Magnitude      float64
+	DepthKm        int
+	Latitude       float64
+	Longitude      float64
+	MaxIntensity   string
+	JpLocation     string
+	EnLocation     string
 }
 
 // QuakeSummary holds the data from the list of earthquakes.
 type QuakeSummary struct {
-	ID         string `json:"eid"`
-	DetailJSON string `json:"json"`
+	ID             string `json:"eid"`
+	DetailJSON     string `json:"json"`
+	ReportDateTime string `json:"rdt"`
 }
 
 // DetailQuakeReport is the intermediate struct for parsing the detailed JSON.
 type DetailQuakeReport struct {
 	Control json.RawMessage `json:"Control"`
-	Head    json.RawMessage `json:"Head"`
+	Head    JsonHead        `json:"Head"`
 	Body    struct {
 		Earthquake JsonEarthquake `json:"Earthquake"`
 		Intensity  JsonIntensity  `json:"Intensity"`
@@ -38,6 +117,17 @@ type DetailQuakeReport struct {
 	} `json:"Body"`
 }
 
+// JsonHead identifies the report: which event it belongs to, its serial
+// number and whether it is an issue (発表), correction (訂正) or cancellation (取消).
+type JsonHead struct {
+	Title          string `json:"Title"`
+	EnTitle        string `json:"enTitle"`
+	ReportDateTime string `json:"ReportDateTime"`
+	EventID        string `json:"EventID"`
+	InfoType       string `json:"InfoType"`
+	Serial         string `json:"Serial"`
+}
+
 // JsonEarthquake contains the core quake info, matching the JSON exactly.
 type JsonEarthquake struct {
 	OriginTime  string `json:"OriginTime"`
@@ -66,9 +156,51 @@ type JsonComments struct {
 	} `json:"VarComment"`
 }
 
-// JsonIntensity is for getting the maximum seismic intensity.
+// JsonIntensity is for getting the maximum seismic intensity and the
+// Pref -> Area -> City -> IntensityStation observation tree.
 type JsonIntensity struct {
 	Observation struct {
-		MaxIntensity string `json:"MaxInt"`
+		MaxIntensity string     `json:"MaxInt"`
+		Pref         []JsonPref `json:"Pref"`
 	} `json:"Observation"`
 }
+
+// JsonPref is a prefecture in the intensity observation tree.
+type JsonPref struct {
+	JpName string     `json:"Name"`
+	EnName string     `json:"enName"`
+	Code   string     `json:"Code"`
+	MaxInt string     `json:"MaxInt"`
+	Area   []JsonArea `json:"Area"`
+}
+
+// JsonArea is a forecast area within a prefecture.
+type JsonArea struct {
+	JpName string     `json:"Name"`
+	EnName string     `json:"enName"`
+	Code   string     `json:"Code"`
+	MaxInt string     `json:"MaxInt"`
+	City   []JsonCity `json:"City"`
+}
+
+// JsonCity is a municipality within an area. Seismic intensity flash reports
+// stop at the area level, so City may be empty.
+type JsonCity struct {
+	JpName           string                 `json:"Name"`
+	EnName           string                 `json:"enName"`
+	Code             string                 `json:"Code"`
+	MaxInt           string                 `json:"MaxInt"`
+	IntensityStation []JsonIntensityStation `json:"IntensityStation"`
+}
+
+// JsonIntensityStation is a single observation station and its reading.
+type JsonIntensityStation struct {
+	JpName string `json:"Name"`
+	EnName string `json:"enName"`
+	Code   string `json:"Code"`
+	Int    string `json:"Int"`
+	LatLon struct {
+		Lat float64 `json:"lat"`
+		Lon float64 `json:"lon"`
+	} `json:"latlon"`
+}
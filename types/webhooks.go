@@ -0,0 +1,81 @@
+package types
+
+import (
+	"strings"
+	"time"
+)
+
+// WebhookSubscription is a callback URL that is sent the earthquakes matching
+// its filters. Empty filters match every earthquake.
+type WebhookSubscription struct {
+	Id  string
+	URL string
+	// Secret signs every delivery. It is only shown when the subscription is
+	// created.
+	Secret       string `json:"-"`
+	MinMagnitude *float64
+	MinIntensity string
+	// Prefectures match a prefecture that observed shaking, by JMA code or by
+	// Japanese or English name.
+	Prefectures []string
+	// TsunamiOnly keeps earthquakes whose forecast comment warns of a tsunami.
+	TsunamiOnly bool
+
+	// Active is cleared once the endpoint has failed a delivery on every retry.
+	Active              bool
+	ConsecutiveFailures int
+	DisabledAt          *time.Time
+	CreatedAt           time.Time
+}
+
+// Matches reports whether eq passes the subscription's filters.
+func (s *WebhookSubscription) Matches(eq *Earthquake) bool {
+	if s.MinMagnitude != nil && eq.Magnitude < *s.MinMagnitude {
+		return false
+	}
+	if s.MinIntensity != "" && IntensityRank(eq.MaxIntensity) < IntensityRank(s.MinIntensity) {
+		return false
+	}
+	if s.TsunamiOnly && !eq.Tsunami {
+		return false
+	}
+	if len(s.Prefectures) == 0 {
+		return true
+	}
+	for _, region := range eq.Regions {
+		if region.Level != RegionLevelPref {
+			continue
+		}
+		for _, pref := range s.Prefectures {
+			if pref == region.Code || pref == region.JpName || strings.EqualFold(pref, region.EnName) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Statuses of a webhook delivery.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// WebhookDelivery is one event queued for a subscription, and the outcome of
+// its latest attempt.
+type WebhookDelivery struct {
+	Id             int64
+	SubscriptionId string
+	EventType      string
+	ReportId       string
+	// Payload is the JSON body, fixed when the delivery is queued.
+	Payload        string `json:"-"`
+	Status         string
+	Attempts       int
+	NextAttemptAt  time.Time
+	LastStatusCode int
+	LastError      string
+	CreatedAt      time.Time
+	DeliveredAt    *time.Time
+}